@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/kvinwang/dstack-mr/pkg/tdxmeasure"
+)
+
+// aggregateFlags are the flags defining the custom aggregates reported alongside mr_enclave and
+// mr_image.
+type aggregateFlags struct {
+	exprs []*tdxmeasure.Aggregate
+	path  string
+}
+
+func (a *aggregateFlags) register(fs *flag.FlagSet) {
+	fs.Func("aggregate", "Custom aggregate digest to report, as name=hash(input, ...) (repeatable)", func(value string) error {
+		agg, err := tdxmeasure.ParseAggregate(value)
+		if err != nil {
+			return err
+		}
+		a.exprs = append(a.exprs, agg)
+		return nil
+	})
+	fs.StringVar(&a.path, "aggregates", "", "Path to a JSON or YAML list of custom aggregate definitions")
+}
+
+// aggregates returns the aggregates of the -aggregates file followed by the -aggregate ones.
+func (a *aggregateFlags) aggregates() ([]*tdxmeasure.Aggregate, error) {
+	var aggregates []*tdxmeasure.Aggregate
+	if a.path != "" {
+		data, err := os.ReadFile(a.path)
+		if err != nil {
+			return nil, fmt.Errorf("reading aggregates file: %w", err)
+		}
+		if data, err = yamlToJSON(data); err != nil {
+			return nil, fmt.Errorf("parsing aggregates file: %w", err)
+		}
+		if err := json.Unmarshal(data, &aggregates); err != nil {
+			return nil, fmt.Errorf("parsing aggregates file: %w", err)
+		}
+	}
+	aggregates = append(aggregates, a.exprs...)
+	if err := checkAggregates(aggregates); err != nil {
+		return nil, err
+	}
+	return aggregates, nil
+}
+
+// checkAggregates checks that the aggregates have distinct names, which don't shadow the
+// built-in ones.
+func checkAggregates(aggregates []*tdxmeasure.Aggregate) error {
+	names := map[string]bool{
+		tdxmeasure.MrEnclaveAggregate.Name: true,
+		tdxmeasure.MrImageAggregate.Name:   true,
+	}
+	for _, a := range aggregates {
+		if a == nil {
+			return errors.New("empty aggregate definition")
+		}
+		if names[a.Name] {
+			return fmt.Errorf("aggregate %s is defined more than once", a.Name)
+		}
+		names[a.Name] = true
+	}
+	return nil
+}
+
+// computeAggregates calculates the aggregates of the measurements, by name. The key provider
+// measurement must be valid.
+func computeAggregates(aggregates []*tdxmeasure.Aggregate, m *tdxmeasure.Measurements, mrKeyProvider string) map[string]string {
+	if len(aggregates) == 0 {
+		return nil
+	}
+	mrKeyProviderBytes, _ := tdxmeasure.ParseKeyProvider(mrKeyProvider)
+	values := make(map[string]string, len(aggregates))
+	for _, a := range aggregates {
+		value, _ := a.Compute(m, mrKeyProviderBytes)
+		values[a.Name] = fmt.Sprintf("%x", value)
+	}
+	return values
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"sync"
+
+	"github.com/kvinwang/dstack-mr/pkg/tdxmeasure"
+)
+
+// batchManifest lists the images to measure and the configurations to measure each of them
+// with: every combination of memory size, CPU count and key provider.
+type batchManifest struct {
+	Images       []batchImage          `json:"images"`
+	Memory       []manifestSize        `json:"memory"`
+	CPU          []uint                `json:"cpu"`
+	KeyProviders []manifestKeyProvider `json:"key_providers"`
+	// Aggregates are the custom aggregates reported for each result.
+	Aggregates []*tdxmeasure.Aggregate `json:"aggregates"`
+}
+
+// batchImage describes an image either by its metadata.json or by its files. Paths are relative
+// to the manifest.
+type batchImage struct {
+	Name     string `json:"name"`
+	Metadata string `json:"metadata"`
+	Firmware string `json:"fw"`
+	Kernel   string `json:"kernel"`
+	Initrd   string `json:"initrd"`
+	Cmdline  string `json:"cmdline"`
+}
+
+// manifestSize is a memory size in QEMU -m syntax, given as a string or a number of MiB.
+type manifestSize uint64
+
+func (s *manifestSize) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		value = string(data)
+	}
+	size, err := parseMemoryOption(value)
+	if err != nil {
+		return err
+	}
+	*s = manifestSize(size)
+	return nil
+}
+
+// manifestKeyProvider is a key provider measurement, given as hex or as a key provider
+// descriptor.
+type manifestKeyProvider struct {
+	name        string
+	measurement string
+}
+
+func (k *manifestKeyProvider) UnmarshalJSON(data []byte) error {
+	var measurement string
+	if err := json.Unmarshal(data, &measurement); err == nil {
+		if _, err := tdxmeasure.ParseKeyProvider(measurement); err != nil {
+			return err
+		}
+		*k = manifestKeyProvider{measurement: measurement}
+		return nil
+	}
+	info, err := tdxmeasure.ParseKeyProviderInfo(data)
+	if err != nil {
+		return err
+	}
+	*k = manifestKeyProvider{name: info.Name, measurement: hex.EncodeToString(info.Measurement())}
+	return nil
+}
+
+type batchResult struct {
+	Image       string `json:"image"`
+	Version     string `json:"version,omitempty"`
+	GitRevision string `json:"git_revision,omitempty"`
+	Memory      string `json:"memory"`
+	MemorySize  uint64 `json:"memory_size"`
+	CPUCount    uint   `json:"cpu"`
+	KeyProvider string `json:"key_provider"`
+	// KeyProviderName is the name of the key provider descriptor, if any.
+	KeyProviderName string `json:"key_provider_name,omitempty"`
+	MRTD            string `json:"mrtd,omitempty"`
+	RTMR0           string `json:"rtmr0,omitempty"`
+	RTMR1           string `json:"rtmr1,omitempty"`
+	RTMR2           string `json:"rtmr2,omitempty"`
+	MrEnclave       string `json:"mr_enclave,omitempty"`
+	MrImage         string `json:"mr_image,omitempty"`
+	// Aggregates holds the custom aggregates of the manifest, by name.
+	Aggregates map[string]string `json:"aggregates,omitempty"`
+	Error      string            `json:"error,omitempty"`
+}
+
+// readBatchManifest reads a JSON or YAML manifest and fills in the defaults.
+func readBatchManifest(path string) (*batchManifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading manifest file: %w", err)
+	}
+	if data, err = yamlToJSON(data); err != nil {
+		return nil, fmt.Errorf("parsing manifest file: %w", err)
+	}
+	var manifest batchManifest
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("parsing manifest file: %w", err)
+	}
+
+	if len(manifest.Images) == 0 {
+		return nil, errors.New("manifest lists no images")
+	}
+	if len(manifest.Memory) == 0 {
+		manifest.Memory = []manifestSize{2 << 30}
+	}
+	if len(manifest.CPU) == 0 {
+		manifest.CPU = []uint{1}
+	}
+	for _, cpu := range manifest.CPU {
+		if cpu < 1 || cpu > tdxmeasure.MaxCPUCount {
+			return nil, fmt.Errorf("invalid CPU count %d (must be between 1 and %d)", cpu, tdxmeasure.MaxCPUCount)
+		}
+	}
+	if err := checkAggregates(manifest.Aggregates); err != nil {
+		return nil, err
+	}
+	if len(manifest.KeyProviders) == 0 {
+		manifest.KeyProviders = []manifestKeyProvider{{measurement: defaultMrKeyProvider}}
+	}
+
+	// Paths are relative to the manifest.
+	dir := filepath.Dir(path)
+	for i := range manifest.Images {
+		img := &manifest.Images[i]
+		for _, p := range []*string{&img.Metadata, &img.Firmware, &img.Kernel, &img.Initrd} {
+			if *p != "" && !filepath.IsAbs(*p) {
+				*p = filepath.Join(dir, *p)
+			}
+		}
+		if img.Name == "" {
+			img.Name = cmp.Or(img.Metadata, img.Kernel)
+		}
+	}
+	return &manifest, nil
+}
+
+// batchImageMeasurer measures an image for the configurations of the manifest.
+type batchImageMeasurer struct {
+	metadata   *DStackMetadata
+	measurer   *tdxmeasure.Measurer
+	closeFiles func()
+	err        error
+}
+
+// prepare reads the image files and measures the inputs shared by all configurations.
+func (b *batchImageMeasurer) prepare(img *batchImage, cache *tdxmeasure.ACPICache) {
+	cfg := measureConfig{
+		fwPath:        img.Firmware,
+		kernelPath:    img.Kernel,
+		initrdPath:    img.Initrd,
+		kernelCmdline: img.Cmdline,
+		metadataPath:  img.Metadata,
+		cpuCount:      1,
+		sockets:       1,
+		memorySize:    2 << 30,
+		mrtdVariants:  mrtdVariantFlag{tdxmeasure.MRTDTwoPass},
+	}
+	if b.err = cfg.resolve(); b.err != nil {
+		return
+	}
+	b.metadata = cfg.metadata
+	var opts tdxmeasure.Options
+	if opts, b.closeFiles, b.err = cfg.options(); b.err != nil {
+		return
+	}
+	opts.ACPICache = cache
+	b.measurer, b.err = tdxmeasure.NewMeasurer(opts)
+}
+
+// runParallel calls fn for the indices from 0 to n-1 on the given number of workers.
+func runParallel(workers, n int, fn func(i int)) {
+	var wg sync.WaitGroup
+	indices := make(chan int)
+	for range min(workers, n) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indices {
+				fn(i)
+			}
+		}()
+	}
+	for i := range n {
+		indices <- i
+	}
+	close(indices)
+	wg.Wait()
+}
+
+// runBatch measures the images of a manifest with each of its configurations.
+func runBatch(args []string) {
+	var (
+		format   string
+		jobs     int
+		cacheDir string
+	)
+
+	fs := flag.NewFlagSet("batch", flag.ExitOnError)
+	fs.StringVar(&format, "format", "json", "Report format: json or csv")
+	fs.IntVar(&jobs, "jobs", runtime.GOMAXPROCS(0), "Number of measurements computed concurrently")
+	fs.StringVar(&cacheDir, "cache-dir", "", "Directory caching the ACPI table measurements across runs")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s batch [options] <manifest>\n", filepath.Base(os.Args[0]))
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	if format != "json" && format != "csv" {
+		fmt.Printf("Error: unknown report format %q\n", format)
+		os.Exit(1)
+	}
+	if jobs < 1 {
+		fmt.Printf("Error: invalid job count %d\n", jobs)
+		os.Exit(1)
+	}
+	manifest, err := readBatchManifest(fs.Arg(0))
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	// All images share the ACPI tables of each machine configuration.
+	cache, err := tdxmeasure.NewACPICache(cacheDir)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	images := make([]batchImageMeasurer, len(manifest.Images))
+	runParallel(jobs, len(images), func(i int) {
+		images[i].prepare(&manifest.Images[i], cache)
+	})
+	defer func() {
+		for _, img := range images {
+			if img.closeFiles != nil {
+				img.closeFiles()
+			}
+		}
+	}()
+
+	// The key providers only change mr_enclave, so each measurement yields a result per key
+	// provider.
+	configs := len(manifest.Memory) * len(manifest.CPU)
+	results := make([]batchResult, len(images)*configs*len(manifest.KeyProviders))
+	runParallel(jobs, len(images)*configs, func(i int) {
+		img := &images[i/configs]
+		memorySize := uint64(manifest.Memory[i%configs/len(manifest.CPU)])
+		cpuCount := manifest.CPU[i%len(manifest.CPU)]
+		var measurements *tdxmeasure.Measurements
+		err := img.err
+		if err == nil {
+			measurements, err = img.measurer.Measure(memorySize, uint32(cpuCount))
+		}
+		for k, kp := range manifest.KeyProviders {
+			r := &results[i*len(manifest.KeyProviders)+k]
+			*r = batchResult{
+				Image:           manifest.Images[i/configs].Name,
+				Memory:          formatSize(memorySize),
+				MemorySize:      memorySize,
+				CPUCount:        cpuCount,
+				KeyProvider:     kp.measurement,
+				KeyProviderName: kp.name,
+			}
+			if img.metadata != nil {
+				r.Version, r.GitRevision = img.metadata.Version, img.metadata.GitRevision
+			}
+			if err != nil {
+				r.Error = err.Error()
+				continue
+			}
+			r.MRTD = measurements.MRTD.String()
+			r.RTMR0 = measurements.RTMR0.String()
+			r.RTMR1 = measurements.RTMR1.String()
+			r.RTMR2 = measurements.RTMR2.String()
+			// The key providers were validated with the manifest.
+			r.MrEnclave, _ = measurements.CalculateMrEnclave(kp.measurement)
+			r.MrImage = measurements.CalculateMrImage()
+			r.Aggregates = computeAggregates(manifest.Aggregates, measurements, kp.measurement)
+		}
+	})
+
+	if format == "csv" {
+		err = writeBatchCSV(results, manifest.Aggregates)
+	} else {
+		var jsonData []byte
+		if jsonData, err = json.MarshalIndent(results, "", "  "); err == nil {
+			fmt.Println(string(jsonData))
+		}
+	}
+	if err != nil {
+		fmt.Printf("Error writing report: %v\n", err)
+		os.Exit(1)
+	}
+
+	for _, r := range results {
+		if r.Error != "" {
+			os.Exit(1)
+		}
+	}
+}
+
+// writeBatchCSV writes the batch results as CSV to the standard output, with a column per custom
+// aggregate before the error.
+func writeBatchCSV(results []batchResult, aggregates []*tdxmeasure.Aggregate) error {
+	w := csv.NewWriter(os.Stdout)
+	header := []string{"image", "version", "git_revision", "memory", "memory_size", "cpu", "key_provider", "key_provider_name", "mrtd", "rtmr0", "rtmr1", "rtmr2", "mr_enclave", "mr_image"}
+	for _, a := range aggregates {
+		header = append(header, a.Name)
+	}
+	w.Write(append(header, "error"))
+	for _, r := range results {
+		record := []string{
+			r.Image, r.Version, r.GitRevision, r.Memory, strconv.FormatUint(r.MemorySize, 10), strconv.FormatUint(uint64(r.CPUCount), 10), r.KeyProvider, r.KeyProviderName,
+			r.MRTD, r.RTMR0, r.RTMR1, r.RTMR2, r.MrEnclave, r.MrImage,
+		}
+		for _, a := range aggregates {
+			record = append(record, r.Aggregates[a.Name])
+		}
+		w.Write(append(record, r.Error))
+	}
+	w.Flush()
+	return w.Error()
+}
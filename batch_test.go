@@ -0,0 +1,69 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadBatchManifest(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "manifest.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+images:
+  - &custom
+    name: custom
+    fw: custom/ovmf.fd
+    kernel: custom/bzImage
+    cmdline: >-
+      console=ttyS0
+      initrd=initrd
+  - <<: *custom
+    name: custom-initrd
+    initrd: /images/initramfs.cpio.gz
+memory: [2G, 1.5, 4096]
+cpu: [1, 8]
+aggregates:
+  - mr_boot = sha384(mrtd, rtmr0)
+`), 0o644))
+
+	manifest, err := readBatchManifest(path)
+	require.NoError(t, err)
+	require.Equal(t, []batchImage{{
+		Name:     "custom",
+		Firmware: filepath.Join(dir, "custom/ovmf.fd"),
+		Kernel:   filepath.Join(dir, "custom/bzImage"),
+		Cmdline:  "console=ttyS0 initrd=initrd",
+	}, {
+		Name:     "custom-initrd",
+		Firmware: filepath.Join(dir, "custom/ovmf.fd"),
+		Kernel:   filepath.Join(dir, "custom/bzImage"),
+		Initrd:   "/images/initramfs.cpio.gz",
+		Cmdline:  "console=ttyS0 initrd=initrd",
+	}}, manifest.Images)
+	require.Equal(t, []manifestSize{2 << 30, 3 << 19, 4 << 30}, manifest.Memory)
+	require.Equal(t, []uint{1, 8}, manifest.CPU)
+	require.Equal(t, []manifestKeyProvider{{measurement: defaultMrKeyProvider}}, manifest.KeyProviders)
+	require.Len(t, manifest.Aggregates, 1)
+	require.Equal(t, "mr_boot", manifest.Aggregates[0].Name)
+}
+
+func TestReadBatchManifestErrors(t *testing.T) {
+	for _, manifest := range []string{
+		"",
+		"images: []",
+		"images: [{name: a, kernal: bzImage}]",
+		"images: [{name: a}]\nmemory: [0]",
+		"images: [{name: a}]\ncpu: [0]",
+		"images: [{name: a}]\nkey_providers: [\"00\"]",
+		"images: [{name: a}]\naggregates: [\"mr_image = sha256(mrtd)\"]",
+		"images:\n  - name: a\n\tkernel: bzImage",
+	} {
+		path := filepath.Join(t.TempDir(), "manifest.yaml")
+		require.NoError(t, os.WriteFile(path, []byte(manifest), 0o644))
+		_, err := readBatchManifest(path)
+		require.Error(t, err, "%q", manifest)
+	}
+}
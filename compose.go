@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/kvinwang/dstack-mr/pkg/tdxmeasure"
+)
+
+type composeEventOutput struct {
+	Event        string `json:"event"`
+	EventPayload string `json:"event_payload"`
+	Digest       string `json:"digest"`
+}
+
+type composeOutput struct {
+	ComposeHash string               `json:"compose_hash"`
+	AppID       string               `json:"app_id"`
+	InstanceID  string               `json:"instance_id,omitempty"`
+	RTMR3       string               `json:"rtmr3,omitempty"`
+	Events      []composeEventOutput `json:"rtmr3_events"`
+}
+
+// replayComposeEvents replaces the payloads of the app-id, compose-hash and, if given,
+// instance-id events of an RTMR3 event log with the values of the app.
+func replayComposeEvents(events []tdxmeasure.RuntimeEvent, appID, composeHash, instanceID []byte) ([]tdxmeasure.RuntimeEvent, error) {
+	events = append([]tdxmeasure.RuntimeEvent{}, events...)
+	found := false
+	for i, e := range events {
+		switch {
+		case e.Name == "app-id":
+			events[i].Payload = appID
+		case e.Name == "compose-hash":
+			events[i].Payload = composeHash
+			found = true
+		case e.Name == "instance-id" && instanceID != nil:
+			events[i].Payload = instanceID
+		}
+	}
+	if !found {
+		return nil, errors.New("the event log has no compose-hash event")
+	}
+	return events, nil
+}
+
+// runComposeHash computes the compose hash and app ID of an app-compose.json file and the RTMR3
+// of an instance of the app.
+func runComposeHash(args []string) {
+	var (
+		canonical  bool
+		appID      hexFlag
+		instanceID hexFlag
+		eventsPath string
+		jsonOutput bool
+	)
+
+	fs := flag.NewFlagSet("compose-hash", flag.ExitOnError)
+	fs.BoolVar(&canonical, "canonical", false, "Hash the canonical encoding the dstack SDKs deploy instead of the file as is")
+	fs.Var(&appID, "app-id", "App ID assigned by the KMS (hex), instead of the one derived from the compose hash")
+	fs.Var(&instanceID, "instance-id", "Instance ID (hex) replacing the one of the -rtmr3-events log")
+	fs.StringVar(&eventsPath, "rtmr3-events", "", "Path to the dstack JSON event log of an instance, whose RTMR3 events are replayed for the app")
+	fs.BoolVar(&jsonOutput, "json", false, "Output in JSON format")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s compose-hash [options] <app-compose.json>\n", filepath.Base(os.Args[0]))
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	data, err := os.ReadFile(fs.Arg(0))
+	if err != nil {
+		fmt.Printf("Error reading app compose file: %v\n", err)
+		os.Exit(1)
+	}
+
+	canonicalData, err := tdxmeasure.CanonicalAppCompose(data)
+	switch {
+	case canonical && err != nil:
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	case canonical:
+		data = canonicalData
+	case err == nil && !bytes.Equal(data, canonicalData):
+		fmt.Fprintln(os.Stderr, "Warning: the file is not in the canonical encoding the dstack SDKs deploy, use -canonical to hash that encoding")
+	}
+	composeHash := tdxmeasure.ComposeHash(data)
+	if appID == nil {
+		appID = tdxmeasure.AppIDFromComposeHash(composeHash)
+	}
+
+	output := composeOutput{
+		ComposeHash: hex.EncodeToString(composeHash[:]),
+		AppID:       hex.EncodeToString(appID),
+		InstanceID:  hex.EncodeToString(instanceID),
+	}
+	events := []tdxmeasure.RuntimeEvent{
+		{Name: "app-id", Payload: appID},
+		{Name: "compose-hash", Payload: composeHash[:]},
+	}
+	if eventsPath != "" {
+		logData, err := os.ReadFile(eventsPath)
+		if err != nil {
+			fmt.Printf("Error reading RTMR3 event log: %v\n", err)
+			os.Exit(1)
+		}
+		logEvents, err := tdxmeasure.ParseRuntimeEventLog(logData)
+		if err != nil {
+			fmt.Printf("Error parsing RTMR3 event log: %v\n", err)
+			os.Exit(1)
+		}
+		if events, err = replayComposeEvents(logEvents, appID, composeHash[:], instanceID); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		output.RTMR3 = tdxmeasure.MeasureRuntimeEvents(events).String()
+	}
+	for _, e := range events {
+		digest := e.Digest()
+		output.Events = append(output.Events, composeEventOutput{
+			Event:        e.Name,
+			EventPayload: hex.EncodeToString(e.Payload),
+			Digest:       hex.EncodeToString(digest[:]),
+		})
+	}
+
+	if jsonOutput {
+		jsonData, err := json.MarshalIndent(output, "", "  ")
+		if err != nil {
+			fmt.Printf("Error encoding JSON: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println(string(jsonData))
+		return
+	}
+	fmt.Printf("compose_hash: %s\n", output.ComposeHash)
+	fmt.Printf("app_id: %s\n", output.AppID)
+	if output.InstanceID != "" {
+		fmt.Printf("instance_id: %s\n", output.InstanceID)
+	}
+	if output.RTMR3 != "" {
+		fmt.Printf("RTMR3: %s\n", output.RTMR3)
+	}
+	fmt.Println("RTMR3 events:")
+	for _, e := range output.Events {
+		fmt.Printf("  %s: %s\n", e.Event, e.EventPayload)
+		fmt.Printf("    digest: %s\n", e.Digest)
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/kvinwang/dstack-mr/pkg/tdxmeasure"
+)
+
+const defaultCcelPath = "/sys/firmware/acpi/tables/data/CCEL"
+
+type diffLogOutput struct {
+	Match bool            `json:"match"`
+	RTMRs []diffLogResult `json:"rtmrs"`
+}
+
+type diffLogResult struct {
+	RTMR            int             `json:"rtmr"`
+	Match           bool            `json:"match"`
+	FirstDivergence *int            `json:"first_divergence,omitempty"`
+	Predicted       []eventLogEntry `json:"predicted"`
+	Actual          []eventLogEntry `json:"actual"`
+}
+
+// printDivergentEvent prints one side of a diverging event, or notes that it's missing.
+func printDivergentEvent(label string, events []tdxmeasure.Event, index int) {
+	if index >= len(events) {
+		fmt.Printf("    %-10s (missing)\n", label+":")
+		return
+	}
+	ev := events[index]
+	fmt.Printf("    %-10s %s: %s\n", label+":", ev.EventType, ev.Description)
+	fmt.Printf("    %-10s %x\n", "", ev.Digest)
+}
+
+// runDiffLog aligns an actual CCEL event log with the predicted events and reports the first
+// diverging event of each RTMR.
+func runDiffLog(args []string) {
+	var (
+		cfg        measureConfig
+		ccelPath   string
+		jsonOutput bool
+	)
+
+	fs := flag.NewFlagSet("diff-log", flag.ExitOnError)
+	cfg.registerFlags(fs)
+	fs.StringVar(&ccelPath, "ccel", defaultCcelPath, "Path to the CCEL event log")
+	fs.BoolVar(&jsonOutput, "json", false, "Output in JSON format")
+	fs.Parse(args)
+
+	logData, err := os.ReadFile(ccelPath)
+	if err != nil {
+		fmt.Printf("Error reading event log: %v\n", err)
+		os.Exit(1)
+	}
+	actual, err := tdxmeasure.ParseCcelEventLog(logData)
+	if err != nil {
+		fmt.Printf("Error parsing event log: %v\n", err)
+		os.Exit(1)
+	}
+
+	// The event log doesn't depend on the MRTD variant.
+	measurements := cfg.resolveAndMeasure(fs)[0]
+	comparisons := tdxmeasure.CompareEventLogs(measurements.EventLog, actual)
+
+	output := diffLogOutput{Match: true}
+	for _, c := range comparisons {
+		result := diffLogResult{
+			RTMR:      c.RTMR,
+			Match:     c.FirstDivergence < 0,
+			Predicted: newEventLogOutput(c.Predicted),
+			Actual:    newEventLogOutput(c.Actual),
+		}
+		if !result.Match {
+			divergence := c.FirstDivergence
+			result.FirstDivergence = &divergence
+			output.Match = false
+		}
+		output.RTMRs = append(output.RTMRs, result)
+	}
+
+	if jsonOutput {
+		jsonData, err := json.MarshalIndent(output, "", "  ")
+		if err != nil {
+			fmt.Printf("Error encoding JSON: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println(string(jsonData))
+	} else {
+		for _, c := range comparisons {
+			fmt.Printf("RTMR%d: %d predicted, %d in log", c.RTMR, len(c.Predicted), len(c.Actual))
+			if c.FirstDivergence < 0 {
+				fmt.Println(", match")
+				continue
+			}
+			fmt.Printf(", first divergence at event %d\n", c.FirstDivergence)
+			printDivergentEvent("predicted", c.Predicted, c.FirstDivergence)
+			printDivergentEvent("log", c.Actual, c.FirstDivergence)
+		}
+	}
+
+	if !output.Match {
+		os.Exit(1)
+	}
+}
@@ -1,199 +1,636 @@
 package main
 
 import (
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
 	"path/filepath"
-	"strconv"
+	"slices"
 	"strings"
 
-	"github.com/kvinwang/dstack-mr/internal"
+	"github.com/kvinwang/dstack-mr/pkg/tdxmeasure"
 )
 
-type DStackMetadata struct {
-	Bios    string `json:"bios"`
-	Kernel  string `json:"kernel"`
-	Cmdline string `json:"cmdline"`
-	Initrd  string `json:"initrd"`
+type measurementOutput struct {
+	Image *imageInfo `json:"image,omitempty"`
+
+	MRTD          string `json:"mrtd"`
+	RTMR0         string `json:"rtmr0"`
+	RTMR1         string `json:"rtmr1"`
+	RTMR2         string `json:"rtmr2"`
+	RTMR3         string `json:"rtmr3,omitempty"`
+	MrKeyProvider string `json:"mr_key_provider"`
+	MrEnclave     string `json:"mr_enclave"`
+	MrImage       string `json:"mr_image"`
+	// Aggregates holds the custom aggregates, by name.
+	Aggregates map[string]string `json:"aggregates,omitempty"`
+
+	// MRTDVariants lists the values depending on MRTD for each variant when several are selected.
+	MRTDVariants []mrtdVariantOutput `json:"mrtd_variants,omitempty"`
+
+	// Inputs and Config describe what the measurements were computed from.
+	Inputs *inputsOutput   `json:"inputs"`
+	Config *vmConfigOutput `json:"config"`
+
+	EventLog []eventLogEntry `json:"event_log,omitempty"`
 }
 
-type measurementOutput struct {
-	MRTD      string `json:"mrtd"`
-	RTMR0     string `json:"rtmr0"`
-	RTMR1     string `json:"rtmr1"`
-	RTMR2     string `json:"rtmr2"`
-	MrEnclave string `json:"mr_enclave"`
-	MrImage   string `json:"mr_image"`
+type mrtdVariantOutput struct {
+	Variant    string            `json:"variant"`
+	MRTD       string            `json:"mrtd"`
+	MrEnclave  string            `json:"mr_enclave"`
+	MrImage    string            `json:"mr_image"`
+	Aggregates map[string]string `json:"aggregates,omitempty"`
 }
 
-// parseMemorySize parses a human readable memory size (e.g., "1G", "512M") into megabytes
-func parseMemorySize(size string) (uint64, error) {
-	size = strings.TrimSpace(strings.ToUpper(size))
-	if len(size) == 0 {
-		return 0, fmt.Errorf("empty memory size")
-	}
+type eventLogEntry struct {
+	Index       int    `json:"index"`
+	RTMR        int    `json:"rtmr"`
+	EventType   string `json:"event_type"`
+	Description string `json:"description"`
+	Digest      string `json:"digest"`
+	RTMRValue   string `json:"rtmr_value"`
+}
 
-	// Get the unit (last character)
-	unit := size[len(size)-1:]
-	// Get the number (everything except the last character)
-	numStr := size[:len(size)-1]
+// newEventLogOutput converts the predicted event log into its JSON representation.
+func newEventLogOutput(log []tdxmeasure.Event) []eventLogEntry {
+	entries := make([]eventLogEntry, 0, len(log))
+	for _, ev := range log {
+		entries = append(entries, eventLogEntry{
+			Index:       ev.Index,
+			RTMR:        ev.RTMR,
+			EventType:   ev.EventType.String(),
+			Description: ev.Description,
+			Digest:      fmt.Sprintf("%x", ev.Digest),
+			RTMRValue:   ev.RTMRValue.String(),
+		})
+	}
+	return entries
+}
 
-	// Parse the number
-	num, err := strconv.ParseUint(numStr, 10, 64)
-	if err != nil {
-		return 0, fmt.Errorf("invalid memory size number: %v", err)
+// printEventLog prints the predicted event log in text form.
+func printEventLog(log []tdxmeasure.Event) {
+	fmt.Println("Event log:")
+	for _, ev := range log {
+		fmt.Printf("  RTMR%d[%d] %s: %s\n", ev.RTMR, ev.Index, ev.EventType, ev.Description)
+		fmt.Printf("    digest: %x\n", ev.Digest)
+		fmt.Printf("    rtmr:   %s\n", ev.RTMRValue)
 	}
+}
+
+const defaultMrKeyProvider = "0000000000000000000000000000000000000000000000000000000000000000"
+
+// keyProviderFlags are the flags selecting the key provider measurement mixed into mr_enclave.
+type keyProviderFlags struct {
+	mrKeyProvider string
+	infoPath      string
+}
+
+func (k *keyProviderFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&k.mrKeyProvider, "mrkp", "", "Measurement of key provider (32 bytes, hex), all zeros by default")
+	fs.StringVar(&k.infoPath, "key-provider", "", "Path to a key provider descriptor ({\"name\": ..., \"id\": ...}) to derive the measurement of key provider from, instead of -mrkp")
+}
 
-	// Convert to megabytes based on unit
-	switch unit {
-	case "G":
-		return num * 1024, nil // Convert GB to MB
-	case "M":
-		return num, nil // Already in MB
-	default:
-		return 0, fmt.Errorf("invalid memory unit '%s', must be one of: G, M", unit)
+// measurement returns the hex encoded key provider measurement selected by the flags.
+func (k *keyProviderFlags) measurement() (string, error) {
+	switch {
+	case k.mrKeyProvider != "" && k.infoPath != "":
+		return "", errors.New("-mrkp and -key-provider are mutually exclusive")
+	case k.infoPath != "":
+		data, err := os.ReadFile(k.infoPath)
+		if err != nil {
+			return "", fmt.Errorf("reading key provider file: %w", err)
+		}
+		info, err := tdxmeasure.ParseKeyProviderInfo(data)
+		if err != nil {
+			return "", err
+		}
+		return hex.EncodeToString(info.Measurement()), nil
+	case k.mrKeyProvider != "":
+		if _, err := tdxmeasure.ParseKeyProvider(k.mrKeyProvider); err != nil {
+			return "", err
+		}
+		return k.mrKeyProvider, nil
 	}
+	return defaultMrKeyProvider, nil
 }
 
-type memoryValue uint64
+// measureConfig holds the measurement inputs given on the command line.
+type measureConfig struct {
+	fwPath        string
+	kernelPath    string
+	initrdPath    string
+	memorySize    memoryValue
+	maxRAMBelow4G sizeValue
+	pciHole64Size sizeValue
+	cpuCount      uint
+	sockets       uint
+	kernelCmdline string
+	metadataPath  string
+	metadata      *DStackMetadata
+	devicesPath   string
+	numaPath      string
+	cacheDir      string
 
-func (m *memoryValue) String() string {
-	mb := uint64(*m)
-	const (
-		GB = 1024 // in MB
-	)
+	secureBootFromFw bool
+	pkPath           string
+	kekPath          string
+	dbPath           string
+	dbxPath          string
+
+	mrtdVariants mrtdVariantFlag
+
+	runtimeEventsPath string
+	runtimeEvents     runtimeEventFlag
+}
 
-	if mb >= GB && mb%GB == 0 {
-		return fmt.Sprintf("%dG", mb/GB)
+// mrtdVariantFlag is a flag selecting one or both MRTD variants.
+type mrtdVariantFlag []tdxmeasure.MRTDVariant
+
+func (f *mrtdVariantFlag) String() string {
+	if len(*f) > 1 {
+		return "both"
+	}
+	if len(*f) == 1 {
+		return (*f)[0].String()
 	}
-	return fmt.Sprintf("%dM", mb)
+	return ""
 }
 
-func (m *memoryValue) Set(value string) error {
-	mb, err := parseMemorySize(value)
+func (f *mrtdVariantFlag) Set(value string) error {
+	if value == "both" {
+		*f = mrtdVariantFlag{tdxmeasure.MRTDTwoPass, tdxmeasure.MRTDSinglePass}
+		return nil
+	}
+	variant, err := tdxmeasure.ParseMRTDVariant(value)
 	if err != nil {
 		return err
 	}
-	*m = memoryValue(mb)
+	*f = mrtdVariantFlag{variant}
 	return nil
 }
 
-func main() {
-	const defaultMrKeyProvider = "0000000000000000000000000000000000000000000000000000000000000000"
-	var (
-		fwPath        string
-		kernelPath    string
-		initrdPath    string
-		memorySize    memoryValue = 2048 // 2G default (in MB)
-		cpuCountUint  uint
-		kernelCmdline string
-		jsonOutput    bool
-		metadataPath  string
-		mrKeyProvider string = defaultMrKeyProvider
-	)
+// registerFlags registers the measurement input flags on the given flag set.
+func (c *measureConfig) registerFlags(fs *flag.FlagSet) {
+	c.memorySize = 2 << 30 // 2G default
+	c.mrtdVariants = mrtdVariantFlag{tdxmeasure.MRTDTwoPass}
 
-	flag.StringVar(&fwPath, "fw", "", "Path to firmware file")
-	flag.StringVar(&kernelPath, "kernel", "", "Path to kernel file")
-	flag.StringVar(&initrdPath, "initrd", "", "Path to initrd file")
-	flag.Var(&memorySize, "memory", "Memory size (e.g., 512M, 1G, 2G)")
-	flag.UintVar(&cpuCountUint, "cpu", 1, "Number of CPUs")
-	flag.StringVar(&kernelCmdline, "cmdline", "", "Kernel command line")
-	flag.BoolVar(&jsonOutput, "json", false, "Output in JSON format")
-	flag.StringVar(&metadataPath, "metadata", "", "Path to DStack metadata.json file")
-	flag.StringVar(&mrKeyProvider, "mrkp", defaultMrKeyProvider, "Measurement of key provider")
-	flag.Parse()
+	fs.StringVar(&c.fwPath, "fw", "", "Path to firmware file")
+	fs.StringVar(&c.kernelPath, "kernel", "", "Path to kernel file")
+	fs.StringVar(&c.initrdPath, "initrd", "", "Path to initrd file")
+	fs.Var(&c.memorySize, "memory", "Memory size in QEMU -m syntax (e.g., 512, 1.5G, size=4G); a size without unit is in MiB")
+	fs.Var(&c.maxRAMBelow4G, "max-ram-below-4g", "Limit of the RAM mapped below 4G (QEMU -machine max-ram-below-4g)")
+	fs.Var(&c.pciHole64Size, "pci-hole64-size", "Minimum size of the 64-bit PCI hole (QEMU q35-pcihost.pci-hole64-size, default 32G)")
+	fs.UintVar(&c.cpuCount, "cpu", 1, "Number of CPUs")
+	fs.UintVar(&c.sockets, "sockets", 1, "Number of CPU sockets the CPUs are split into")
+	fs.StringVar(&c.kernelCmdline, "cmdline", "", "Kernel command line")
+	fs.StringVar(&c.metadataPath, "metadata", "", "Path to DStack metadata.json file")
+	fs.StringVar(&c.devicesPath, "devices", "", "Path to a JSON file describing the PCI devices of the guest")
+	fs.StringVar(&c.numaPath, "numa", "", "Path to a JSON file describing the NUMA topology of the guest")
+	fs.StringVar(&c.cacheDir, "cache-dir", "", "Directory caching the ACPI table measurements across runs")
+	fs.BoolVar(&c.secureBootFromFw, "sb-fw-vars", false, "Read Secure Boot keys (PK, KEK, db, dbx) from the firmware variable store")
+	fs.StringVar(&c.pkPath, "pk", "", "Path to Secure Boot PK (ESL or auth file)")
+	fs.StringVar(&c.kekPath, "kek", "", "Path to Secure Boot KEK (ESL or auth file)")
+	fs.StringVar(&c.dbPath, "db", "", "Path to Secure Boot db (ESL or auth file)")
+	fs.StringVar(&c.dbxPath, "dbx", "", "Path to Secure Boot dbx (ESL or auth file)")
+	fs.Var(&c.mrtdVariants, "mrtd-variant", "MRTD page-add ordering: two-pass, single-pass or both")
+	fs.StringVar(&c.runtimeEventsPath, "rtmr3-events", "", "Path to a dstack JSON event log whose RTMR3 events are replayed")
+	fs.Var(&c.runtimeEvents, "rtmr3-event", "RTMR3 runtime event as name=hex-payload, replayed after the -rtmr3-events ones (repeatable)")
+}
+
+// resolve fills in the inputs from the metadata file, if one is given, and checks that all
+// required inputs are present and the CPU topology is valid.
+func (c *measureConfig) resolve() error {
+	if err := c.resolveInputs(); err != nil {
+		return err
+	}
+	if c.cpuCount < 1 || c.cpuCount > tdxmeasure.MaxCPUCount {
+		return fmt.Errorf("invalid CPU count %d (must be between 1 and %d)", c.cpuCount, tdxmeasure.MaxCPUCount)
+	}
+	if c.sockets < 1 || c.cpuCount%c.sockets != 0 {
+		return fmt.Errorf("invalid socket count %d (must evenly divide the CPU count)", c.sockets)
+	}
+	return nil
+}
 
+// resolveInputs fills in the inputs from the metadata file, if one is given, and checks that all
+// required inputs are present.
+func (c *measureConfig) resolveInputs() error {
 	// If metadata file is provided, read it and override other options
-	if metadataPath != "" {
-		metadataDir := filepath.Dir(metadataPath)
-		data, err := os.ReadFile(metadataPath)
+	if c.metadataPath != "" {
+		metadataDir := filepath.Dir(c.metadataPath)
+		metadata, err := readDStackMetadata(c.metadataPath)
 		if err != nil {
-			fmt.Printf("Error reading metadata file: %v\n", err)
-			os.Exit(1)
+			return err
 		}
+		c.metadata = metadata
 
-		var metadata DStackMetadata
-		if err := json.Unmarshal(data, &metadata); err != nil {
-			fmt.Printf("Error parsing metadata file: %v\n", err)
-			os.Exit(1)
+		// Override paths with metadata values
+		if c.fwPath == "" {
+			c.fwPath = filepath.Join(metadataDir, metadata.Bios)
+		}
+		if c.kernelPath == "" {
+			c.kernelPath = filepath.Join(metadataDir, metadata.Kernel)
+		}
+		if c.initrdPath == "" && metadata.Initrd != "" {
+			c.initrdPath = filepath.Join(metadataDir, metadata.Initrd)
 		}
+		if c.kernelCmdline == "" {
+			c.kernelCmdline = metadata.kernelCmdline()
+		}
+	}
 
-		// Override paths with metadata values
-		if fwPath == "" {
-			fwPath = filepath.Join(metadataDir, metadata.Bios)
+	if c.fwPath == "" || c.kernelPath == "" {
+		return errMissingInputs
+	}
+	return nil
+}
+
+var errMissingInputs = errors.New("firmware and kernel paths are required (either directly or via metadata.json)")
+
+// secureBootConfig builds the Secure Boot configuration from the firmware variable store and the
+// given key files. It returns nil if no Secure Boot keys were requested.
+func (c *measureConfig) secureBootConfig(fwData []byte) (*tdxmeasure.SecureBootConfig, error) {
+	if !c.secureBootFromFw && c.pkPath == "" && c.kekPath == "" && c.dbPath == "" && c.dbxPath == "" {
+		return nil, nil
+	}
+
+	cfg := &tdxmeasure.SecureBootConfig{}
+	if c.secureBootFromFw {
+		var err error
+		cfg, err = tdxmeasure.SecureBootConfigFromFirmware(fwData)
+		if err != nil {
+			return nil, fmt.Errorf("reading Secure Boot keys from firmware: %w", err)
 		}
-		if kernelPath == "" {
-			kernelPath = filepath.Join(metadataDir, metadata.Kernel)
+	}
+
+	// Key files override the keys found in the firmware.
+	for _, key := range []struct {
+		name string
+		path string
+		dst  *[]byte
+	}{
+		{"PK", c.pkPath, &cfg.PK},
+		{"KEK", c.kekPath, &cfg.KEK},
+		{"db", c.dbPath, &cfg.DB},
+		{"dbx", c.dbxPath, &cfg.DBX},
+	} {
+		if key.path == "" {
+			continue
 		}
-		if initrdPath == "" && metadata.Initrd != "" {
-			initrdPath = filepath.Join(metadataDir, metadata.Initrd)
+		data, err := os.ReadFile(key.path)
+		if err != nil {
+			return nil, fmt.Errorf("reading %s file: %w", key.name, err)
 		}
-		if kernelCmdline == "" {
-			kernelCmdline = metadata.Cmdline
-			if metadata.Initrd != "" {
-				kernelCmdline += " initrd=initrd"
-			}
+		if *key.dst, err = tdxmeasure.LoadSignatureListFile(data); err != nil {
+			return nil, fmt.Errorf("parsing %s file: %w", key.name, err)
 		}
 	}
+	return cfg, nil
+}
 
-	if fwPath == "" || kernelPath == "" {
-		fmt.Println("Error: firmware and kernel paths are required (either directly or via metadata.json)")
-		flag.Usage()
-		os.Exit(1)
+// options reads the input files into measurement options. The kernel and initrd are opened to be
+// streamed from disk while being hashed, the returned function closes them.
+func (c *measureConfig) options() (tdxmeasure.Options, func(), error) {
+	var opts tdxmeasure.Options
+	var files []*os.File
+	closeFiles := func() {
+		for _, f := range files {
+			f.Close()
+		}
 	}
 
 	// Read files
-	fwData, err := os.ReadFile(fwPath)
+	fwData, err := os.ReadFile(c.fwPath)
 	if err != nil {
-		fmt.Printf("Error reading firmware file: %v\n", err)
-		os.Exit(1)
+		return opts, nil, fmt.Errorf("reading firmware file: %w", err)
 	}
 
-	kernelData, err := os.ReadFile(kernelPath)
+	kernelFile, kernelSize, err := openInput(c.kernelPath)
 	if err != nil {
-		fmt.Printf("Error reading kernel file: %v\n", err)
-		os.Exit(1)
+		return opts, nil, fmt.Errorf("reading kernel file: %w", err)
 	}
+	files = append(files, kernelFile)
 
-	var initrdData []byte
-	if initrdPath != "" {
-		initrdData, err = os.ReadFile(initrdPath)
+	opts = tdxmeasure.Options{
+		Firmware:      fwData,
+		KernelReader:  kernelFile,
+		KernelSize:    kernelSize,
+		MemorySize:    uint64(c.memorySize),
+		MaxRAMBelow4G: uint64(c.maxRAMBelow4G),
+		PCIHole64Size: uint64(c.pciHole64Size),
+		CPUCount:      uint32(c.cpuCount),
+		Sockets:       uint32(c.sockets),
+		KernelCmdline: c.kernelCmdline,
+		MRTDVariant:   c.mrtdVariants[0],
+	}
+
+	if c.initrdPath != "" {
+		initrdFile, initrdSize, err := openInput(c.initrdPath)
 		if err != nil {
-			fmt.Printf("Error reading initrd file: %v\n", err)
-			os.Exit(1)
+			closeFiles()
+			return opts, nil, fmt.Errorf("reading initrd file: %w", err)
 		}
+		files = append(files, initrdFile)
+		opts.InitrdReader = initrdFile
+		opts.InitrdSize = initrdSize
+	}
+
+	opts.SecureBoot, err = c.secureBootConfig(fwData)
+	if err == nil && c.devicesPath != "" {
+		opts.Devices = &tdxmeasure.DeviceConfig{}
+		err = readJSONFile(c.devicesPath, "devices", opts.Devices)
 	}
+	if err == nil {
+		opts.RuntimeEvents, err = c.runtimeEventList()
+	}
+	if err == nil && c.numaPath != "" {
+		opts.NUMA = &tdxmeasure.NUMAConfig{}
+		err = readJSONFile(c.numaPath, "NUMA", opts.NUMA)
+	}
+	if err == nil {
+		// The cache is also used in memory by commands measuring several configurations.
+		opts.ACPICache, err = tdxmeasure.NewACPICache(c.cacheDir)
+	}
+	if err != nil {
+		closeFiles()
+		return opts, nil, err
+	}
+	return opts, closeFiles, nil
+}
+
+// measure reads the input files and calculates the measurements for each selected MRTD variant.
+func (c *measureConfig) measure() ([]*tdxmeasure.Measurements, error) {
+	opts, closeFiles, err := c.options()
+	if err != nil {
+		return nil, err
+	}
+	defer closeFiles()
 
 	// Calculate measurements
-	measurements, err := internal.MeasureTdxQemu(fwData, kernelData, initrdData, uint64(memorySize), uint8(cpuCountUint), kernelCmdline)
+	measurements, err := tdxmeasure.Measure(opts)
+	if err != nil {
+		return nil, fmt.Errorf("calculating measurements: %w", err)
+	}
+	result := []*tdxmeasure.Measurements{measurements}
+
+	// The other variants only differ in MRTD.
+	for _, variant := range c.mrtdVariants[1:] {
+		m := *measurements
+		m.MRTDVariant = variant
+		if m.MRTD, err = tdxmeasure.ComputeMRTD(opts.Firmware, variant); err != nil {
+			return nil, fmt.Errorf("calculating MRTD: %w", err)
+		}
+		result = append(result, &m)
+	}
+	return result, nil
+}
+
+// hasRuntimeEvents reports whether RTMR3 runtime events were given.
+func (c *measureConfig) hasRuntimeEvents() bool {
+	return c.runtimeEventsPath != "" || len(c.runtimeEvents) > 0
+}
+
+// runtimeEventList returns the RTMR3 runtime events of the event log file followed by the ones
+// given on the command line.
+func (c *measureConfig) runtimeEventList() ([]tdxmeasure.RuntimeEvent, error) {
+	var events []tdxmeasure.RuntimeEvent
+	if c.runtimeEventsPath != "" {
+		data, err := os.ReadFile(c.runtimeEventsPath)
+		if err != nil {
+			return nil, fmt.Errorf("reading RTMR3 event log: %w", err)
+		}
+		if events, err = tdxmeasure.ParseRuntimeEventLog(data); err != nil {
+			return nil, fmt.Errorf("parsing RTMR3 event log: %w", err)
+		}
+	}
+	return append(events, c.runtimeEvents...), nil
+}
+
+// runtimeEventFlag is a repeatable flag holding RTMR3 runtime events given as name=hex-payload.
+type runtimeEventFlag []tdxmeasure.RuntimeEvent
+
+func (f *runtimeEventFlag) String() string {
+	var events []string
+	for _, e := range *f {
+		events = append(events, fmt.Sprintf("%s=%x", e.Name, e.Payload))
+	}
+	return strings.Join(events, ",")
+}
+
+func (f *runtimeEventFlag) Set(value string) error {
+	name, payload, ok := strings.Cut(value, "=")
+	if !ok || name == "" {
+		return fmt.Errorf("invalid runtime event %q (expected name=hex-payload)", value)
+	}
+	decoded, err := hex.DecodeString(strings.TrimPrefix(payload, "0x"))
 	if err != nil {
-		fmt.Printf("Error calculating measurements: %v\n", err)
+		return fmt.Errorf("invalid payload of runtime event %q: %w", name, err)
+	}
+	*f = append(*f, tdxmeasure.RuntimeEvent{Name: name, Payload: decoded})
+	return nil
+}
+
+// readJSONFile reads the JSON file describing the given input into v.
+func readJSONFile(path, what string, v any) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading %s file: %w", what, err)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("parsing %s file: %w", what, err)
+	}
+	return nil
+}
+
+// openInput opens an input file and returns it along with its size.
+func openInput(path string) (*os.File, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	return f, info.Size(), nil
+}
+
+// resolveAndMeasure resolves the inputs and calculates the measurements for each selected MRTD
+// variant, exiting on failure.
+func (c *measureConfig) resolveAndMeasure(fs *flag.FlagSet) []*tdxmeasure.Measurements {
+	if err := c.resolve(); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		if errors.Is(err, errMissingInputs) {
+			fs.Usage()
+		}
 		os.Exit(1)
 	}
 
+	measurements, err := c.measure()
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	return measurements
+}
+
+func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "verify":
+			runVerify(os.Args[2:])
+			return
+		case "diff-log":
+			runDiffLog(os.Args[2:])
+			return
+		case "search":
+			runSearch(os.Args[2:])
+			return
+		case "batch":
+			runBatch(os.Args[2:])
+			return
+		case "compose-hash":
+			runComposeHash(os.Args[2:])
+			return
+		}
+	}
+	runMeasure()
+}
+
+// runMeasure calculates and prints the measurements for the given inputs.
+func runMeasure() {
+	var (
+		cfg         measureConfig
+		format      string
+		jsonOutput  bool
+		eventLog    bool
+		keyProvider keyProviderFlags
+		aggFlags    aggregateFlags
+	)
+
+	cfg.registerFlags(flag.CommandLine)
+	flag.StringVar(&format, "format", "text", "Output format: "+strings.Join(outputFormats, ", "))
+	flag.BoolVar(&jsonOutput, "json", false, "Output in JSON format (same as -format json)")
+	flag.BoolVar(&eventLog, "eventlog", false, "Include the predicted RTMR event log in the output")
+	keyProvider.register(flag.CommandLine)
+	aggFlags.register(flag.CommandLine)
+	flag.Parse()
+
+	mrKeyProvider, err := keyProvider.measurement()
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	aggregates, err := aggFlags.aggregates()
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
 	if jsonOutput {
-		output := measurementOutput{
-			MRTD:      fmt.Sprintf("%x", measurements.MRTD),
-			RTMR0:     fmt.Sprintf("%x", measurements.RTMR0),
-			RTMR1:     fmt.Sprintf("%x", measurements.RTMR1),
-			RTMR2:     fmt.Sprintf("%x", measurements.RTMR2),
-			MrEnclave: measurements.CalculateMrEnclave(mrKeyProvider),
-			MrImage:   measurements.CalculateMrImage(),
-		}
-		jsonData, err := json.MarshalIndent(output, "", "  ")
+		if format != "text" && format != "json" {
+			fmt.Println("Error: -json and -format are mutually exclusive")
+			os.Exit(1)
+		}
+		format = "json"
+	}
+	if !slices.Contains(outputFormats, format) {
+		fmt.Printf("Error: unknown output format %q\n", format)
+		os.Exit(1)
+	}
+	variants := cfg.resolveAndMeasure(flag.CommandLine)
+	measurements := variants[0]
+	// The key provider measurement is valid, so mr_enclave can't fail.
+	mrEnclave := func(m *tdxmeasure.Measurements) string {
+		v, _ := m.CalculateMrEnclave(mrKeyProvider)
+		return v
+	}
+
+	if format != "text" {
+		inputs, err := cfg.inputs(keyProvider.infoPath)
 		if err != nil {
-			fmt.Printf("Error encoding JSON: %v\n", err)
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		// The key provider measurement is valid, its encoding is normalized.
+		mrKeyProviderBytes, _ := tdxmeasure.ParseKeyProvider(mrKeyProvider)
+		output := measurementOutput{
+			Image:         cfg.metadata.imageInfo(),
+			MRTD:          measurements.MRTD.String(),
+			RTMR0:         measurements.RTMR0.String(),
+			RTMR1:         measurements.RTMR1.String(),
+			RTMR2:         measurements.RTMR2.String(),
+			MrKeyProvider: hex.EncodeToString(mrKeyProviderBytes),
+			MrEnclave:     mrEnclave(measurements),
+			MrImage:       measurements.CalculateMrImage(),
+			Aggregates:    computeAggregates(aggregates, measurements, mrKeyProvider),
+			Inputs:        inputs,
+			Config:        cfg.vmConfig(),
+		}
+		if cfg.hasRuntimeEvents() {
+			output.RTMR3 = measurements.RTMR3.String()
+		}
+		if len(variants) > 1 {
+			for _, m := range variants {
+				output.MRTDVariants = append(output.MRTDVariants, mrtdVariantOutput{
+					Variant:    m.MRTDVariant.String(),
+					MRTD:       m.MRTD.String(),
+					MrEnclave:  mrEnclave(m),
+					MrImage:    m.CalculateMrImage(),
+					Aggregates: computeAggregates(aggregates, m, mrKeyProvider),
+				})
+			}
+		}
+		if eventLog {
+			output.EventLog = newEventLogOutput(measurements.EventLog)
+		}
+		if err := writeMeasurementOutput(os.Stdout, format, &output); err != nil {
+			fmt.Printf("Error writing output: %v\n", err)
 			os.Exit(1)
 		}
-		fmt.Println(string(jsonData))
+	} else if len(variants) > 1 {
+		printImageInfo(cfg.metadata.imageInfo())
+		for _, m := range variants {
+			fmt.Printf("MRTD (%s): %s\n", m.MRTDVariant, m.MRTD)
+		}
+		fmt.Printf("RTMR0: %s\n", measurements.RTMR0)
+		fmt.Printf("RTMR1: %s\n", measurements.RTMR1)
+		fmt.Printf("RTMR2: %s\n", measurements.RTMR2)
+		if cfg.hasRuntimeEvents() {
+			fmt.Printf("RTMR3: %s\n", measurements.RTMR3)
+		}
+		for _, m := range variants {
+			fmt.Printf("mr_enclave (%s): %s\n", m.MRTDVariant, mrEnclave(m))
+			fmt.Printf("mr_image (%s): %s\n", m.MRTDVariant, m.CalculateMrImage())
+			values := computeAggregates(aggregates, m, mrKeyProvider)
+			for _, a := range aggregates {
+				fmt.Printf("%s (%s): %s\n", a.Name, m.MRTDVariant, values[a.Name])
+			}
+		}
+		if eventLog {
+			printEventLog(measurements.EventLog)
+		}
 	} else {
-		fmt.Printf("MRTD: %x\n", measurements.MRTD)
-		fmt.Printf("RTMR0: %x\n", measurements.RTMR0)
-		fmt.Printf("RTMR1: %x\n", measurements.RTMR1)
-		fmt.Printf("RTMR2: %x\n", measurements.RTMR2)
-		fmt.Printf("mr_enclave: %s\n", measurements.CalculateMrEnclave(mrKeyProvider))
+		printImageInfo(cfg.metadata.imageInfo())
+		fmt.Printf("MRTD: %s\n", measurements.MRTD)
+		fmt.Printf("RTMR0: %s\n", measurements.RTMR0)
+		fmt.Printf("RTMR1: %s\n", measurements.RTMR1)
+		fmt.Printf("RTMR2: %s\n", measurements.RTMR2)
+		if cfg.hasRuntimeEvents() {
+			fmt.Printf("RTMR3: %s\n", measurements.RTMR3)
+		}
+		fmt.Printf("mr_enclave: %s\n", mrEnclave(measurements))
 		fmt.Printf("mr_image: %s\n", measurements.CalculateMrImage())
+		values := computeAggregates(aggregates, measurements, mrKeyProvider)
+		for _, a := range aggregates {
+			fmt.Printf("%s: %s\n", a.Name, values[a.Name])
+		}
+		if eventLog {
+			printEventLog(measurements.EventLog)
+		}
 	}
 }
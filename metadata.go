@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DStackMetadata is the metadata.json file describing a dstack image.
+type DStackMetadata struct {
+	Bios    string `json:"bios"`
+	Kernel  string `json:"kernel"`
+	Cmdline string `json:"cmdline"`
+	Initrd  string `json:"initrd"`
+	// Rootfs is the root filesystem image and RootfsHash the SHA-256 hash the guest checks it
+	// against.
+	Rootfs     string `json:"rootfs,omitempty"`
+	RootfsHash string `json:"rootfs_hash,omitempty"`
+	// SharedRO is set when the root filesystem is shared read-only with the guest instead of
+	// being attached as a disk.
+	SharedRO    bool   `json:"shared_ro,omitempty"`
+	Version     string `json:"version,omitempty"`
+	GitRevision string `json:"git_revision,omitempty"`
+	IsDev       bool   `json:"is_dev,omitempty"`
+}
+
+// readDStackMetadata reads and validates a metadata.json file.
+func readDStackMetadata(path string) (*DStackMetadata, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading metadata file: %w", err)
+	}
+	var metadata DStackMetadata
+	if err := json.Unmarshal(data, &metadata); err != nil {
+		return nil, fmt.Errorf("parsing metadata file: %w", err)
+	}
+	if err := metadata.check(); err != nil {
+		return nil, fmt.Errorf("invalid metadata file: %w", err)
+	}
+	return &metadata, nil
+}
+
+// check validates the metadata.
+func (m *DStackMetadata) check() error {
+	if m.Bios == "" || m.Kernel == "" {
+		return errors.New("bios and kernel are required")
+	}
+	for _, file := range []struct{ name, path string }{
+		{"bios", m.Bios}, {"kernel", m.Kernel}, {"initrd", m.Initrd}, {"rootfs", m.Rootfs},
+	} {
+		// Image files are stored next to the metadata.
+		if file.path != "" && !filepath.IsLocal(file.path) {
+			return fmt.Errorf("%s path %q is not relative to the image directory", file.name, file.path)
+		}
+	}
+	if m.RootfsHash != "" {
+		if m.Rootfs == "" {
+			return errors.New("rootfs_hash is set without a rootfs")
+		}
+		if hash, err := hex.DecodeString(m.RootfsHash); err != nil || len(hash) != 32 {
+			return fmt.Errorf("rootfs_hash %q is not a hex encoded SHA-256 hash", m.RootfsHash)
+		}
+	}
+	if m.SharedRO && m.Rootfs == "" {
+		return errors.New("shared_ro is set without a rootfs")
+	}
+	return nil
+}
+
+// kernelCmdline returns the command line the kernel receives. Like dstack's VMM, the root
+// filesystem hash is appended to the command line of the metadata unless it's already there, and
+// like OVMF, "initrd=initrd" is appended when an initrd is loaded.
+func (m *DStackMetadata) kernelCmdline() string {
+	cmdline := m.Cmdline
+	if m.RootfsHash != "" && !strings.Contains(cmdline, "dstack.rootfs_hash=") {
+		cmdline += " dstack.rootfs_hash=" + m.RootfsHash
+	}
+	if m.Initrd != "" {
+		cmdline += " initrd=initrd"
+	}
+	return cmdline
+}
+
+// imageInfo identifies the image a report was computed for.
+type imageInfo struct {
+	Version     string `json:"version,omitempty"`
+	GitRevision string `json:"git_revision,omitempty"`
+	IsDev       bool   `json:"is_dev,omitempty"`
+	RootfsHash  string `json:"rootfs_hash,omitempty"`
+}
+
+// imageInfo returns the description of the image, or nil if the metadata doesn't identify it.
+func (m *DStackMetadata) imageInfo() *imageInfo {
+	if m == nil || m.Version == "" && m.GitRevision == "" && m.RootfsHash == "" {
+		return nil
+	}
+	return &imageInfo{Version: m.Version, GitRevision: m.GitRevision, IsDev: m.IsDev, RootfsHash: m.RootfsHash}
+}
+
+// String formats the image description for the text output, e.g. "0.4.0 (git 1a2b3c, dev)".
+func (i *imageInfo) String() string {
+	version := i.Version
+	if version == "" {
+		version = "unknown version"
+	}
+	var details []string
+	if i.GitRevision != "" {
+		details = append(details, "git "+i.GitRevision)
+	}
+	if i.IsDev {
+		details = append(details, "dev")
+	}
+	if len(details) > 0 {
+		version += " (" + strings.Join(details, ", ") + ")"
+	}
+	return version
+}
+
+// printImageInfo prints the image description of the text output, if any.
+func printImageInfo(info *imageInfo) {
+	if info == nil {
+		return
+	}
+	fmt.Printf("Image: %s\n", info)
+	if info.RootfsHash != "" {
+		fmt.Printf("Rootfs hash: %s\n", info.RootfsHash)
+	}
+}
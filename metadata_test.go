@@ -0,0 +1,142 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testRootfsHash = "3c47ef972d531d524daa15fa33dd885dd23de6221bbd10a29eb42ecfcf2ef422"
+
+func TestDStackMetadataCheck(t *testing.T) {
+	valid := DStackMetadata{Bios: "ovmf.fd", Kernel: "bzImage", Initrd: "initramfs.cpio.gz", Rootfs: "rootfs.img.verity", RootfsHash: testRootfsHash}
+	tests := []struct {
+		name    string
+		modify  func(*DStackMetadata)
+		wantErr bool
+	}{
+		{"valid", func(*DStackMetadata) {}, false},
+		{"no initrd or rootfs", func(m *DStackMetadata) { m.Initrd, m.Rootfs, m.RootfsHash = "", "", "" }, false},
+		{"rootfs without hash", func(m *DStackMetadata) { m.RootfsHash = "" }, false},
+		{"shared read-only rootfs", func(m *DStackMetadata) { m.SharedRO = true }, false},
+		{"image in a subdirectory", func(m *DStackMetadata) { m.Kernel = "boot/bzImage" }, false},
+		{"no bios", func(m *DStackMetadata) { m.Bios = "" }, true},
+		{"no kernel", func(m *DStackMetadata) { m.Kernel = "" }, true},
+		{"absolute path", func(m *DStackMetadata) { m.Bios = "/usr/share/ovmf/OVMF.fd" }, true},
+		{"path outside of the image", func(m *DStackMetadata) { m.Initrd = "../initramfs.cpio.gz" }, true},
+		{"rootfs hash without rootfs", func(m *DStackMetadata) { m.Rootfs = "" }, true},
+		{"rootfs hash not hex", func(m *DStackMetadata) { m.RootfsHash = "zz" + testRootfsHash[2:] }, true},
+		{"rootfs hash too short", func(m *DStackMetadata) { m.RootfsHash = testRootfsHash[:62] }, true},
+		{"rootfs hash too long", func(m *DStackMetadata) { m.RootfsHash = testRootfsHash + "00" }, true},
+		{"shared read-only without rootfs", func(m *DStackMetadata) { m.Rootfs, m.RootfsHash, m.SharedRO = "", "", true }, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := valid
+			tt.modify(&m)
+			if tt.wantErr {
+				require.Error(t, m.check())
+			} else {
+				require.NoError(t, m.check())
+			}
+		})
+	}
+}
+
+func TestDStackMetadataKernelCmdline(t *testing.T) {
+	hash := testRootfsHash
+	tests := []struct {
+		name     string
+		metadata DStackMetadata
+		want     string
+	}{
+		{"command line only", DStackMetadata{Cmdline: "console=ttyS0"}, "console=ttyS0"},
+		{"initrd", DStackMetadata{Cmdline: "console=ttyS0", Initrd: "initramfs.cpio.gz"}, "console=ttyS0 initrd=initrd"},
+		{"rootfs hash", DStackMetadata{Cmdline: "console=ttyS0", RootfsHash: hash}, "console=ttyS0 dstack.rootfs_hash=" + hash},
+		{
+			"rootfs hash and initrd",
+			DStackMetadata{Cmdline: "console=ttyS0", Initrd: "initramfs.cpio.gz", RootfsHash: hash},
+			"console=ttyS0 dstack.rootfs_hash=" + hash + " initrd=initrd",
+		},
+		{
+			"rootfs hash already on the command line",
+			DStackMetadata{Cmdline: "console=ttyS0 dstack.rootfs_hash=" + hash, Initrd: "initramfs.cpio.gz", RootfsHash: hash},
+			"console=ttyS0 dstack.rootfs_hash=" + hash + " initrd=initrd",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, tt.metadata.kernelCmdline())
+		})
+	}
+}
+
+func TestDStackMetadataImageInfo(t *testing.T) {
+	var none *DStackMetadata
+	require.Nil(t, none.imageInfo())
+	require.Nil(t, (&DStackMetadata{Bios: "ovmf.fd", Kernel: "bzImage"}).imageInfo())
+
+	tests := []struct {
+		metadata DStackMetadata
+		want     string
+	}{
+		{DStackMetadata{Version: "0.4.2"}, "0.4.2"},
+		{DStackMetadata{Version: "0.4.2", GitRevision: "1a2b3c"}, "0.4.2 (git 1a2b3c)"},
+		{DStackMetadata{Version: "0.4.2", GitRevision: "1a2b3c", IsDev: true}, "0.4.2 (git 1a2b3c, dev)"},
+		{DStackMetadata{GitRevision: "1a2b3c"}, "unknown version (git 1a2b3c)"},
+		{DStackMetadata{Rootfs: "rootfs.img", RootfsHash: testRootfsHash}, "unknown version"},
+	}
+	for _, tt := range tests {
+		info := tt.metadata.imageInfo()
+		require.NotNil(t, info, "%+v", tt.metadata)
+		require.Equal(t, tt.want, info.String())
+		require.Equal(t, tt.metadata.Version, info.Version)
+		require.Equal(t, tt.metadata.RootfsHash, info.RootfsHash)
+	}
+}
+
+func TestReadDStackMetadata(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "metadata.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{
+  "bios": "ovmf.fd",
+  "kernel": "bzImage",
+  "cmdline": "console=ttyS0 init=/init",
+  "initrd": "initramfs.cpio.gz",
+  "rootfs": "rootfs.img.verity",
+  "rootfs_hash": "`+testRootfsHash+`",
+  "shared_ro": true,
+  "version": "0.4.2",
+  "git_revision": "1a2b3c",
+  "is_dev": true
+}`), 0o644))
+	m, err := readDStackMetadata(path)
+	require.NoError(t, err)
+	require.Equal(t, &DStackMetadata{
+		Bios:        "ovmf.fd",
+		Kernel:      "bzImage",
+		Cmdline:     "console=ttyS0 init=/init",
+		Initrd:      "initramfs.cpio.gz",
+		Rootfs:      "rootfs.img.verity",
+		RootfsHash:  testRootfsHash,
+		SharedRO:    true,
+		Version:     "0.4.2",
+		GitRevision: "1a2b3c",
+		IsDev:       true,
+	}, m)
+
+	// The image paths are resolved relative to the metadata and the command line is completed.
+	cfg := measureConfig{metadataPath: path}
+	require.NoError(t, cfg.resolveInputs())
+	require.Equal(t, filepath.Join(dir, "ovmf.fd"), cfg.fwPath)
+	require.Equal(t, filepath.Join(dir, "bzImage"), cfg.kernelPath)
+	require.Equal(t, filepath.Join(dir, "initramfs.cpio.gz"), cfg.initrdPath)
+	require.Equal(t, "console=ttyS0 init=/init dstack.rootfs_hash="+testRootfsHash+" initrd=initrd", cfg.kernelCmdline)
+	require.Equal(t, "0.4.2 (git 1a2b3c, dev)", cfg.metadata.imageInfo().String())
+
+	require.NoError(t, os.WriteFile(path, []byte(`{"bios": "ovmf.fd", "rootfs_hash": "00"}`), 0o644))
+	_, err = readDStackMetadata(path)
+	require.Error(t, err)
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// outputFormats are the formats the measurements can be printed in.
+var outputFormats = []string{"text", "json", "yaml", "env", "kms-policy"}
+
+// inputFileOutput identifies an input file by its path and content.
+type inputFileOutput struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// inputsOutput lists the input files the measurements were computed from.
+type inputsOutput struct {
+	Metadata    *inputFileOutput `json:"metadata,omitempty"`
+	Firmware    *inputFileOutput `json:"firmware"`
+	Kernel      *inputFileOutput `json:"kernel"`
+	Initrd      *inputFileOutput `json:"initrd,omitempty"`
+	Devices     *inputFileOutput `json:"devices,omitempty"`
+	NUMA        *inputFileOutput `json:"numa,omitempty"`
+	PK          *inputFileOutput `json:"pk,omitempty"`
+	KEK         *inputFileOutput `json:"kek,omitempty"`
+	DB          *inputFileOutput `json:"db,omitempty"`
+	DBX         *inputFileOutput `json:"dbx,omitempty"`
+	EventLog    *inputFileOutput `json:"rtmr3_events,omitempty"`
+	KeyProvider *inputFileOutput `json:"key_provider,omitempty"`
+}
+
+// vmConfigOutput is the VM configuration the measurements were computed for.
+type vmConfigOutput struct {
+	Memory        string `json:"memory"`
+	MemorySize    uint64 `json:"memory_size"`
+	MaxRAMBelow4G uint64 `json:"max_ram_below_4g,omitempty"`
+	PCIHole64Size uint64 `json:"pci_hole64_size,omitempty"`
+	CPUCount      uint   `json:"cpu"`
+	Sockets       uint   `json:"sockets"`
+	Cmdline       string `json:"cmdline"`
+	// MRTDVariants lists the selected MRTD variants, the first one being the reported MRTD.
+	MRTDVariants []string `json:"mrtd_variants"`
+	SecureBoot   bool     `json:"secure_boot"`
+	// RuntimeEvents lists the RTMR3 events given with -rtmr3-event, as name=hex-payload.
+	RuntimeEvents []string `json:"rtmr3_events,omitempty"`
+}
+
+// hashInputFile returns the description of an input file, or nil if no path is given.
+func hashInputFile(path string) (*inputFileOutput, error) {
+	if path == "" {
+		return nil, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return nil, fmt.Errorf("hashing %s: %w", path, err)
+	}
+	return &inputFileOutput{Path: path, Size: size, SHA256: hex.EncodeToString(h.Sum(nil))}, nil
+}
+
+// inputs hashes the resolved input files, including the key provider descriptor, if any.
+func (c *measureConfig) inputs(keyProviderPath string) (*inputsOutput, error) {
+	var inputs inputsOutput
+	for _, file := range []struct {
+		output **inputFileOutput
+		path   string
+	}{
+		{&inputs.Metadata, c.metadataPath},
+		{&inputs.Firmware, c.fwPath},
+		{&inputs.Kernel, c.kernelPath},
+		{&inputs.Initrd, c.initrdPath},
+		{&inputs.Devices, c.devicesPath},
+		{&inputs.NUMA, c.numaPath},
+		{&inputs.PK, c.pkPath},
+		{&inputs.KEK, c.kekPath},
+		{&inputs.DB, c.dbPath},
+		{&inputs.DBX, c.dbxPath},
+		{&inputs.EventLog, c.runtimeEventsPath},
+		{&inputs.KeyProvider, keyProviderPath},
+	} {
+		var err error
+		if *file.output, err = hashInputFile(file.path); err != nil {
+			return nil, err
+		}
+	}
+	return &inputs, nil
+}
+
+// vmConfig returns the VM configuration given on the command line.
+func (c *measureConfig) vmConfig() *vmConfigOutput {
+	config := &vmConfigOutput{
+		Memory:        formatSize(uint64(c.memorySize)),
+		MemorySize:    uint64(c.memorySize),
+		MaxRAMBelow4G: uint64(c.maxRAMBelow4G),
+		PCIHole64Size: uint64(c.pciHole64Size),
+		CPUCount:      c.cpuCount,
+		Sockets:       c.sockets,
+		Cmdline:       c.kernelCmdline,
+		SecureBoot:    c.secureBootFromFw || c.pkPath != "" || c.kekPath != "" || c.dbPath != "" || c.dbxPath != "",
+	}
+	for _, variant := range c.mrtdVariants {
+		config.MRTDVariants = append(config.MRTDVariants, variant.String())
+	}
+	for _, e := range c.runtimeEvents {
+		config.RuntimeEvents = append(config.RuntimeEvents, fmt.Sprintf("%s=%x", e.Name, e.Payload))
+	}
+	return config
+}
+
+// kmsPolicyOutput is a snippet for the dstack KMS allowlist or the on-chain registration of an
+// image: the measurements it boots with, with 0x-prefixed hex values, for each MRTD variant.
+type kmsPolicyOutput struct {
+	Image         *imageInfo       `json:"image,omitempty"`
+	MrKeyProvider string           `json:"mr_key_provider"`
+	Measurements  []kmsPolicyEntry `json:"measurements"`
+	Inputs        *inputsOutput    `json:"inputs"`
+	Config        *vmConfigOutput  `json:"config"`
+}
+
+type kmsPolicyEntry struct {
+	MRTDVariant string            `json:"mrtd_variant"`
+	MrEnclave   string            `json:"mr_enclave"`
+	MrImage     string            `json:"mr_image"`
+	MRTD        string            `json:"mrtd"`
+	RTMR0       string            `json:"rtmr0"`
+	RTMR1       string            `json:"rtmr1"`
+	RTMR2       string            `json:"rtmr2"`
+	RTMR3       string            `json:"rtmr3,omitempty"`
+	Aggregates  map[string]string `json:"aggregates,omitempty"`
+}
+
+// newKMSPolicy converts the measurements into a KMS policy snippet.
+func newKMSPolicy(output *measurementOutput) *kmsPolicyOutput {
+	prefixed := func(value string) string {
+		if value == "" {
+			return ""
+		}
+		return "0x" + value
+	}
+	prefixedMap := func(values map[string]string) map[string]string {
+		if values == nil {
+			return nil
+		}
+		result := make(map[string]string, len(values))
+		for name, value := range values {
+			result[name] = prefixed(value)
+		}
+		return result
+	}
+
+	variants := output.MRTDVariants
+	if len(variants) == 0 {
+		variants = []mrtdVariantOutput{{
+			Variant:    output.Config.MRTDVariants[0],
+			MRTD:       output.MRTD,
+			MrEnclave:  output.MrEnclave,
+			MrImage:    output.MrImage,
+			Aggregates: output.Aggregates,
+		}}
+	}
+	policy := &kmsPolicyOutput{
+		Image:         output.Image,
+		MrKeyProvider: prefixed(output.MrKeyProvider),
+		Inputs:        output.Inputs,
+		Config:        output.Config,
+	}
+	for _, v := range variants {
+		policy.Measurements = append(policy.Measurements, kmsPolicyEntry{
+			MRTDVariant: v.Variant,
+			MrEnclave:   prefixed(v.MrEnclave),
+			MrImage:     prefixed(v.MrImage),
+			MRTD:        prefixed(v.MRTD),
+			RTMR0:       prefixed(output.RTMR0),
+			RTMR1:       prefixed(output.RTMR1),
+			RTMR2:       prefixed(output.RTMR2),
+			RTMR3:       prefixed(output.RTMR3),
+			Aggregates:  prefixedMap(v.Aggregates),
+		})
+	}
+	return policy
+}
+
+// writeMeasurementOutput writes the measurements in the given format, other than text.
+func writeMeasurementOutput(w io.Writer, format string, output *measurementOutput) error {
+	var v any = output
+	if format == "kms-policy" {
+		v = newKMSPolicy(output)
+	}
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	switch format {
+	case "yaml":
+		if data, err = jsonToYAML(data); err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
+	case "env":
+		return writeEnv(w, data)
+	}
+	_, err = fmt.Fprintln(w, string(data))
+	return err
+}
+
+// writeEnv writes the fields of a JSON document as KEY=value lines, as read by shells and
+// docker compose. Nested field names are joined with underscores, e.g. INPUTS_KERNEL_SHA256,
+// and list items are numbered from 0. Values with other characters than letters, digits and
+// "_.,:/=+-@%" are single quoted.
+func writeEnv(w io.Writer, data []byte) error {
+	value, err := decodeOrderedJSON(data)
+	if err != nil {
+		return err
+	}
+	var lines []string
+	var flatten func(key string, v any)
+	flatten = func(key string, v any) {
+		join := func(name string) string {
+			if key == "" {
+				return envKey(name)
+			}
+			return key + "_" + envKey(name)
+		}
+		switch v := v.(type) {
+		case []jsonField:
+			for _, f := range v {
+				flatten(join(f.key), f.value)
+			}
+		case []any:
+			for i, item := range v {
+				flatten(join(strconv.Itoa(i)), item)
+			}
+		case nil:
+			lines = append(lines, key+"=")
+		default:
+			lines = append(lines, key+"="+envValue(fmt.Sprint(v)))
+		}
+	}
+	flatten("", value)
+	_, err = io.WriteString(w, strings.Join(lines, "\n")+"\n")
+	return err
+}
+
+var envKeyInvalidChars = regexp.MustCompile(`[^A-Z0-9_]`)
+
+func envKey(name string) string {
+	return envKeyInvalidChars.ReplaceAllString(strings.ToUpper(name), "_")
+}
+
+var envPlainValuePattern = regexp.MustCompile(`^[A-Za-z0-9_.,:/=+@%-]*$`)
+
+func envValue(value string) string {
+	if envPlainValuePattern.MatchString(value) {
+		return value
+	}
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testMeasurementOutput returns the output of a measurement with two MRTD variants.
+func testMeasurementOutput() *measurementOutput {
+	return &measurementOutput{
+		Image:         &imageInfo{Version: "0.5.0", RootfsHash: "0e"},
+		MRTD:          "01",
+		RTMR0:         "02",
+		RTMR1:         "03",
+		RTMR2:         "04",
+		MrKeyProvider: "00",
+		MrEnclave:     "05",
+		MrImage:       "06",
+		Aggregates:    map[string]string{"mr_boot": "07"},
+		MRTDVariants: []mrtdVariantOutput{
+			{Variant: "two-pass", MRTD: "01", MrEnclave: "05", MrImage: "06", Aggregates: map[string]string{"mr_boot": "07"}},
+			{Variant: "single-pass", MRTD: "11", MrEnclave: "15", MrImage: "16", Aggregates: map[string]string{"mr_boot": "17"}},
+		},
+		Inputs: &inputsOutput{
+			Firmware: &inputFileOutput{Path: "ovmf.fd", Size: 4194304, SHA256: "aa"},
+			Kernel:   &inputFileOutput{Path: "images/bz Image", Size: 12582912, SHA256: "bb"},
+		},
+		Config: &vmConfigOutput{
+			Memory:       "2G",
+			MemorySize:   2 << 30,
+			CPUCount:     8,
+			Sockets:      1,
+			Cmdline:      "console=ttyS0 init='/init' dstack.x=$HOME",
+			MRTDVariants: []string{"two-pass", "single-pass"},
+		},
+	}
+}
+
+func TestWriteMeasurementOutputEnv(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, writeMeasurementOutput(&buf, "env", testMeasurementOutput()))
+	require.Equal(t, `IMAGE_VERSION=0.5.0
+IMAGE_ROOTFS_HASH=0e
+MRTD=01
+RTMR0=02
+RTMR1=03
+RTMR2=04
+MR_KEY_PROVIDER=00
+MR_ENCLAVE=05
+MR_IMAGE=06
+AGGREGATES_MR_BOOT=07
+MRTD_VARIANTS_0_VARIANT=two-pass
+MRTD_VARIANTS_0_MRTD=01
+MRTD_VARIANTS_0_MR_ENCLAVE=05
+MRTD_VARIANTS_0_MR_IMAGE=06
+MRTD_VARIANTS_0_AGGREGATES_MR_BOOT=07
+MRTD_VARIANTS_1_VARIANT=single-pass
+MRTD_VARIANTS_1_MRTD=11
+MRTD_VARIANTS_1_MR_ENCLAVE=15
+MRTD_VARIANTS_1_MR_IMAGE=16
+MRTD_VARIANTS_1_AGGREGATES_MR_BOOT=17
+INPUTS_FIRMWARE_PATH=ovmf.fd
+INPUTS_FIRMWARE_SIZE=4194304
+INPUTS_FIRMWARE_SHA256=aa
+INPUTS_KERNEL_PATH='images/bz Image'
+INPUTS_KERNEL_SIZE=12582912
+INPUTS_KERNEL_SHA256=bb
+CONFIG_MEMORY=2G
+CONFIG_MEMORY_SIZE=2147483648
+CONFIG_CPU=8
+CONFIG_SOCKETS=1
+CONFIG_CMDLINE='console=ttyS0 init='\''/init'\'' dstack.x=$HOME'
+CONFIG_MRTD_VARIANTS_0=two-pass
+CONFIG_MRTD_VARIANTS_1=single-pass
+CONFIG_SECURE_BOOT=false
+`, buf.String())
+}
+
+func TestWriteEnv(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, writeEnv(&buf, []byte(`{"a-b.c": {"x y": null, "list": [[1, 2], {"k": true}]}, "empty": {}}`)))
+	require.Equal(t, "A_B_C_X_Y=\nA_B_C_LIST_0_0=1\nA_B_C_LIST_0_1=2\nA_B_C_LIST_1_K=true\n", buf.String())
+}
+
+func TestEnvValue(t *testing.T) {
+	for value, want := range map[string]string{
+		"":                      "",
+		"0x0123abcdef":          "0x0123abcdef",
+		"a_b.c,d:e/f=g+h@i%j-k": "a_b.c,d:e/f=g+h@i%j-k",
+		"a b":                   "'a b'",
+		"it's":                  `'it'\''s'`,
+		"$HOME":                 "'$HOME'",
+		"`id`":                  "'`id`'",
+		`a"b\c`:                 `'a"b\c'`,
+		"a\nb":                  "'a\nb'",
+		"#comment":              "'#comment'",
+		"café":                  "'café'",
+	} {
+		require.Equal(t, want, envValue(value), "%q", value)
+	}
+}
+
+func TestWriteMeasurementOutputKMSPolicy(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, writeMeasurementOutput(&buf, "kms-policy", testMeasurementOutput()))
+	require.JSONEq(t, `{
+  "image": {"version": "0.5.0", "rootfs_hash": "0e"},
+  "mr_key_provider": "0x00",
+  "measurements": [
+    {"mrtd_variant": "two-pass", "mr_enclave": "0x05", "mr_image": "0x06", "mrtd": "0x01", "rtmr0": "0x02", "rtmr1": "0x03", "rtmr2": "0x04", "aggregates": {"mr_boot": "0x07"}},
+    {"mrtd_variant": "single-pass", "mr_enclave": "0x15", "mr_image": "0x16", "mrtd": "0x11", "rtmr0": "0x02", "rtmr1": "0x03", "rtmr2": "0x04", "aggregates": {"mr_boot": "0x17"}}
+  ],
+  "inputs": {
+    "firmware": {"path": "ovmf.fd", "size": 4194304, "sha256": "aa"},
+    "kernel": {"path": "images/bz Image", "size": 12582912, "sha256": "bb"}
+  },
+  "config": {"memory": "2G", "memory_size": 2147483648, "cpu": 8, "sockets": 1, "cmdline": "console=ttyS0 init='/init' dstack.x=$HOME", "mrtd_variants": ["two-pass", "single-pass"], "secure_boot": false}
+}`, buf.String())
+
+	// With a single MRTD variant, the measurements are the reported ones.
+	output := testMeasurementOutput()
+	output.MRTDVariants = nil
+	output.Aggregates = nil
+	output.RTMR3 = "08"
+	policy := newKMSPolicy(output)
+	require.Equal(t, []kmsPolicyEntry{{
+		MRTDVariant: "two-pass",
+		MrEnclave:   "0x05",
+		MrImage:     "0x06",
+		MRTD:        "0x01",
+		RTMR0:       "0x02",
+		RTMR1:       "0x03",
+		RTMR2:       "0x04",
+		RTMR3:       "0x08",
+	}}, policy.Measurements)
+}
+
+func TestWriteMeasurementOutputYAML(t *testing.T) {
+	output := testMeasurementOutput()
+	output.MRTDVariants = nil
+	output.Aggregates = map[string]string{}
+	var buf bytes.Buffer
+	require.NoError(t, writeMeasurementOutput(&buf, "yaml", output))
+	require.Equal(t, `image:
+  version: "0.5.0"
+  rootfs_hash: "0e"
+mrtd: "01"
+rtmr0: "02"
+rtmr1: "03"
+rtmr2: "04"
+mr_key_provider: "00"
+mr_enclave: "05"
+mr_image: "06"
+inputs:
+  firmware:
+    path: "ovmf.fd"
+    size: 4194304
+    sha256: "aa"
+  kernel:
+    path: "images/bz Image"
+    size: 12582912
+    sha256: "bb"
+config:
+  memory: "2G"
+  memory_size: 2147483648
+  cpu: 8
+  sockets: 1
+  cmdline: "console=ttyS0 init='/init' dstack.x=$HOME"
+  mrtd_variants:
+    - "two-pass"
+    - "single-pass"
+  secure_boot: false
+`, buf.String())
+
+	// The YAML document reads back as the JSON one.
+	roundTrip, err := yamlToJSON(buf.Bytes())
+	require.NoError(t, err)
+	want, err := json.Marshal(output)
+	require.NoError(t, err)
+	require.JSONEq(t, string(want), string(roundTrip))
+}
+
+func TestJSONToYAML(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{`null`, "null\n"},
+		{`"0123"`, "\"0123\"\n"},
+		{`[]`, "[]\n"},
+		{`{"a": {}, "b": [], "c": [{}, []]}`, "a: {}\nb: []\nc:\n  - {}\n  - []\n"},
+		{`{"true": 1, "1": 1.5, "null": -2e3, "a b": "x\ty\"é"}`, "\"true\": 1\n\"1\": 1.5\n\"null\": -2e3\na b: \"x\\ty\\\"é\"\n"},
+		{`[{"a": [1, {"b": "c"}]}]`, "- a:\n    - 1\n    - b: \"c\"\n"},
+	}
+	for _, tt := range tests {
+		got, err := jsonToYAML([]byte(tt.input))
+		require.NoError(t, err)
+		require.Equal(t, tt.want, string(got), tt.input)
+
+		roundTrip, err := yamlToJSON(got)
+		require.NoError(t, err)
+		require.JSONEq(t, tt.input, string(roundTrip))
+	}
+}
@@ -0,0 +1,362 @@
+package tdxmeasure
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// ACPI table layout of the QEMU q35 machine.
+const (
+	acpiBuildTableSize = 0x20000 // Size the table blob is padded to.
+
+	qemuIOAPICAddress  = 0xfec00000
+	qemuLAPICAddress   = 0xfee00000
+	qemuMCFGBase       = 0xe0000000
+	qemuMCFGSize       = 0x10000000
+	qemuPCIHole64Start = 0x380000000000
+	qemuPCIHole64Size  = 0x800000000
+)
+
+// acpiTableBuilder accumulates the ACPI tables of the etc/acpi/tables blob and the table loader
+// commands that link and checksum them.
+type acpiTableBuilder struct {
+	data []byte
+	ldr  []byte
+}
+
+// begin starts a table with a standard header and returns its offset.
+func (b *acpiTableBuilder) begin(signature string, revision uint8) uint32 {
+	offset := uint32(len(b.data))
+	b.data = append(b.data, signature...)
+	b.data = binary.LittleEndian.AppendUint32(b.data, 0) // Length, set by end.
+	b.data = append(b.data, revision, 0)                 // Checksum, computed by the loader.
+	b.data = append(b.data, "BOCHS "...)                 // OEM ID.
+	b.data = append(b.data, "BXPC    "...)               // OEM table ID.
+	b.data = binary.LittleEndian.AppendUint32(b.data, 1) // OEM revision.
+	b.data = append(b.data, "BXPC"...)                   // Creator ID.
+	b.data = binary.LittleEndian.AppendUint32(b.data, 1) // Creator revision.
+	return offset
+}
+
+// end finalizes the table started at offset and adds the loader command computing its checksum.
+func (b *acpiTableBuilder) end(offset uint32) {
+	length := uint32(len(b.data)) - offset
+	binary.LittleEndian.PutUint32(b.data[offset+4:], length)
+	b.ldr = qemuLoaderAppend(b.ldr, &qemuLoaderCmdAddChecksum{"etc/acpi/tables", offset + 9, offset, length})
+}
+
+func (b *acpiTableBuilder) appendInt(v uint64, size int) {
+	b.data = amlAppendIntNoPrefix(b.data, v, size)
+}
+
+// appendPointer appends a pointer of the given size to another table of the blob and adds the
+// loader command relocating it.
+func (b *acpiTableBuilder) appendPointer(target uint32, size int) {
+	b.ldr = qemuLoaderAppend(b.ldr, &qemuLoaderCmdAddPtr{
+		"etc/acpi/tables", "etc/acpi/tables", uint32(len(b.data)), uint8(size),
+	})
+	b.appendInt(uint64(target), size)
+}
+
+// appendGAS appends a Generic Address Structure for a system I/O register.
+func (b *acpiTableBuilder) appendGAS(bitWidth uint8, address uint64) {
+	var spaceID uint8
+	if address != 0 {
+		spaceID = amlSystemIO
+	}
+	b.data = append(b.data, spaceID, bitWidth, 0, 0)
+	b.appendInt(address, 8)
+}
+
+// buildFACS builds the Firmware ACPI Control Structure, which has no standard header.
+func (b *acpiTableBuilder) buildFACS() uint32 {
+	offset := uint32(len(b.data))
+	b.data = append(b.data, "FACS"...)
+	b.appendInt(64, 4)
+	b.data = append(b.data, make([]byte, 56)...)
+	return offset
+}
+
+// buildFADT builds the revision 3 Fixed ACPI Description Table of the ICH9 power management
+// block.
+func (b *acpiTableBuilder) buildFADT(facs, dsdt uint32, cpuCount int) uint32 {
+	const (
+		pm1aEvtBlk = 0x600
+		pm1aCntBlk = 0x604
+		pmTmrBlk   = 0x608
+		gpe0Blk    = 0x620
+		gpe0BlkLen = 0x10
+		resetReg   = 0xcf9
+	)
+	// WBINVD, PROC_C1, SLP_BUTTON, RTC_S4, RESET_REG_SUP and USE_PLATFORM_CLOCK.
+	flags := uint32(1<<0 | 1<<2 | 1<<5 | 1<<7 | 1<<10 | 1<<15)
+	if cpuCount > 8 {
+		flags |= 1 << 18 // FORCE_APIC_CLUSTER_MODEL
+	}
+
+	offset := b.begin("FACP", 3)
+	b.appendPointer(facs, 4) // FIRMWARE_CTRL
+	b.appendPointer(dsdt, 4) // DSDT
+	b.appendInt(1, 1)        // INT_MODEL
+	b.appendInt(0, 1)        // Preferred_PM_Profile
+	b.appendInt(9, 2)        // SCI_INT
+	b.appendInt(0, 4)        // SMI_CMD
+	b.appendInt(0, 1)        // ACPI_ENABLE
+	b.appendInt(0, 1)        // ACPI_DISABLE
+	b.appendInt(0, 1)        // S4BIOS_REQ
+	b.appendInt(0, 1)        // PSTATE_CNT
+	b.appendInt(pm1aEvtBlk, 4)
+	b.appendInt(0, 4) // PM1b_EVT_BLK
+	b.appendInt(pm1aCntBlk, 4)
+	b.appendInt(0, 4) // PM1b_CNT_BLK
+	b.appendInt(0, 4) // PM2_CNT_BLK
+	b.appendInt(pmTmrBlk, 4)
+	b.appendInt(gpe0Blk, 4)
+	b.appendInt(0, 4)          // GPE1_BLK
+	b.appendInt(4, 1)          // PM1_EVT_LEN
+	b.appendInt(2, 1)          // PM1_CNT_LEN
+	b.appendInt(0, 1)          // PM2_CNT_LEN
+	b.appendInt(4, 1)          // PM_TMR_LEN
+	b.appendInt(gpe0BlkLen, 1) // GPE0_BLK_LEN
+	b.appendInt(0, 1)          // GPE1_BLK_LEN
+	b.appendInt(0, 1)          // GPE1_BASE
+	b.appendInt(0, 1)          // CST_CNT
+	b.appendInt(0xfff, 2)      // P_LVL2_LAT
+	b.appendInt(0xfff, 2)      // P_LVL3_LAT
+	b.appendInt(0, 2)          // FLUSH_SIZE
+	b.appendInt(0, 2)          // FLUSH_STRIDE
+	b.appendInt(0, 1)          // DUTY_OFFSET
+	b.appendInt(0, 1)          // DUTY_WIDTH
+	b.appendInt(0, 1)          // DAY_ALRM
+	b.appendInt(0, 1)          // MON_ALRM
+	b.appendInt(0x32, 1)       // CENTURY
+	b.appendInt(2, 2)          // IAPC_BOOT_ARCH: 8042 present.
+	b.appendInt(0, 1)          // Reserved
+	b.appendInt(uint64(flags), 4)
+	b.appendGAS(8, resetReg) // RESET_REG
+	b.appendInt(0xf, 1)      // RESET_VALUE
+	b.appendInt(0, 2)        // ARM_BOOT_ARCH
+	b.appendInt(0, 1)        // FADT Minor Version
+	b.appendInt(0, 8)        // X_FIRMWARE_CTRL
+	b.appendPointer(dsdt, 8) // X_DSDT
+	b.appendGAS(32, pm1aEvtBlk)
+	b.appendGAS(0, 0) // X_PM1b_EVT_BLK
+	b.appendGAS(16, pm1aCntBlk)
+	b.appendGAS(0, 0) // X_PM1b_CNT_BLK
+	b.appendGAS(0, 0) // X_PM2_CNT_BLK
+	b.appendGAS(32, pmTmrBlk)
+	b.appendGAS(gpe0BlkLen*8, gpe0Blk)
+	b.appendGAS(0, 0) // X_GPE1_BLK
+	b.end(offset)
+	return offset
+}
+
+// madtCPU returns the MADT entry of a CPU, which is also used as its _MAT object in the DSDT.
+func madtCPU(uid int, apicID uint32) []byte {
+	const flags = 1 // Enabled.
+	if apicID < 255 {
+		// Processor Local APIC: type, length, ACPI processor UID, APIC ID and flags.
+		entry := []byte{0x00, 0x08, byte(uid), byte(apicID)}
+		return binary.LittleEndian.AppendUint32(entry, flags)
+	}
+	// Processor Local x2APIC: type, length, reserved, x2APIC ID, flags and ACPI processor UID.
+	entry := []byte{0x09, 0x10, 0x00, 0x00}
+	entry = binary.LittleEndian.AppendUint32(entry, apicID)
+	entry = binary.LittleEndian.AppendUint32(entry, flags)
+	return binary.LittleEndian.AppendUint32(entry, uint32(uid))
+}
+
+// buildMADT builds the Multiple APIC Description Table.
+func (b *acpiTableBuilder) buildMADT(m *qemuMachine) uint32 {
+	offset := b.begin("APIC", 3)
+	b.appendInt(qemuLAPICAddress, 4)
+	b.appendInt(1, 4) // Flags: PCAT_COMPAT
+	for i, apicID := range m.apicIDs {
+		b.data = append(b.data, madtCPU(i, apicID)...)
+	}
+
+	// I/O APIC.
+	b.data = append(b.data, 0x01, 0x0c, 0x00, 0x00)
+	b.appendInt(qemuIOAPICAddress, 4)
+	b.appendInt(0, 4) // Global system interrupt base.
+
+	// Interrupt source overrides for the legacy IRQs, IRQ0 is routed to GSI 2.
+	for irq := 0; irq < 16; irq++ {
+		gsi := irq
+		if irq == 0 {
+			gsi = 2
+		}
+		b.data = append(b.data, 0x02, 0x0a, 0x00, byte(irq))
+		b.appendInt(uint64(gsi), 4)
+		b.appendInt(0x5, 2) // Flags: active high, edge triggered.
+	}
+
+	// NMI on LINT1 of all processors.
+	if m.x2APICMode() {
+		// Local x2APIC NMI: type, length, flags, ACPI processor UID, LINT# and reserved.
+		b.data = append(b.data, 0x0a, 0x0c, 0x00, 0x00)
+		b.appendInt(0xffffffff, 4)
+		b.data = append(b.data, 0x01, 0x00, 0x00, 0x00)
+	} else {
+		// Local APIC NMI: type, length, ACPI processor ID, flags and LINT#.
+		b.data = append(b.data, 0x04, 0x06, 0xff, 0x00, 0x00, 0x01)
+	}
+	b.end(offset)
+	return offset
+}
+
+// buildMCFG builds the PCI Express memory mapped configuration space table.
+func (b *acpiTableBuilder) buildMCFG() uint32 {
+	offset := b.begin("MCFG", 1)
+	b.appendInt(0, 8) // Reserved
+	b.appendInt(qemuMCFGBase, 8)
+	b.appendInt(0, 2)                      // PCI segment group.
+	b.appendInt(0, 1)                      // Start bus number.
+	b.appendInt(qemuMCFGSize/(1<<20)-1, 1) // End bus number.
+	b.appendInt(0, 4)                      // Reserved
+	b.end(offset)
+	return offset
+}
+
+// buildWAET builds the Windows ACPI Emulated Devices Table.
+func (b *acpiTableBuilder) buildWAET() uint32 {
+	offset := b.begin("WAET", 1)
+	b.appendInt(1<<1, 4) // ACPI PM timer good.
+	b.end(offset)
+	return offset
+}
+
+// buildRSDT builds the Root System Description Table referencing the given tables.
+func (b *acpiTableBuilder) buildRSDT(tables ...uint32) uint32 {
+	offset := b.begin("RSDT", 1)
+	for _, t := range tables {
+		b.appendPointer(t, 4)
+	}
+	b.end(offset)
+	return offset
+}
+
+// GenerateTablesQemu generates ACPI tables for the given TD configuration.
+//
+// The tables replicate those generated by QEMU's q35 machine for a TD with the memory size, CPUs,
+// devices and NUMA topology of opts; the firmware and boot inputs are ignored.
+//
+// Returns the raw ACPI tables, RSDP and QEMU table loader command blob.
+func GenerateTablesQemu(opts Options) ([]byte, []byte, []byte, error) {
+	m, err := newQemuMachine(&opts)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	var b acpiTableBuilder
+	b.ldr = qemuLoaderAppend(nil, &qemuLoaderCmdAllocate{"etc/acpi/rsdp", 16, 2})
+	b.ldr = qemuLoaderAppend(b.ldr, &qemuLoaderCmdAllocate{"etc/acpi/tables", 64, 1})
+
+	facs := b.buildFACS()
+	dsdt := b.buildDSDT(m)
+	tables := []uint32{b.buildFADT(facs, dsdt, len(m.apicIDs)), b.buildMADT(m)}
+	if m.numa != nil {
+		tables = append(tables, b.buildSRAT(m))
+		if m.numa.Distances != nil {
+			tables = append(tables, b.buildSLIT(m))
+		}
+	}
+	tables = append(tables, b.buildMCFG(), b.buildWAET())
+	rsdt := b.buildRSDT(tables...)
+
+	// The blob is padded so that its size doesn't change with the configuration.
+	blob := append(b.data, make([]byte, (acpiBuildTableSize-len(b.data)%acpiBuildTableSize)%acpiBuildTableSize)...)
+
+	// Generate RSDP.
+	rsdp := append([]byte{},
+		0x52, 0x53, 0x44, 0x20, 0x50, 0x54, 0x52, 0x20, // Signature ("RSDP PTR ").
+		0x00,                               // Checksum.
+		0x42, 0x4F, 0x43, 0x48, 0x53, 0x20, // OEM ID ("BOCHS ").
+		0x00, // Revision.
+	)
+	rsdp = binary.LittleEndian.AppendUint32(rsdp, rsdt)
+
+	// Generate table loader commands.
+	const ldrLength = 4096
+	ldr := qemuLoaderAppend(b.ldr, &qemuLoaderCmdAddPtr{"etc/acpi/rsdp", "etc/acpi/tables", 16, 4}) // RSDT address
+	ldr = qemuLoaderAppend(ldr, &qemuLoaderCmdAddChecksum{"etc/acpi/rsdp", 8, 0, 20})               // RSDP
+	if len(ldr) < ldrLength {
+		ldr = append(ldr, bytes.Repeat([]byte{0x00}, ldrLength-len(ldr))...)
+	}
+
+	return blob, rsdp, ldr, nil
+}
+
+type qemuLoaderCmdAllocate struct {
+	file      string
+	alignment uint32
+	zone      uint8
+}
+
+type qemuLoaderCmdAddPtr struct {
+	pointerFile   string
+	pointeeFile   string
+	pointerOffset uint32
+	pointerSize   uint8
+}
+
+type qemuLoaderCmdAddChecksum struct {
+	file         string
+	resultOffset uint32
+	start        uint32
+	length       uint32
+}
+
+func qemuLoaderAppend(data []byte, cmd interface{}) []byte {
+	appendFixedString := func(str string) {
+		const fixedLength = 56
+		data = append(data, []byte(str)...)
+		if len(str) < fixedLength {
+			data = append(data, bytes.Repeat([]byte{0x00}, fixedLength-len(str))...)
+		}
+	}
+
+	switch c := cmd.(type) {
+	case *qemuLoaderCmdAllocate:
+		data = append(data, 0x01, 0x00, 0x00, 0x00)
+
+		appendFixedString(c.file)
+
+		var val [4]byte
+		binary.LittleEndian.PutUint32(val[:], c.alignment)
+		data = append(data, val[:]...)
+
+		data = append(data, c.zone)
+		data = append(data, bytes.Repeat([]byte{0x00}, 63)...) // Padding.
+	case *qemuLoaderCmdAddPtr:
+		data = append(data, 0x02, 0x00, 0x00, 0x00)
+
+		appendFixedString(c.pointerFile)
+		appendFixedString(c.pointeeFile)
+
+		var val [4]byte
+		binary.LittleEndian.PutUint32(val[:], c.pointerOffset)
+		data = append(data, val[:]...)
+		data = append(data, c.pointerSize)
+		data = append(data, bytes.Repeat([]byte{0x00}, 7)...) // Padding.
+	case *qemuLoaderCmdAddChecksum:
+		data = append(data, 0x03, 0x00, 0x00, 0x00)
+
+		appendFixedString(c.file)
+
+		var val [4]byte
+		binary.LittleEndian.PutUint32(val[:], c.resultOffset)
+		data = append(data, val[:]...)
+
+		binary.LittleEndian.PutUint32(val[:], c.start)
+		data = append(data, val[:]...)
+
+		binary.LittleEndian.PutUint32(val[:], c.length)
+		data = append(data, val[:]...)
+
+		data = append(data, bytes.Repeat([]byte{0x00}, 56)...) // Padding.
+	default:
+		panic("unsupported command")
+	}
+	return data
+}
@@ -0,0 +1,619 @@
+package tdxmeasure
+
+// The DSDT is built the same way as by QEMU's hw/i386/acpi-build.c for a q35 TD, with the ICH9
+// LPC bridge, ACPI PCI hotplug on the root bus disabled and CPU hotplug enabled. The function
+// names follow their QEMU counterparts.
+
+// CPU hotplug interface names.
+const (
+	cpuHotplugResPath = `\_SB.PCI0.PRES`
+	cpuHotplugIOBase  = 0x0cd8
+	cpuHotplugRegLen  = 0x0c
+)
+
+// buildDSDT builds the Differentiated System Description Table.
+func (b *acpiTableBuilder) buildDSDT(m *qemuMachine) uint32 {
+	dsdt := &aml{}
+	dsdt.append(buildDbgAml())
+
+	sb := amlScope("_SB")
+	dev := amlDevice("PCI0")
+	dev.append(
+		amlNameDecl("_HID", amlEISAID("PNP0A08")),
+		amlNameDecl("_CID", amlEISAID("PNP0A03")),
+		amlNameDecl("_UID", amlInt(0)),
+		buildQ35OscMethod(),
+		buildPCIBridgeEDSM(),
+	)
+	sb.append(dev, buildQ35DRAMController())
+	dsdt.append(sb)
+
+	dsdt.append(buildACPIPCIHotplug())
+	buildQ35PCI0Int(dsdt)
+
+	dsdt.append(amlScope("_GPE").append(amlNameDecl("_HID", amlString("ACPI0006"))))
+	buildCPUsAml(dsdt, m)
+
+	scope := amlScope(`\_SB.PCI0`)
+	scope.append(amlNameDecl("_CRS", buildPCI0Crs(m.mem, m.devices)))
+	scope.append(buildIODevice("GPE0", "GPE0 resources", 0x0620, 0x10))
+	scope.append(buildIODevice("PHPR", "PCI Hotplug resources", 0x0cc0, 0x18))
+	dsdt.append(scope)
+
+	// Sleep states, S3 and S4 are advertised with their ICH9 SLP_TYP values.
+	scope = amlScope(`\`)
+	for _, s := range []struct {
+		name string
+		typ  uint64
+	}{{"_S3", 1}, {"_S4", 2}, {"_S5", 0}} {
+		pkg := amlPackage(4).append(amlInt(s.typ), amlInt(s.typ), amlInt(0), amlInt(0))
+		scope.append(amlNameDecl(s.name, pkg))
+	}
+	dsdt.append(scope)
+
+	dev = amlDevice("FWCF")
+	dev.append(
+		amlNameDecl("_HID", amlString("QEMU0002")),
+		amlNameDecl("_STA", amlInt(0xb)),
+		amlNameDecl("_CRS", amlResourceTemplate().append(amlIO(0x0510, 0x0510, 0x01, 0x0c))),
+	)
+	dsdt.append(amlScope(`\_SB.PCI0`).append(dev))
+
+	dsdt.append(amlScope(`\_SB`).append(buildPCIBusDevices(m.devices)))
+
+	// GPE0.1 is the PCI hotplug event, there is nothing to scan without root bus hotplug.
+	dsdt.append(amlScope("_GPE").append(amlMethod("_E01", 0, false)))
+
+	offset := b.begin("DSDT", 1)
+	b.data = append(b.data, dsdt.buf...)
+	b.end(offset)
+	return offset
+}
+
+// buildDbgAml builds the DBUG method writing to the QEMU debug console.
+func buildDbgAml() *aml {
+	scope := amlScope(`\`)
+	debugPort := amlName("DBGB")
+	str, buf := amlLocal(0), amlLocal(0)
+	length, idx := amlLocal(1), amlLocal(2)
+
+	scope.append(amlOperationRegion("DBG", amlSystemIO, amlInt(0x0402), 0x01))
+	scope.append(amlField("DBG", amlByteAcc, amlNoLock, amlPreserve).append(amlNamedField("DBGB", 8)))
+
+	method := amlMethod("DBUG", 1, false)
+	method.append(
+		amlToHexString(amlArg(0), str),
+		amlToBuffer(str, buf),
+		amlSubtract(amlSizeof(buf), amlInt(1), length),
+		amlStore(amlInt(0), idx),
+		amlWhile(amlLLess(idx, length)).append(
+			amlStore(amlDerefOf(amlIndex(buf, idx)), debugPort),
+			amlIncrement(idx),
+		),
+		amlStore(amlInt(0x0a), debugPort),
+	)
+	return scope.append(method)
+}
+
+// buildQ35OscMethod builds the PCI host bridge _OSC method, which grants the OS control of PME
+// and AER but not of native PCIe hotplug.
+func buildQ35OscMethod() *aml {
+	cdw1, ctrl := amlName("CDW1"), amlLocal(0)
+
+	method := amlMethod("_OSC", 4, false)
+	method.append(amlCreateDWordField(amlArg(3), amlInt(0), "CDW1"))
+
+	ifCtx := amlIf(amlEqual(amlArg(0), amlToUUID("33DB4D5B-1FF7-401C-9657-7441C03DD766")))
+	ifCtx.append(
+		amlCreateDWordField(amlArg(3), amlInt(4), "CDW2"),
+		amlCreateDWordField(amlArg(3), amlInt(8), "CDW3"),
+		amlStore(amlName("CDW3"), ctrl),
+		amlAnd(ctrl, amlInt(0x1e), ctrl),
+		amlIf(amlLNot(amlEqual(amlArg(1), amlInt(1)))).append(amlOr(cdw1, amlInt(0x08), cdw1)),
+		amlIf(amlLNot(amlEqual(amlName("CDW3"), ctrl))).append(amlOr(cdw1, amlInt(0x10), cdw1)),
+		amlStore(ctrl, amlName("CDW3")),
+	)
+	method.append(ifCtx)
+	method.append(amlElse().append(amlOr(cdw1, amlInt(0x04), cdw1)))
+	return method.append(amlReturn(amlArg(3)))
+}
+
+// pciDSMUUID is the UUID of the PCI firmware _DSM functions.
+const pciDSMUUID = "E5C937D0-3553-4D7A-9117-EA4D19C3434D"
+
+// buildDSMQuery builds the start of _DSM function 0, returning an empty function set for unknown
+// UUIDs or revisions.
+func buildDSMQuery(ret *aml) []*aml {
+	return []*aml{
+		amlStore(amlBuffer([]byte{0x00}), ret),
+		amlIf(amlLNot(amlEqual(amlArg(0), amlToUUID(pciDSMUUID)))).append(amlReturn(ret)),
+		amlIf(amlLLess(amlArg(1), amlInt(2))).append(amlReturn(ret)),
+	}
+}
+
+// buildPCIBridgeEDSM builds the EDSM method reporting the ACPI index of non-hotpluggable devices.
+func buildPCIBridgeEDSM() *aml {
+	ret, acpiIdx := amlLocal(0), amlLocal(1)
+
+	method := amlMethod("EDSM", 5, true)
+	ifCtx := amlIf(amlEqual(amlArg(2), amlInt(0)))
+	ifCtx.append(buildDSMQuery(ret)...)
+	ifCtx.append(
+		// Functions 0 and 7 are supported.
+		amlStore(amlInt(1<<0|1<<7), amlIndex(ret, amlInt(0))),
+		amlReturn(ret),
+	)
+	method.append(ifCtx)
+
+	ifCtx = amlIf(amlEqual(amlArg(2), amlInt(7)))
+	ifCtx.append(
+		amlStore(amlPackage(2).append(amlInt(0), amlString("")), ret),
+		amlStore(amlDerefOf(amlIndex(amlArg(4), amlInt(0))), acpiIdx),
+		amlStore(acpiIdx, amlIndex(ret, amlInt(0))),
+		amlReturn(ret),
+	)
+	return method.append(ifCtx)
+}
+
+// buildQ35DRAMController builds the device reserving the PCIe MMCONFIG window.
+func buildQ35DRAMController() *aml {
+	dev := amlDevice("DRAC")
+	dev.append(amlNameDecl("_HID", amlString("PNP0C01")))
+	crs := amlResourceTemplate().append(amlDWordMemory(amlNonCacheable, qemuMCFGBase, qemuMCFGBase+qemuMCFGSize-1))
+	return dev.append(amlNameDecl("_CRS", crs))
+}
+
+// buildACPIPCIHotplug builds the PCI hotplug registers and helper methods.
+func buildACPIPCIHotplug() *aml {
+	scope := amlScope("_SB.PCI0")
+	scope.append(amlOperationRegion("PCST", amlSystemIO, amlInt(0x0cc0), 0x08))
+	scope.append(amlField("PCST", amlDWordAcc, amlNoLock, amlWriteAsZeros).append(
+		amlNamedField("PCIU", 32),
+		amlNamedField("PCID", 32),
+	))
+	scope.append(amlOperationRegion("SEJ", amlSystemIO, amlInt(0x0cc8), 0x04))
+	scope.append(amlField("SEJ", amlDWordAcc, amlNoLock, amlWriteAsZeros).append(
+		amlNamedField("B0EJ", 32),
+	))
+	scope.append(amlOperationRegion("BNMR", amlSystemIO, amlInt(0x0cd0), 0x08))
+	scope.append(amlField("BNMR", amlDWordAcc, amlNoLock, amlWriteAsZeros).append(
+		amlNamedField("BNUM", 32),
+		amlNamedField("PIDX", 32),
+	))
+	scope.append(amlMutex("BLCK", 0))
+
+	method := amlMethod("PCEJ", 2, false)
+	method.append(
+		amlAcquire(amlName("BLCK"), 0xffff),
+		amlStore(amlArg(0), amlName("BNUM")),
+		amlStore(amlShiftLeft(amlInt(1), amlArg(1)), amlName("B0EJ")),
+		amlRelease(amlName("BLCK")),
+		amlReturn(amlInt(0)),
+	)
+	scope.append(method)
+
+	method = amlMethod("AIDX", 2, false)
+	method.append(
+		amlAcquire(amlName("BLCK"), 0xffff),
+		amlStore(amlArg(0), amlName("BNUM")),
+		amlStore(amlShiftLeft(amlInt(1), amlArg(1)), amlName("PIDX")),
+		amlStore(amlName("PIDX"), amlLocal(0)),
+		amlRelease(amlName("BLCK")),
+		amlReturn(amlLocal(0)),
+	)
+	scope.append(method)
+
+	return scope.append(buildPCIHotplugDSM())
+}
+
+// buildPCIHotplugDSM builds the PDSM method reporting the ACPI index of hotplugged devices.
+func buildPCIHotplugDSM() *aml {
+	ret, caps, acpiIdx := amlLocal(0), amlLocal(1), amlLocal(2)
+	aidx := amlCall("AIDX",
+		amlDerefOf(amlIndex(amlArg(4), amlInt(0))),
+		amlDerefOf(amlIndex(amlArg(4), amlInt(1))),
+	)
+
+	method := amlMethod("PDSM", 5, true)
+	ifCtx := amlIf(amlEqual(amlArg(2), amlInt(0)))
+	ifCtx.append(buildDSMQuery(ret)...)
+	ifCtx.append(
+		amlStore(amlInt(0), caps),
+		amlStore(aidx, acpiIdx),
+		// Functions 0 and 7 are supported if the device has a valid ACPI index.
+		amlIf(amlLNot(amlOr(amlEqual(acpiIdx, amlInt(0)), amlEqual(acpiIdx, amlInt(0xffffffff)), nil))).append(
+			amlOr(caps, amlInt(1), caps),
+			amlOr(caps, amlShiftLeft(amlInt(1), amlInt(7)), caps),
+		),
+		amlStore(caps, amlIndex(ret, amlInt(0))),
+		amlReturn(ret),
+	)
+	method.append(ifCtx)
+
+	ifCtx = amlIf(amlEqual(amlArg(2), amlInt(7)))
+	ifCtx.append(
+		amlStore(amlPackage(2).append(amlInt(0), amlString("")), ret),
+		amlStore(aidx, acpiIdx),
+		amlStore(acpiIdx, amlIndex(ret, amlInt(0))),
+		amlReturn(ret),
+	)
+	return method.append(ifCtx)
+}
+
+// buildQ35RoutingTable builds the PCI interrupt routing table of the root bus using the given
+// link device name prefix ("LNK" or "GSI").
+func buildQ35RoutingTable(prefix string) *aml {
+	pkg := amlPackage(128)
+	for slot := 0; slot < 32; slot++ {
+		for pin := 0; pin < 4; pin++ {
+			var link byte
+			switch {
+			case slot < 0x18+1:
+				// PCIe slots, the interrupts of the E-H links are swizzled.
+				link = 'E' + byte((slot+pin)&3)
+			case slot == 0x1e:
+				// DMI-to-PCI bridge.
+				link = 'E' + byte(pin)
+			default:
+				// ICH9 functions.
+				link = 'A' + byte(pin)
+			}
+			entry := amlPackage(4).append(
+				amlInt(uint64(slot)<<16|0xffff),
+				amlInt(uint64(pin)),
+				amlName("%s%c", prefix, link),
+				amlInt(0),
+			)
+			pkg.append(entry)
+		}
+	}
+	return pkg
+}
+
+// buildQ35PCI0Int builds the PCI interrupt routing and the interrupt link devices.
+func buildQ35PCI0Int(table *aml) {
+	// Zero => PIC mode, One => APIC mode.
+	table.append(amlNameDecl("PICF", amlInt(0)))
+	table.append(amlMethod("_PIC", 1, false).append(amlStore(amlArg(0), amlName("PICF"))))
+
+	pci0 := amlScope("PCI0")
+	pci0.append(amlNameDecl("PRTP", buildQ35RoutingTable("LNK")))
+	pci0.append(amlNameDecl("PRTA", buildQ35RoutingTable("GSI")))
+	method := amlMethod("_PRT", 0, false)
+	method.append(
+		amlIf(amlEqual(amlName("PICF"), amlInt(0))).append(amlReturn(amlName("PRTP"))),
+		amlElse().append(amlReturn(amlName("PRTA"))),
+	)
+	pci0.append(method)
+
+	sb := amlScope("_SB")
+	sb.append(pci0, buildIQSTMethod(), buildIQCRMethod())
+	for i := 0; i < 8; i++ {
+		sb.append(buildLinkDev(i))
+	}
+	for i := 0; i < 8; i++ {
+		sb.append(buildGSILinkDev(i))
+	}
+	table.append(sb)
+}
+
+// buildIQSTMethod builds the helper returning the _STA value of an interrupt link.
+func buildIQSTMethod() *aml {
+	method := amlMethod("IQST", 1, false)
+	method.append(amlIf(amlAnd(amlInt(0x80), amlArg(0), nil)).append(amlReturn(amlInt(0x09))))
+	return method.append(amlReturn(amlInt(0x0b)))
+}
+
+// buildIQCRMethod builds the helper returning the _CRS value of an interrupt link.
+func buildIQCRMethod() *aml {
+	method := amlMethod("IQCR", 1, true)
+	method.append(
+		amlNameDecl("PRR0", amlResourceTemplate().append(amlInterrupt(0))),
+		amlCreateDWordField(amlName("PRR0"), amlInt(5), "PRRI"),
+		amlStore(amlAnd(amlArg(0), amlInt(0x0f), nil), amlName("PRRI")),
+		amlReturn(amlName("PRR0")),
+	)
+	return method
+}
+
+// buildLinkDev builds the PIRQ interrupt link device LNKA-LNKH.
+func buildLinkDev(idx int) *aml {
+	reg := amlName("PRQ%c", 'A'+idx)
+	dev := amlDevice("LNK%c", 'A'+idx)
+	dev.append(
+		amlNameDecl("_HID", amlEISAID("PNP0C0F")),
+		amlNameDecl("_UID", amlInt(uint64(idx))),
+		amlNameDecl("_PRS", amlResourceTemplate().append(amlInterrupt(5, 10, 11))),
+		amlMethod("_STA", 0, false).append(amlReturn(amlCall("IQST", reg))),
+		amlMethod("_DIS", 0, false).append(amlOr(reg, amlInt(0x80), reg)),
+		amlMethod("_CRS", 0, false).append(amlReturn(amlCall("IQCR", reg))),
+		amlMethod("_SRS", 1, false).append(
+			amlCreateDWordField(amlArg(0), amlInt(5), "PRRI"),
+			amlStore(amlName("PRRI"), reg),
+		),
+	)
+	return dev
+}
+
+// buildGSILinkDev builds the link device GSIA-GSIH of the fixed GSI 16-23 used in APIC mode.
+func buildGSILinkDev(idx int) *aml {
+	gsi := uint32(0x10 + idx)
+	dev := amlDevice("GSI%c", 'A'+idx)
+	dev.append(
+		amlNameDecl("_HID", amlEISAID("PNP0C0F")),
+		amlNameDecl("_UID", amlInt(uint64(gsi))),
+		amlNameDecl("_PRS", amlResourceTemplate().append(amlInterrupt(gsi))),
+		amlNameDecl("_CRS", amlResourceTemplate().append(amlInterrupt(gsi))),
+		// _DIS and _SRS are required for the Windows OS, there is nothing to do.
+		amlMethod("_DIS", 0, false),
+		amlMethod("_SRS", 1, false),
+	)
+	return dev
+}
+
+// buildCPUsAml builds the CPU hotplug interface and a processor object for each CPU.
+func buildCPUsAml(table *aml, m *qemuMachine) {
+	cpuCount := len(m.apicIDs)
+	resPath := func(name string) *aml { return amlName("%s.%s", cpuHotplugResPath, name) }
+	ctrlLock := resPath("CPLK")
+	cpuSelector := resPath("CSEL")
+	cpuData := resPath("CDAT")
+	cpuCmd := resPath("CCMD")
+	isEnabled := resPath("CPEN")
+	insEvt := resPath("CINS")
+	rmEvt := resPath("CRMV")
+	ejEvt := resPath("CEJ0")
+	zero, one := amlInt(0), amlInt(1)
+
+	sb := amlScope("_SB")
+
+	ctrl := amlDevice("%s", cpuHotplugResPath)
+	ctrl.append(
+		amlNameDecl("_HID", amlEISAID("PNP0A06")),
+		amlNameDecl("_UID", amlString("CPU Hotplug resources")),
+		amlMutex("CPLK", 0),
+		amlNameDecl("_CRS", amlResourceTemplate().append(amlIO(cpuHotplugIOBase, cpuHotplugIOBase, 1, cpuHotplugRegLen))),
+		amlOperationRegion("PRST", amlSystemIO, amlInt(cpuHotplugIOBase), cpuHotplugRegLen),
+		amlField("PRST", amlByteAcc, amlNoLock, amlWriteAsZeros).append(
+			amlReservedField(4*8),
+			amlNamedField("CPEN", 1),
+			amlNamedField("CINS", 1),
+			amlNamedField("CRMV", 1),
+			amlNamedField("CEJ0", 1),
+			amlNamedField("CEJF", 1),
+			amlReservedField(3),
+			amlNamedField("CCMD", 8),
+		),
+		amlField("PRST", amlDWordAcc, amlNoLock, amlPreserve).append(
+			amlNamedField("CSEL", 32),
+			amlReservedField(32),
+			amlNamedField("CDAT", 32),
+		),
+		amlMethod("_INI", 0, true).append(amlStore(zero, amlName("CSEL"))),
+	)
+	sb.append(ctrl)
+
+	cpus := amlDevice(`\_SB.CPUS`)
+	cpus.append(
+		amlNameDecl("_HID", amlString("ACPI0010")),
+		amlNameDecl("_CID", amlEISAID("PNP0A05")),
+	)
+
+	method := amlMethod("CTFY", 2, false)
+	for i := 0; i < cpuCount; i++ {
+		method.append(amlIf(amlEqual(amlArg(0), amlInt(uint64(i)))).append(
+			amlNotify(amlName("C%03X", i), amlArg(1)),
+		))
+	}
+	cpus.append(method)
+
+	sta := amlLocal(0)
+	method = amlMethod("CSTA", 1, true)
+	method.append(
+		amlAcquire(ctrlLock, 0xffff),
+		amlStore(amlArg(0), cpuSelector),
+		amlStore(zero, sta),
+		amlIf(amlEqual(isEnabled, one)).append(amlStore(amlInt(0xf), sta)),
+		amlRelease(ctrlLock),
+		amlReturn(sta),
+	)
+	cpus.append(method)
+
+	method = amlMethod("CEJ0", 1, true)
+	method.append(
+		amlAcquire(ctrlLock, 0xffff),
+		amlStore(amlArg(0), cpuSelector),
+		amlStore(one, ejEvt),
+		amlRelease(ctrlLock),
+	)
+	cpus.append(method)
+
+	cpus.append(buildCPUScanMethod(cpuCount, ctrlLock, cpuSelector, cpuData, cpuCmd, insEvt, rmEvt))
+
+	method = amlMethod("COST", 4, true)
+	method.append(
+		amlAcquire(ctrlLock, 0xffff),
+		amlStore(amlArg(0), cpuSelector),
+		amlStore(amlInt(1), cpuCmd), // Select the _OST event.
+		amlStore(amlArg(1), cpuData),
+		amlStore(amlInt(2), cpuCmd), // Select the _OST status.
+		amlStore(amlArg(2), cpuData),
+		amlRelease(ctrlLock),
+	)
+	cpus.append(method)
+
+	for i, apicID := range m.apicIDs {
+		uid := amlInt(uint64(i))
+		var dev *aml
+		if apicID < 255 {
+			dev = amlProcessor(uint8(i), 0, 0, "C%03X", i)
+		} else {
+			// Processor objects can't describe x2APIC CPUs.
+			dev = amlDevice("C%03X", i)
+			dev.append(amlNameDecl("_HID", amlString("ACPI0007")), amlNameDecl("_UID", uid))
+		}
+		dev.append(
+			amlMethod("_STA", 0, true).append(amlReturn(amlCall("CSTA", uid))),
+			amlNameDecl("_MAT", amlBuffer(madtCPU(i, apicID))),
+		)
+		if i != 0 {
+			dev.append(amlMethod("_EJ0", 1, false).append(amlCall("CEJ0", uid)))
+		}
+		dev.append(amlMethod("_OST", 3, true).append(amlCall("COST", uid, amlArg(0), amlArg(1), amlArg(2))))
+		if m.cpuNodes != nil {
+			dev.append(amlNameDecl("_PXM", amlInt(uint64(m.cpuNodes[i]))))
+		}
+		cpus.append(dev)
+	}
+	sb.append(cpus)
+	table.append(sb)
+
+	table.append(amlMethod(`\_GPE._E02`, 0, false).append(amlCall(`\_SB.CPUS.CSCN`)))
+}
+
+// buildCPUScanMethod builds the CSCN method, which notifies the OS of inserted and removed CPUs.
+func buildCPUScanMethod(cpuCount int, ctrlLock, cpuSelector, cpuData, cpuCmd, insEvt, rmEvt *aml) *aml {
+	const maxCPUsPerPass = 255
+	hasEvent, uid := amlLocal(0), amlLocal(3)
+	newCPUCount, idx, again := amlLocal(1), amlLocal(2), amlLocal(4)
+	newCPUs := amlName("CNEW")
+	zero, one := amlInt(0), amlInt(1)
+
+	method := amlMethod("CSCN", 0, true)
+	method.append(
+		amlAcquire(ctrlLock, 0xffff),
+		amlNameDecl("CNEW", amlPackage(maxCPUsPerPass)),
+		amlStore(zero, uid),
+		amlStore(one, again),
+	)
+
+	// Collect the CPUs with pending events, in passes of at most maxCPUsPerPass CPUs.
+	scan := amlWhile(amlLAnd(amlEqual(hasEvent, one), amlLLess(uid, amlInt(uint64(cpuCount)))))
+	scan.append(
+		amlStore(zero, hasEvent),
+		amlStore(uid, cpuSelector),
+		amlStore(zero, cpuCmd), // Get the next CPU with an event.
+		amlIf(amlLLess(cpuData, uid)).append(amlBreak()),
+		amlIf(amlEqual(newCPUCount, amlInt(maxCPUsPerPass))).append(amlStore(one, again), amlBreak()),
+		amlStore(cpuData, uid),
+		amlIf(amlEqual(insEvt, one)).append(
+			amlStore(uid, amlIndex(newCPUs, newCPUCount)),
+			amlIncrement(newCPUCount),
+			amlStore(one, hasEvent),
+		),
+		amlElse().append(amlIf(amlEqual(rmEvt, one)).append(
+			amlCall("CTFY", uid, amlInt(3)),
+			amlStore(one, rmEvt),
+			amlStore(one, hasEvent),
+		)),
+		amlIncrement(uid),
+	)
+
+	// Notify the OS of the inserted CPUs.
+	notify := amlWhile(amlLLess(idx, newCPUCount))
+	notify.append(
+		amlStore(amlDerefOf(amlIndex(newCPUs, idx)), uid),
+		amlCall("CTFY", uid, one),
+		amlStore(uid, amlDebug()),
+		amlStore(uid, cpuSelector),
+		amlStore(one, insEvt),
+		amlIncrement(idx),
+	)
+
+	pass := amlWhile(amlEqual(again, one))
+	pass.append(
+		amlStore(zero, again),
+		amlStore(one, hasEvent),
+		amlStore(zero, newCPUCount),
+		scan,
+		amlStore(zero, idx),
+		notify,
+	)
+	method.append(pass, amlRelease(ctrlLock))
+	return method
+}
+
+// buildPCI0Crs builds the resources decoded by the PCI host bridge: the bus numbers, the I/O
+// ports except for the configuration registers and the MMIO windows below and above 4 GiB.
+func buildPCI0Crs(mem *memoryMap, devices *DeviceConfig) *aml {
+	hole64Start, hole64End := devices.pciHole64(mem.pciHole64Size)
+	return amlResourceTemplate().append(
+		amlWordBusNumber(0x00, 0xff),
+		amlIO(0x0cf8, 0x0cf8, 0x01, 0x08),
+		amlWordIO(0x0000, 0x0cf7),
+		amlWordIO(0x0d00, 0xffff),
+		amlDWordMemory(amlCacheable, 0x000a0000, 0x000bffff), // VGA
+		amlDWordMemory(amlNonCacheable, uint32(mem.below4GMemSize), qemuMCFGBase-1),
+		amlDWordMemory(amlNonCacheable, qemuMCFGBase+qemuMCFGSize, qemuIOAPICAddress-1),
+		amlQWordMemory(amlCacheable, hole64Start, hole64End),
+	)
+}
+
+// buildIODevice builds a motherboard resource device reserving an I/O port range.
+func buildIODevice(name, uid string, base uint16, length uint8) *aml {
+	dev := amlDevice("%s", name)
+	dev.append(
+		amlNameDecl("_HID", amlString("PNP0A06")),
+		amlNameDecl("_UID", amlString(uid)),
+		amlNameDecl("_STA", amlInt(0xb)),
+		amlNameDecl("_CRS", amlResourceTemplate().append(amlIO(base, base, 0x01, length))),
+	)
+	return dev
+}
+
+// buildPCIBusDevices builds the device objects of the root PCI bus.
+func buildPCIBusDevices(devices *DeviceConfig) *aml {
+	pci0 := amlScope("PCI0")
+	pci0.append(amlDevice("S00").append(amlNameDecl("_ADR", amlInt(0)))) // Host bridge.
+	buildPCIBus(pci0, devices.PCIDevices, true)
+
+	// ICH9 LPC bridge with the PIRQ routing registers and the ISA devices.
+	lpc := amlDevice("SF8")
+	lpc.append(
+		amlNameDecl("_ADR", amlInt(ich9Slot<<16)),
+		amlOperationRegion("PIRQ", amlPCIConfig, amlInt(0x60), 0x0c),
+		amlScope(`\_SB`).append(amlField("PCI0.SF8.PIRQ", amlByteAcc, amlNoLock, amlPreserve).append(
+			amlNamedField("PRQA", 8),
+			amlNamedField("PRQB", 8),
+			amlNamedField("PRQC", 8),
+			amlNamedField("PRQD", 8),
+			amlReservedField(0x20),
+			amlNamedField("PRQE", 8),
+			amlNamedField("PRQF", 8),
+			amlNamedField("PRQG", 8),
+			amlNamedField("PRQH", 8),
+		)),
+		buildISADevice("KBD", amlEISAID("PNP0303"), false,
+			amlIO(0x0060, 0x0060, 0x01, 0x01), amlIO(0x0064, 0x0064, 0x01, 0x01), amlIRQNoFlags(1)),
+		buildISADevice("MOU", amlEISAID("PNP0F13"), false, amlIRQNoFlags(12)),
+		buildISADevice("COM1", amlEISAID("PNP0501"), true,
+			amlIO(0x03f8, 0x03f8, 0x00, 0x08), amlIRQNoFlags(4)),
+	)
+	rtc := amlDevice("RTC")
+	rtc.append(
+		amlNameDecl("_HID", amlEISAID("PNP0B00")),
+		amlNameDecl("_CRS", amlResourceTemplate().append(
+			amlIO(0x0070, 0x0070, 0x01, 0x08), amlIRQNoFlags(8))),
+	)
+	lpc.append(rtc)
+	pci0.append(lpc)
+
+	// SATA and SMBus controllers.
+	pci0.append(amlDevice("SFA").append(amlNameDecl("_ADR", amlInt(ich9Slot<<16|2))))
+	pci0.append(amlDevice("SFB").append(amlNameDecl("_ADR", amlInt(ich9Slot<<16|3))))
+	return pci0
+}
+
+// buildISADevice builds an ISA device object with the given resources.
+func buildISADevice(name string, hid *aml, withUID bool, resources ...*aml) *aml {
+	dev := amlDevice("%s", name)
+	dev.append(amlNameDecl("_HID", hid))
+	if withUID {
+		dev.append(amlNameDecl("_UID", amlInt(1)))
+	}
+	dev.append(
+		amlNameDecl("_STA", amlInt(0xf)),
+		amlNameDecl("_CRS", amlResourceTemplate().append(resources...)),
+	)
+	return dev
+}
@@ -0,0 +1,167 @@
+package tdxmeasure
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestGenerateTablesQemuGolden checks the generated tables against the digests of the tables QEMU
+// generated for each CPU count and memory size, in testdata/acpi-tables.txt. Any change to them
+// changes RTMR0.
+func TestGenerateTablesQemuGolden(t *testing.T) {
+	f, err := os.Open("testdata/acpi-tables.txt")
+	require.NoError(t, err)
+	defer f.Close()
+
+	cases := 0
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var (
+			cpu, memory          uint64
+			tables, rsdp, loader string
+		)
+		_, err := fmt.Sscanf(line, "%d %d %s %s %s", &cpu, &memory, &tables, &rsdp, &loader)
+		require.NoError(t, err, line)
+
+		gotTables, gotRSDP, gotLoader, err := GenerateTablesQemu(Options{MemorySize: memory << 20, CPUCount: uint32(cpu)})
+		require.NoError(t, err)
+		require.Equal(t, tables, sha384Hex(gotTables), "tables, %d CPUs, %d MiB", cpu, memory)
+		require.Equal(t, rsdp, sha384Hex(gotRSDP), "RSDP, %d CPUs, %d MiB", cpu, memory)
+		require.Equal(t, loader, sha384Hex(gotLoader), "loader, %d CPUs, %d MiB", cpu, memory)
+		cases++
+	}
+	require.NoError(t, scanner.Err())
+	require.Equal(t, 128*8, cases)
+}
+
+// testACPITables splits the table blob into its tables by signature. The blob starts with the
+// FACS, followed by the tables with a standard header and the padding.
+func testACPITables(t *testing.T, blob []byte) map[string][]byte {
+	tables := make(map[string][]byte)
+	for offset := 0; offset+8 <= len(blob) && blob[offset] != 0; {
+		length := int(binary.LittleEndian.Uint32(blob[offset+4:]))
+		require.Greater(t, length, 8)
+		require.LessOrEqual(t, offset+length, len(blob))
+		tables[string(blob[offset:offset+4])] = blob[offset : offset+length]
+		offset += length
+	}
+	return tables
+}
+
+// testGoldenTables checks the digests of the tables generated for each of the named
+// configurations against a testdata file. Fields: name, tables, RSDP, loader and DSDT.
+func testGoldenTables(t *testing.T, path string, configs map[string]Options) {
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	seen := make(map[string]bool)
+	for _, line := range strings.Split(string(data), "\n") {
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var name, tables, rsdp, loader, dsdt string
+		_, err := fmt.Sscanf(line, "%s %s %s %s %s", &name, &tables, &rsdp, &loader, &dsdt)
+		require.NoError(t, err, line)
+		opts, ok := configs[name]
+		require.True(t, ok, "unknown configuration %s", name)
+		seen[name] = true
+
+		gotTables, gotRSDP, gotLoader, err := GenerateTablesQemu(opts)
+		require.NoError(t, err, name)
+		require.Equal(t, dsdt, sha384Hex(testACPITables(t, gotTables)["DSDT"]), "DSDT, %s", name)
+		require.Equal(t, tables, sha384Hex(gotTables), "tables, %s", name)
+		require.Equal(t, rsdp, sha384Hex(gotRSDP), "RSDP, %s", name)
+		require.Equal(t, loader, sha384Hex(gotLoader), "loader, %s", name)
+	}
+	require.Len(t, seen, len(configs))
+}
+
+// TestGenerateTablesQemuCPUCount checks the tables of guests with more CPUs than the xAPIC IDs
+// can address against testdata/acpi-cpus.txt.
+func TestGenerateTablesQemuCPUCount(t *testing.T) {
+	configs := make(map[string]Options)
+	for _, cpu := range []uint32{255, 256, 300, MaxCPUCount} {
+		configs[fmt.Sprintf("cpu-%d", cpu)] = Options{MemorySize: 4 << 30, CPUCount: cpu}
+	}
+	configs["cpu-288-sockets-2"] = Options{MemorySize: 4 << 30, CPUCount: 288, Sockets: 2}
+	testGoldenTables(t, "testdata/acpi-cpus.txt", configs)
+
+	for _, cpu := range []uint32{0, MaxCPUCount + 1} {
+		_, _, _, err := GenerateTablesQemu(Options{MemorySize: 4 << 30, CPUCount: cpu})
+		require.ErrorIs(t, err, ErrUnsupportedConfig, "%d CPUs", cpu)
+	}
+	_, _, _, err := GenerateTablesQemu(Options{MemorySize: 4 << 30, CPUCount: 255, Sockets: 2})
+	require.ErrorIs(t, err, ErrUnsupportedConfig)
+}
+
+// TestGenerateTablesQemuX2APIC checks that the CPUs with an APIC ID of 255 and above are
+// described with x2APIC structures in the MADT and as processor devices in the DSDT.
+func TestGenerateTablesQemuX2APIC(t *testing.T) {
+	for _, cpu := range []int{254, 255, 256, 300} {
+		blob, _, _, err := GenerateTablesQemu(Options{MemorySize: 4 << 30, CPUCount: uint32(cpu)})
+		require.NoError(t, err)
+		tables := testACPITables(t, blob)
+
+		// MADT entries after the local APIC address and flags.
+		madt := tables["APIC"]
+		var lapics, x2apics []uint32
+		var nmi byte
+		for entries := madt[44:]; len(entries) > 0; entries = entries[entries[1]:] {
+			switch entries[0] {
+			case 0x00: // Processor Local APIC
+				require.Equal(t, entries[2], entries[3], "UID and APIC ID")
+				lapics = append(lapics, uint32(entries[3]))
+			case 0x09: // Processor Local x2APIC
+				id := binary.LittleEndian.Uint32(entries[4:])
+				require.Equal(t, id, binary.LittleEndian.Uint32(entries[12:]), "UID and x2APIC ID")
+				require.Equal(t, uint32(1), binary.LittleEndian.Uint32(entries[8:]), "flags")
+				x2apics = append(x2apics, id)
+			case 0x04, 0x0a: // Local APIC NMI, Local x2APIC NMI
+				nmi = entries[0]
+			}
+		}
+		require.Len(t, lapics, min(cpu, 255), "%d CPUs", cpu)
+		require.Len(t, x2apics, max(cpu-255, 0), "%d CPUs", cpu)
+		for i, id := range x2apics {
+			require.Equal(t, uint32(255+i), id)
+		}
+		if cpu > 255 {
+			require.Equal(t, byte(0x0a), nmi, "%d CPUs", cpu)
+		} else {
+			require.Equal(t, byte(0x04), nmi, "%d CPUs", cpu)
+		}
+
+		// The CPU objects: Processor(C0FE, 0xFE, 0, 0) for the last xAPIC CPU, and
+		// Device(C0FF) { Name(_HID, "ACPI0007") Name(_UID, 0xFF) } for the first x2APIC one,
+		// whose _MAT is the x2APIC structure.
+		dsdt := tables["DSDT"]
+		for i := range cpu {
+			name := fmt.Sprintf("C%03X", i)
+			processor := append([]byte(name), byte(i), 0, 0, 0, 0, 0)
+			device := append([]byte(name), "\x08_HID\x0dACPI0007\x00\x08_UID"...)
+			if i < 255 {
+				require.True(t, bytes.Contains(dsdt, processor), "%s, %d CPUs", name, cpu)
+				require.False(t, bytes.Contains(dsdt, device), "%s, %d CPUs", name, cpu)
+			} else {
+				require.False(t, bytes.Contains(dsdt, processor), "%s, %d CPUs", name, cpu)
+				require.True(t, bytes.Contains(dsdt, device), "%s, %d CPUs", name, cpu)
+			}
+		}
+		require.Equal(t, max(cpu-255, 0), bytes.Count(dsdt, []byte("ACPI0007")), "%d CPUs", cpu)
+		if cpu > 255 {
+			mat := []byte("\x08_MAT\x11\x13\x0a\x10\x09\x10\x00\x00\xff\x00\x00\x00\x01\x00\x00\x00\xff\x00\x00\x00")
+			require.True(t, bytes.Contains(dsdt, mat), "%d CPUs", cpu)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package tdxmeasure
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"slices"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/sha3"
+)
+
+// aggregateHashes are the hash functions aggregates can be defined with.
+var aggregateHashes = map[string]func() hash.Hash{
+	"sha256":    sha256.New,
+	"sha384":    sha512.New384,
+	"sha512":    sha512.New,
+	"sha3-256":  sha3.New256,
+	"sha3-384":  sha3.New384,
+	"keccak256": sha3.NewLegacyKeccak256,
+}
+
+// aggregateValues are the measurement values aggregates can refer to, by name.
+var aggregateValues = []string{"mrtd", "rtmr0", "rtmr1", "rtmr2", "rtmr3", "mr_key_provider"}
+
+// Aggregate is a named digest over measurement values and constants, such as mr_enclave and
+// mr_image. It's defined by an expression of the form
+//
+//	name = hash(input, ...)
+//
+// where hash is one of sha256, sha384, sha512, sha3-256, sha3-384 or keccak256, and each input is
+// a measurement value (mrtd, rtmr0 to rtmr3 or mr_key_provider), a hex constant (0x0102) or a
+// quoted string constant ("v1"), hashed in order.
+type Aggregate struct {
+	// Name is the name of the aggregate, lowercase letters, digits and underscores.
+	Name string
+
+	hashName string
+	inputs   []aggregateInput
+}
+
+// aggregateInput is a measurement value, by name, or a constant.
+type aggregateInput struct {
+	value    string
+	constant []byte
+	literal  string // The constant as written.
+}
+
+// The built-in aggregates defined by dstack.
+var (
+	MrEnclaveAggregate = mustParseAggregate("mr_enclave = sha256(mrtd, rtmr0, rtmr1, rtmr2, mr_key_provider)")
+	MrImageAggregate   = mustParseAggregate("mr_image = sha256(mrtd, rtmr1, rtmr2)")
+)
+
+func mustParseAggregate(expr string) *Aggregate {
+	a, err := ParseAggregate(expr)
+	if err != nil {
+		panic(err)
+	}
+	return a
+}
+
+// ParseAggregate parses the expression defining an aggregate.
+func ParseAggregate(expr string) (*Aggregate, error) {
+	p := &aggregateParser{expr: expr}
+	a := &Aggregate{Name: p.identifier()}
+	if strings.Contains(a.Name, "-") {
+		p.fail("invalid name %q", a.Name)
+	}
+	p.expect('=')
+	a.hashName = p.identifier()
+	if _, ok := aggregateHashes[a.hashName]; !ok && p.err == nil {
+		p.fail("unknown hash %q", a.hashName)
+	}
+	p.expect('(')
+	for p.err == nil {
+		a.inputs = append(a.inputs, p.input())
+		if !p.accept(',') {
+			break
+		}
+	}
+	p.expect(')')
+	if p.skipSpace(); p.err == nil && p.pos != len(expr) {
+		p.fail("unexpected %q", expr[p.pos:])
+	}
+	if p.err != nil {
+		return nil, fmt.Errorf("%w %q: %w", ErrInvalidAggregate, expr, p.err)
+	}
+	return a, nil
+}
+
+// String returns the expression defining the aggregate.
+func (a *Aggregate) String() string {
+	inputs := make([]string, len(a.inputs))
+	for i, in := range a.inputs {
+		inputs[i] = in.value + in.literal
+	}
+	return fmt.Sprintf("%s = %s(%s)", a.Name, a.hashName, strings.Join(inputs, ", "))
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (a *Aggregate) MarshalText() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (a *Aggregate) UnmarshalText(text []byte) error {
+	parsed, err := ParseAggregate(string(text))
+	if err != nil {
+		return err
+	}
+	*a = *parsed
+	return nil
+}
+
+// Compute calculates the aggregate of the measurements with the given key provider measurement,
+// which is only needed if the aggregate refers to mr_key_provider.
+func (a *Aggregate) Compute(m *Measurements, mrKeyProvider []byte) ([]byte, error) {
+	h := aggregateHashes[a.hashName]()
+	for _, in := range a.inputs {
+		switch in.value {
+		case "":
+			h.Write(in.constant)
+		case "mrtd":
+			h.Write(m.MRTD[:])
+		case "rtmr0":
+			h.Write(m.RTMR0[:])
+		case "rtmr1":
+			h.Write(m.RTMR1[:])
+		case "rtmr2":
+			h.Write(m.RTMR2[:])
+		case "rtmr3":
+			h.Write(m.RTMR3[:])
+		case "mr_key_provider":
+			if len(mrKeyProvider) != KeyProviderSize {
+				return nil, fmt.Errorf("%w: measurement is %d bytes long, expected %d",
+					ErrInvalidKeyProvider, len(mrKeyProvider), KeyProviderSize)
+			}
+			h.Write(mrKeyProvider)
+		}
+	}
+	return h.Sum(nil), nil
+}
+
+// aggregateParser scans an aggregate expression, remembering the first error.
+type aggregateParser struct {
+	expr string
+	pos  int
+	err  error
+}
+
+func (p *aggregateParser) fail(format string, args ...any) {
+	if p.err == nil {
+		p.err = fmt.Errorf(format, args...)
+	}
+}
+
+func (p *aggregateParser) skipSpace() {
+	for p.pos < len(p.expr) && (p.expr[p.pos] == ' ' || p.expr[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+// accept consumes the given character if it's next.
+func (p *aggregateParser) accept(c byte) bool {
+	p.skipSpace()
+	if p.err != nil || p.pos == len(p.expr) || p.expr[p.pos] != c {
+		return false
+	}
+	p.pos++
+	return true
+}
+
+func (p *aggregateParser) expect(c byte) {
+	if !p.accept(c) {
+		p.fail("expected %q at offset %d", c, p.pos)
+	}
+}
+
+// identifier consumes a name made of lowercase letters, digits, underscores and dashes.
+func (p *aggregateParser) identifier() string {
+	p.skipSpace()
+	start := p.pos
+	for p.pos < len(p.expr) {
+		c := p.expr[p.pos]
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '_' || c == '-' && p.pos > start) {
+			break
+		}
+		p.pos++
+	}
+	if p.pos == start {
+		p.fail("expected a name at offset %d", p.pos)
+	}
+	return p.expr[start:p.pos]
+}
+
+// input consumes an input of the hash: a measurement value, a hex constant or a string constant.
+func (p *aggregateParser) input() aggregateInput {
+	p.skipSpace()
+	rest := p.expr[p.pos:]
+	switch {
+	case strings.HasPrefix(rest, "0x"):
+		end := 2
+		for end < len(rest) && strings.IndexByte("0123456789abcdefABCDEF", rest[end]) >= 0 {
+			end++
+		}
+		constant, err := hex.DecodeString(rest[2:end])
+		if err != nil || end == 2 {
+			p.fail("invalid hex constant %q", rest[:end])
+		}
+		p.pos += end
+		return aggregateInput{constant: constant, literal: strings.ToLower(rest[:end])}
+	case strings.HasPrefix(rest, `"`):
+		quoted, err := strconv.QuotedPrefix(rest)
+		if err != nil {
+			p.fail("invalid string constant at offset %d", p.pos)
+			return aggregateInput{}
+		}
+		s, _ := strconv.Unquote(quoted)
+		p.pos += len(quoted)
+		return aggregateInput{constant: []byte(s), literal: strconv.Quote(s)}
+	}
+	name := p.identifier()
+	if p.err == nil && !slices.Contains(aggregateValues, name) {
+		p.fail("unknown value %q", name)
+	}
+	return aggregateInput{value: name}
+}
@@ -0,0 +1,107 @@
+package tdxmeasure
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testMeasurements returns measurements with each register filled with its own byte value.
+func testMeasurements() *Measurements {
+	m := &Measurements{}
+	for i, r := range []*Register{&m.MRTD, &m.RTMR0, &m.RTMR1, &m.RTMR2, &m.RTMR3} {
+		copy(r[:], bytes.Repeat([]byte{byte(i + 1)}, len(r)))
+	}
+	return m
+}
+
+func TestParseAggregate(t *testing.T) {
+	tests := []struct {
+		expr    string
+		want    string
+		wantErr bool
+	}{
+		{expr: "mr_boot = sha384(mrtd, rtmr0)", want: "mr_boot = sha384(mrtd, rtmr0)"},
+		{expr: "  x1=sha3-256( mrtd ,rtmr3 )  ", want: "x1 = sha3-256(mrtd, rtmr3)"},
+		{expr: `p = keccak256("dstack-policy-v1", 0xABcd, mr_key_provider)`, want: `p = keccak256("dstack-policy-v1", 0xabcd, mr_key_provider)`},
+		{expr: `s = sha512("a\"bé")`, want: `s = sha512("a\"bé")`},
+		{expr: "\tt = sha256(rtmr1,\trtmr2)", want: "t = sha256(rtmr1, rtmr2)"},
+		{expr: "", wantErr: true},
+		{expr: "mr_boot", wantErr: true},
+		{expr: "mr_boot = ", wantErr: true},
+		{expr: "Mr = sha256(mrtd)", wantErr: true},
+		{expr: "mr-boot = sha256(mrtd)", wantErr: true},
+		{expr: "_x = md5(mrtd)", wantErr: true},
+		{expr: "x = sha256()", wantErr: true},
+		{expr: "x = sha256(mrtd,)", wantErr: true},
+		{expr: "x = sha256(mrtd", wantErr: true},
+		{expr: "x = sha256(mrtd) extra", wantErr: true},
+		{expr: "x = sha256(rtmr4)", wantErr: true},
+		{expr: "x = sha256(0x)", wantErr: true},
+		{expr: "x = sha256(0x123)", wantErr: true},
+		{expr: `x = sha256("unterminated)`, wantErr: true},
+		{expr: "x = sha256(mrtd rtmr0)", wantErr: true},
+	}
+	for _, tt := range tests {
+		a, err := ParseAggregate(tt.expr)
+		if tt.wantErr {
+			require.ErrorIs(t, err, ErrInvalidAggregate, "%q", tt.expr)
+			continue
+		}
+		require.NoError(t, err, "%q", tt.expr)
+		require.Equal(t, tt.want, a.String())
+
+		// The expression parses back to the same aggregate.
+		again, err := ParseAggregate(a.String())
+		require.NoError(t, err)
+		require.Equal(t, a, again)
+	}
+}
+
+func TestAggregateCompute(t *testing.T) {
+	m := testMeasurements()
+	mrKeyProvider := make([]byte, KeyProviderSize)
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{"mr_boot = sha384(mrtd, rtmr0, rtmr1, rtmr2, rtmr3)", "165f57243134f4775d1c806413e34e2017aa7b521dba8608599e4ed0cc182ad9d0435a91ec5409a62748eb589614ec65"},
+		{`c = sha3-256("v1", 0x01ab, mrtd, mr_key_provider)`, "e23e7414c274a13057bf175094f6bef5112614216df3fb61466d486a101dd0b8"},
+		{`k = keccak256("")`, "c5d2460186f7233c927e7db2dcc703c0e500b653ca82273b7bfad8045d85a470"},
+	}
+	for _, tt := range tests {
+		a, err := ParseAggregate(tt.expr)
+		require.NoError(t, err)
+		value, err := a.Compute(m, mrKeyProvider)
+		require.NoError(t, err)
+		require.Equal(t, tt.want, hex.EncodeToString(value), tt.expr)
+	}
+
+	// The key provider measurement is only needed when it's hashed.
+	_, err := MrImageAggregate.Compute(m, nil)
+	require.NoError(t, err)
+	_, err = MrEnclaveAggregate.Compute(m, nil)
+	require.ErrorIs(t, err, ErrInvalidKeyProvider)
+}
+
+func TestBuiltinAggregates(t *testing.T) {
+	m := testMeasurements()
+	mrEnclave, err := m.CalculateMrEnclave("0x" + hex.EncodeToString(make([]byte, KeyProviderSize)))
+	require.NoError(t, err)
+	require.Equal(t, "277dd3f55ca5f00d78b6df04104ca9e04ad17b871128e38808abc9f6b86faf31", mrEnclave)
+	require.Equal(t, "f9aa5fad4ffa53815ea7d57c706950417a0c88b347d03150416de171e4f8da62", m.CalculateMrImage())
+}
+
+func TestAggregateJSON(t *testing.T) {
+	var aggregates []*Aggregate
+	require.NoError(t, json.Unmarshal([]byte(`["mr_boot = sha384(mrtd, rtmr0)", "v = sha256(\"v1\", rtmr3)"]`), &aggregates))
+	require.Len(t, aggregates, 2)
+	data, err := json.Marshal(aggregates)
+	require.NoError(t, err)
+	require.Equal(t, `["mr_boot = sha384(mrtd, rtmr0)","v = sha256(\"v1\", rtmr3)"]`, string(data))
+
+	require.ErrorIs(t, json.Unmarshal([]byte(`["mr_boot = sha384(mrtd"]`), &aggregates), ErrInvalidAggregate)
+}
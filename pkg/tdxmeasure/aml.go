@@ -0,0 +1,480 @@
+package tdxmeasure
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// The AML builder below mirrors QEMU's hw/acpi/aml-build.c. It has to make the same encoding
+// choices (integer widths, package length sizes, null targets) as QEMU, as the measured ACPI
+// tables are compared byte by byte.
+
+// amlBlock describes how a term is serialized when it is appended to its parent.
+type amlBlock int
+
+const (
+	amlBlockNoOpcode    amlBlock = iota // Raw bytes.
+	amlBlockOpcode                      // Opcode followed by the arguments.
+	amlBlockPackage                     // Opcode, package length and the contents.
+	amlBlockExtPackage                  // Extended opcode, package length and the contents.
+	amlBlockBuffer                      // Buffer with the given opcode.
+	amlBlockResTemplate                 // Buffer of resource descriptors terminated by an end tag.
+)
+
+// aml is an AML term under construction.
+type aml struct {
+	op    byte
+	block amlBlock
+	buf   []byte
+}
+
+func amlBundle(op byte, block amlBlock) *aml {
+	return &aml{op: op, block: block}
+}
+
+func amlOp(op byte) *aml {
+	return amlBundle(op, amlBlockOpcode)
+}
+
+// append serializes the children and appends them to the term.
+func (a *aml) append(children ...*aml) *aml {
+	for _, c := range children {
+		a.buf = append(a.buf, c.encode()...)
+	}
+	return a
+}
+
+// encode returns the serialized term.
+func (a *aml) encode() []byte {
+	switch a.block {
+	case amlBlockOpcode:
+		return append([]byte{a.op}, a.buf...)
+	case amlBlockPackage:
+		return append(append([]byte{a.op}, amlPkgLength(len(a.buf), true)...), a.buf...)
+	case amlBlockExtPackage:
+		return append(append([]byte{0x5b, a.op}, amlPkgLength(len(a.buf), true)...), a.buf...)
+	case amlBlockResTemplate, amlBlockBuffer:
+		data := a.buf
+		if a.block == amlBlockResTemplate {
+			// End tag with a zero checksum.
+			data = append(append([]byte{}, data...), 0x79, 0x00)
+		}
+		data = append(amlInt(uint64(len(data))).buf, data...)
+		return append(append([]byte{a.op}, amlPkgLength(len(data), true)...), data...)
+	default:
+		return a.buf
+	}
+}
+
+// amlPkgLength encodes a PkgLength. QEMU sizes the encoding by the length of the data alone, also
+// when the encoding itself is included in the length.
+func amlPkgLength(length int, inclSelf bool) []byte {
+	var n int
+	switch {
+	case length+1 < 1<<6:
+		n = 1
+	case length+2 < 1<<12:
+		n = 2
+	case length+3 < 1<<20:
+		n = 3
+	default:
+		n = 4
+	}
+	if inclSelf {
+		length += n
+	}
+	if n == 1 {
+		return []byte{byte(length)}
+	}
+	out := []byte{byte((n-1)<<6 | length&0xf)}
+	for length >>= 4; len(out) < n; length >>= 8 {
+		out = append(out, byte(length))
+	}
+	return out
+}
+
+func amlAppendNameSeg(buf []byte, seg string) []byte {
+	return append(buf, (seg + "____")[:4]...)
+}
+
+func amlAppendNameString(buf []byte, name string) []byte {
+	for len(name) > 0 && (name[0] == '\\' || name[0] == '^') {
+		buf = append(buf, name[0])
+		name = name[1:]
+	}
+	segs := strings.Split(name, ".")
+	switch len(segs) {
+	case 1:
+		if name == "" {
+			return append(buf, 0x00) // NullName
+		}
+	case 2:
+		buf = append(buf, 0x2e) // DualNamePrefix
+	default:
+		buf = append(buf, 0x2f, byte(len(segs))) // MultiNamePrefix
+	}
+	for _, seg := range segs {
+		buf = amlAppendNameSeg(buf, seg)
+	}
+	return buf
+}
+
+func amlAppendIntNoPrefix(buf []byte, v uint64, size int) []byte {
+	for i := 0; i < size; i++ {
+		buf = append(buf, byte(v>>(8*i)))
+	}
+	return buf
+}
+
+// amlInt encodes an integer constant using the smallest encoding.
+func amlInt(v uint64) *aml {
+	a := &aml{}
+	switch {
+	case v == 0:
+		a.buf = []byte{0x00} // ZeroOp
+	case v == 1:
+		a.buf = []byte{0x01} // OneOp
+	case v <= 0xff:
+		a.buf = amlAppendIntNoPrefix([]byte{0x0a}, v, 1)
+	case v <= 0xffff:
+		a.buf = amlAppendIntNoPrefix([]byte{0x0b}, v, 2)
+	case v <= 0xffffffff:
+		a.buf = amlAppendIntNoPrefix([]byte{0x0c}, v, 4)
+	default:
+		a.buf = amlAppendIntNoPrefix([]byte{0x0e}, v, 8)
+	}
+	return a
+}
+
+func amlName(format string, args ...any) *aml {
+	return &aml{buf: amlAppendNameString(nil, fmt.Sprintf(format, args...))}
+}
+
+func amlNameDecl(name string, val *aml) *aml {
+	a := amlOp(0x08)
+	a.buf = amlAppendNameString(a.buf, name)
+	return a.append(val)
+}
+
+func amlString(s string) *aml {
+	return &aml{buf: append(append([]byte{0x0d}, s...), 0x00)}
+}
+
+// amlEISAID encodes a compressed EISA ID such as "PNP0A03".
+func amlEISAID(id string) *aml {
+	hex := func(c byte) uint32 {
+		if c >= 'A' {
+			return uint32(c-'A') + 10
+		}
+		return uint32(c - '0')
+	}
+	v := uint32(id[0]-0x40)<<26 | uint32(id[1]-0x40)<<21 | uint32(id[2]-0x40)<<16 |
+		hex(id[3])<<12 | hex(id[4])<<8 | hex(id[5])<<4 | hex(id[6])
+	a := &aml{buf: []byte{0x0c}}
+	a.buf = binary.BigEndian.AppendUint32(a.buf, v)
+	return a
+}
+
+// amlToUUID encodes a UUID as a 16 byte buffer.
+func amlToUUID(uuid string) *aml {
+	return amlBuffer(encodeGUID(uuid))
+}
+
+func amlBuffer(data []byte) *aml {
+	a := amlBundle(0x11, amlBlockBuffer)
+	a.buf = append(a.buf, data...)
+	return a
+}
+
+func amlPackage(numElements uint8) *aml {
+	a := amlBundle(0x12, amlBlockPackage)
+	a.buf = append(a.buf, numElements)
+	return a
+}
+
+func amlLocal(n int) *aml {
+	return &aml{buf: []byte{0x60 + byte(n)}}
+}
+
+func amlArg(n int) *aml {
+	return &aml{buf: []byte{0x68 + byte(n)}}
+}
+
+func amlDebug() *aml {
+	return &aml{buf: []byte{0x5b, 0x31}}
+}
+
+func amlScope(format string, args ...any) *aml {
+	a := amlBundle(0x10, amlBlockPackage)
+	a.buf = amlAppendNameString(a.buf, fmt.Sprintf(format, args...))
+	return a
+}
+
+func amlDevice(format string, args ...any) *aml {
+	a := amlBundle(0x82, amlBlockExtPackage)
+	a.buf = amlAppendNameString(a.buf, fmt.Sprintf(format, args...))
+	return a
+}
+
+func amlProcessor(procID uint8, pblkAddr uint32, pblkLen uint8, format string, args ...any) *aml {
+	a := amlBundle(0x83, amlBlockExtPackage)
+	a.buf = amlAppendNameString(a.buf, fmt.Sprintf(format, args...))
+	a.buf = append(a.buf, procID)
+	a.buf = binary.LittleEndian.AppendUint32(a.buf, pblkAddr)
+	a.buf = append(a.buf, pblkLen)
+	return a
+}
+
+func amlMethod(name string, argCount int, serialized bool) *aml {
+	a := amlBundle(0x14, amlBlockPackage)
+	a.buf = amlAppendNameString(a.buf, name)
+	flags := byte(argCount)
+	if serialized {
+		flags |= 1 << 3
+	}
+	a.buf = append(a.buf, flags)
+	return a
+}
+
+func amlIf(predicate *aml) *aml {
+	return amlBundle(0xa0, amlBlockPackage).append(predicate)
+}
+
+func amlElse() *aml {
+	return amlBundle(0xa1, amlBlockPackage)
+}
+
+func amlWhile(predicate *aml) *aml {
+	return amlBundle(0xa2, amlBlockPackage).append(predicate)
+}
+
+func amlBreak() *aml {
+	return amlOp(0xa5)
+}
+
+func amlReturn(val *aml) *aml {
+	return amlOp(0xa4).append(val)
+}
+
+func amlStore(val, target *aml) *aml {
+	return amlOp(0x70).append(val, target)
+}
+
+// amlOpcode2ArgDst builds a binary operator with an optional target.
+func amlOpcode2ArgDst(op byte, arg1, arg2, dst *aml) *aml {
+	a := amlOp(op).append(arg1, arg2)
+	if dst == nil {
+		a.buf = append(a.buf, 0x00) // NullName
+		return a
+	}
+	return a.append(dst)
+}
+
+func amlAnd(arg1, arg2, dst *aml) *aml {
+	return amlOpcode2ArgDst(0x7b, arg1, arg2, dst)
+}
+
+func amlOr(arg1, arg2, dst *aml) *aml {
+	return amlOpcode2ArgDst(0x7d, arg1, arg2, dst)
+}
+
+func amlShiftLeft(arg1, count *aml) *aml {
+	return amlOpcode2ArgDst(0x79, arg1, count, nil)
+}
+
+func amlSubtract(arg1, arg2, dst *aml) *aml {
+	return amlOpcode2ArgDst(0x74, arg1, arg2, dst)
+}
+
+func amlIndex(arg1, idx *aml) *aml {
+	return amlOpcode2ArgDst(0x88, arg1, idx, nil)
+}
+
+func amlToHexString(src, dst *aml) *aml {
+	a := amlOp(0x98).append(src)
+	if dst == nil {
+		a.buf = append(a.buf, 0x00)
+		return a
+	}
+	return a.append(dst)
+}
+
+func amlToBuffer(src, dst *aml) *aml {
+	a := amlOp(0x96).append(src)
+	if dst == nil {
+		a.buf = append(a.buf, 0x00)
+		return a
+	}
+	return a.append(dst)
+}
+
+func amlIncrement(arg *aml) *aml {
+	return amlOp(0x75).append(arg)
+}
+
+func amlSizeof(arg *aml) *aml {
+	return amlOp(0x87).append(arg)
+}
+
+func amlDerefOf(arg *aml) *aml {
+	return amlOp(0x83).append(arg)
+}
+
+func amlLAnd(arg1, arg2 *aml) *aml {
+	return amlOp(0x90).append(arg1, arg2)
+}
+
+func amlLNot(arg *aml) *aml {
+	return amlOp(0x92).append(arg)
+}
+
+func amlEqual(arg1, arg2 *aml) *aml {
+	return amlOp(0x93).append(arg1, arg2)
+}
+
+func amlLLess(arg1, arg2 *aml) *aml {
+	return amlOp(0x95).append(arg1, arg2)
+}
+
+func amlNotify(object, value *aml) *aml {
+	return amlOp(0x86).append(object, value)
+}
+
+// amlCall invokes the named method with the given arguments.
+func amlCall(method string, args ...*aml) *aml {
+	return amlName("%s", method).append(args...)
+}
+
+func amlCreateDWordField(srcbuf, index *aml, name string) *aml {
+	a := amlOp(0x8a).append(srcbuf, index)
+	a.buf = amlAppendNameString(a.buf, name)
+	return a
+}
+
+func amlMutex(name string, syncLevel uint8) *aml {
+	a := &aml{buf: []byte{0x5b, 0x01}}
+	a.buf = amlAppendNameString(a.buf, name)
+	a.buf = append(a.buf, syncLevel)
+	return a
+}
+
+func amlAcquire(mutex *aml, timeout uint16) *aml {
+	a := &aml{buf: []byte{0x5b, 0x23}}
+	a.append(mutex)
+	a.buf = binary.LittleEndian.AppendUint16(a.buf, timeout)
+	return a
+}
+
+func amlRelease(mutex *aml) *aml {
+	return (&aml{buf: []byte{0x5b, 0x27}}).append(mutex)
+}
+
+// Operation region address spaces and field flags.
+const (
+	amlSystemIO  = 0x01
+	amlPCIConfig = 0x02
+
+	amlByteAcc      = 0x01
+	amlDWordAcc     = 0x03
+	amlNoLock       = 0x00
+	amlPreserve     = 0x00
+	amlWriteAsZeros = 0x02
+)
+
+func amlOperationRegion(name string, space uint8, offset *aml, length uint32) *aml {
+	a := &aml{buf: []byte{0x5b, 0x80}}
+	a.buf = amlAppendNameString(a.buf, name)
+	a.buf = append(a.buf, space)
+	return a.append(offset, amlInt(uint64(length)))
+}
+
+func amlField(name string, accessType, lockRule, updateRule uint8) *aml {
+	a := amlBundle(0x81, amlBlockExtPackage)
+	a.buf = amlAppendNameString(a.buf, name)
+	a.buf = append(a.buf, updateRule<<5|lockRule<<4|accessType)
+	return a
+}
+
+func amlNamedField(name string, bits int) *aml {
+	return &aml{buf: append(amlAppendNameSeg(nil, name), amlPkgLength(bits, false)...)}
+}
+
+func amlReservedField(bits int) *aml {
+	return &aml{buf: append([]byte{0x00}, amlPkgLength(bits, false)...)}
+}
+
+// Resource descriptors.
+
+func amlResourceTemplate() *aml {
+	return amlBundle(0x11, amlBlockResTemplate)
+}
+
+// amlIO is an I/O port descriptor with 16-bit decoding.
+func amlIO(minBase, maxBase uint16, align, length uint8) *aml {
+	a := &aml{buf: []byte{0x47, 0x01}}
+	a.buf = binary.LittleEndian.AppendUint16(a.buf, minBase)
+	a.buf = binary.LittleEndian.AppendUint16(a.buf, maxBase)
+	a.buf = append(a.buf, align, length)
+	return a
+}
+
+func amlIRQNoFlags(irq uint8) *aml {
+	a := &aml{buf: []byte{0x22}}
+	a.buf = binary.LittleEndian.AppendUint16(a.buf, 1<<irq)
+	return a
+}
+
+// amlInterrupt is an extended interrupt descriptor of a shared, active-high interrupt consumed
+// by the device, with the same flags QEMU uses for the PCI interrupt links.
+func amlInterrupt(irqs ...uint32) *aml {
+	a := &aml{buf: []byte{0x89}}
+	a.buf = binary.LittleEndian.AppendUint16(a.buf, uint16(2+4*len(irqs)))
+	a.buf = append(a.buf, 0x0b, byte(len(irqs)))
+	for _, irq := range irqs {
+		a.buf = binary.LittleEndian.AppendUint32(a.buf, irq)
+	}
+	return a
+}
+
+// Address space descriptor resource types and flags.
+const (
+	amlMemoryRange    = 0x00
+	amlIORange        = 0x01
+	amlBusNumberRange = 0x02
+
+	// General flags: fixed minimum and maximum address, positive decoding.
+	amlMinMaxFixed = 0x0c
+
+	amlNoTypeFlags  = 0x00
+	amlEntireRange  = 0x03
+	amlNonCacheable = 0x00
+	amlCacheable    = 0x01
+	amlReadWrite    = 0x01
+)
+
+func amlAddressSpaceDesc(tag byte, size int, resType, typeFlags uint8, gran, min, max, offset, length uint64) *aml {
+	a := &aml{buf: []byte{tag}}
+	a.buf = binary.LittleEndian.AppendUint16(a.buf, uint16(3+5*size))
+	a.buf = append(a.buf, resType, amlMinMaxFixed, typeFlags)
+	for _, v := range []uint64{gran, min, max, offset, length} {
+		a.buf = amlAppendIntNoPrefix(a.buf, v, size)
+	}
+	return a
+}
+
+func amlWordBusNumber(min, max uint16) *aml {
+	return amlAddressSpaceDesc(0x88, 2, amlBusNumberRange, amlNoTypeFlags, 0, uint64(min), uint64(max), 0, uint64(max)-uint64(min)+1)
+}
+
+func amlWordIO(min, max uint16) *aml {
+	return amlAddressSpaceDesc(0x88, 2, amlIORange, amlEntireRange, 0, uint64(min), uint64(max), 0, uint64(max)-uint64(min)+1)
+}
+
+func amlDWordMemory(cacheable uint8, min, max uint32) *aml {
+	return amlAddressSpaceDesc(0x87, 4, amlMemoryRange, cacheable<<1|amlReadWrite, 0, uint64(min), uint64(max), 0, uint64(max)-uint64(min)+1)
+}
+
+func amlQWordMemory(cacheable uint8, min, max uint64) *aml {
+	return amlAddressSpaceDesc(0x8a, 8, amlMemoryRange, cacheable<<1|amlReadWrite, 0, min, max, 0, max-min+1)
+}
@@ -0,0 +1,166 @@
+package tdxmeasure
+
+import (
+	"bytes"
+	"crypto"
+	"debug/pe"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/foxboron/go-uefi/efi/signature"
+)
+
+// peImage holds the layout of a PE/COFF image needed to compute its Authenticode hash without
+// reading the whole image into memory.
+type peImage struct {
+	r    io.ReaderAt
+	size int64
+
+	checksumOffset int64
+	certDirOffset  int64
+	sizeOfHeaders  int64
+	sections       []*pe.Section
+	certificateDir pe.DataDirectory
+}
+
+// parsePEImage parses the headers of a PE/COFF image of the given size.
+func parsePEImage(r io.ReaderAt, size int64) (*peImage, error) {
+	f, err := pe.NewFile(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var dosHeader [0x40]byte
+	if _, err := r.ReadAt(dosHeader[:], 0); err != nil {
+		return nil, err
+	}
+	// The optional header follows the 4 byte PE signature and the COFF file header.
+	optHeaderOffset := int64(binary.LittleEndian.Uint32(dosHeader[0x3c:])) + 4 + int64(binary.Size(f.FileHeader))
+
+	img := &peImage{
+		r:              r,
+		size:           size,
+		checksumOffset: optHeaderOffset + 64,
+		sections:       f.Sections,
+	}
+	switch h := f.OptionalHeader.(type) {
+	case *pe.OptionalHeader32:
+		img.certDirOffset = optHeaderOffset + 128
+		img.sizeOfHeaders = int64(h.SizeOfHeaders)
+		img.certificateDir = h.DataDirectory[pe.IMAGE_DIRECTORY_ENTRY_SECURITY]
+	case *pe.OptionalHeader64:
+		img.certDirOffset = optHeaderOffset + 144
+		img.sizeOfHeaders = int64(h.SizeOfHeaders)
+		img.certificateDir = h.DataDirectory[pe.IMAGE_DIRECTORY_ENTRY_SECURITY]
+	default:
+		return nil, fmt.Errorf("missing optional header")
+	}
+	if img.sizeOfHeaders < img.certDirOffset+8 || img.sizeOfHeaders > size {
+		return nil, fmt.Errorf("malformed size of headers")
+	}
+	return img, nil
+}
+
+// hash computes the Authenticode hash of the image, streaming the hashed ranges from the
+// underlying reader.
+func (img *peImage) hash(alg crypto.Hash) ([]byte, error) {
+	h := alg.New()
+	copyRange := func(start, end int64) error {
+		n, err := io.Copy(h, io.NewSectionReader(img.r, start, end-start))
+		if err != nil {
+			return err
+		}
+		if n != end-start {
+			return fmt.Errorf("image is truncated at offset %d", start+n)
+		}
+		return nil
+	}
+
+	// Hash the headers, skipping the checksum and the certificate table entry.
+	if err := copyRange(0, img.checksumOffset); err != nil {
+		return nil, err
+	}
+	if err := copyRange(img.checksumOffset+4, img.certDirOffset); err != nil {
+		return nil, err
+	}
+	if err := copyRange(img.certDirOffset+8, img.sizeOfHeaders); err != nil {
+		return nil, err
+	}
+
+	// Hash the sections in the order of their file offsets.
+	sections := append([]*pe.Section{}, img.sections...)
+	sort.SliceStable(sections, func(i, j int) bool { return sections[i].Offset < sections[j].Offset })
+	sumOfBytesHashed := img.sizeOfHeaders
+	for _, s := range sections {
+		if s.Size == 0 {
+			continue
+		}
+		if err := copyRange(int64(s.Offset), int64(s.Offset)+int64(s.Size)); err != nil {
+			return nil, fmt.Errorf("section %s: %w", s.Name, err)
+		}
+		sumOfBytesHashed += int64(s.Size)
+	}
+
+	// Hash the data following the sections, except for the certificate table, and pad the
+	// hashed data as if the file size was a multiple of 8 bytes.
+	fileSize := max(img.size, sumOfBytesHashed)
+	extraEnd := fileSize - int64(img.certificateDir.Size)
+	if extraEnd < sumOfBytesHashed {
+		return nil, fmt.Errorf("malformed certificate table")
+	}
+	if err := copyRange(sumOfBytesHashed, extraEnd); err != nil {
+		return nil, err
+	}
+	if pad := (8 - fileSize%8) % 8; pad != 0 {
+		h.Write(make([]byte, pad))
+	}
+	return h.Sum(nil), nil
+}
+
+// signatures returns the Authenticode signatures embedded in the certificate table.
+func (img *peImage) signatures() ([]*signature.WINCertificate, error) {
+	dir := img.certificateDir
+	if dir.Size == 0 {
+		return nil, nil
+	}
+	if int64(dir.VirtualAddress)+int64(dir.Size) > img.size {
+		return nil, fmt.Errorf("certificate table is outside of the image")
+	}
+	table := make([]byte, dir.Size)
+	if _, err := img.r.ReadAt(table, int64(dir.VirtualAddress)); err != nil {
+		return nil, err
+	}
+
+	var sigs []*signature.WINCertificate
+	reader := bytes.NewReader(table)
+	for reader.Len() > signature.SizeofWINCertificate {
+		sig, err := signature.ReadWinCertificate(reader)
+		if err != nil {
+			return nil, fmt.Errorf("malformed certificate table: %w", err)
+		}
+		sigs = append(sigs, &sig)
+
+		// Each entry is padded to 8 bytes.
+		if pad := (8 - int64(sig.Length)%8) % 8; pad != 0 {
+			reader.Seek(pad, io.SeekCurrent)
+		}
+	}
+	return sigs, nil
+}
+
+// headerOverlay is an io.ReaderAt that reads the underlying image with its leading bytes
+// replaced by a (patched) copy of the header.
+type headerOverlay struct {
+	r      io.ReaderAt
+	header []byte
+}
+
+func (o *headerOverlay) ReadAt(p []byte, off int64) (int, error) {
+	n, err := o.r.ReadAt(p, off)
+	if off < int64(len(o.header)) {
+		copy(p[:n], o.header[off:])
+	}
+	return n, err
+}
@@ -0,0 +1,117 @@
+package tdxmeasure
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// acpiCacheVersion identifies the ACPI table generator in the cache keys. It must be bumped
+// whenever the generated tables change, so that stale measurements on disk aren't used.
+const acpiCacheVersion = 1
+
+// acpiHashes are the digests of the ACPI tables, RSDP and table loader blobs.
+type acpiHashes [3][48]byte
+
+// ACPICache caches the measurements of the ACPI tables by machine configuration, which are most of
+// the cost of calculating RTMR0. The tables don't depend on the firmware or the boot inputs, so
+// measurements of the same machine configuration share them.
+//
+// The cache is kept in memory and, if a directory is given, on disk, so that it's shared across
+// processes. It is safe for concurrent use.
+type ACPICache struct {
+	dir string
+
+	mu      sync.Mutex
+	entries map[string]acpiHashes
+}
+
+// NewACPICache creates an ACPI table cache, stored in the given directory if it is not empty.
+func NewACPICache(dir string) (*ACPICache, error) {
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, fmt.Errorf("creating cache directory: %w", err)
+		}
+	}
+	return &ACPICache{dir: dir, entries: make(map[string]acpiHashes)}, nil
+}
+
+// acpiCacheKey returns the key of the machine configuration of opts.
+func acpiCacheKey(opts *Options) string {
+	config := struct {
+		Version       int
+		MemorySize    uint64
+		MaxRAMBelow4G uint64
+		PCIHole64Size uint64
+		CPUCount      uint32
+		Sockets       uint32
+		Devices       *DeviceConfig
+		NUMA          *NUMAConfig
+	}{acpiCacheVersion, opts.MemorySize, opts.MaxRAMBelow4G, opts.PCIHole64Size, opts.CPUCount, max(opts.Sockets, 1), opts.Devices, opts.NUMA}
+	if config.Devices == nil {
+		config.Devices = DefaultDeviceConfig()
+	}
+	data, _ := json.Marshal(config)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// get returns the cached measurements of the ACPI tables of opts, computing them if needed.
+func (c *ACPICache) get(opts *Options, compute func() (acpiHashes, error)) (acpiHashes, error) {
+	key := acpiCacheKey(opts)
+	c.mu.Lock()
+	hashes, ok := c.entries[key]
+	c.mu.Unlock()
+	if ok {
+		return hashes, nil
+	}
+
+	path := filepath.Join(c.dir, key)
+	if c.dir != "" {
+		// Unreadable or truncated entries are recomputed.
+		if data, err := os.ReadFile(path); err == nil && len(data) == len(hashes)*48 {
+			for i := range hashes {
+				copy(hashes[i][:], data[i*48:])
+			}
+			c.put(key, hashes)
+			return hashes, nil
+		}
+	}
+
+	hashes, err := compute()
+	if err != nil {
+		return hashes, err
+	}
+	c.put(key, hashes)
+	if c.dir != "" {
+		var data []byte
+		for _, h := range hashes {
+			data = append(data, h[:]...)
+		}
+		// Write to a temporary file first so that concurrent readers never see partial entries.
+		// Failing to store the entry only costs recomputing it later.
+		if f, err := os.CreateTemp(c.dir, key+".tmp*"); err == nil {
+			_, err = f.Write(data)
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+			if err == nil {
+				err = os.Rename(f.Name(), path)
+			}
+			if err != nil {
+				os.Remove(f.Name())
+			}
+		}
+	}
+	return hashes, nil
+}
+
+func (c *ACPICache) put(key string, hashes acpiHashes) {
+	c.mu.Lock()
+	c.entries[key] = hashes
+	c.mu.Unlock()
+}
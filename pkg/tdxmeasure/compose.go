@@ -0,0 +1,166 @@
+package tdxmeasure
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// CanonicalAppCompose returns the canonical encoding of a dstack app-compose.json document, the
+// form the dstack SDKs hash and deploy: JSON with sorted keys, no whitespace and non-ASCII
+// characters left unescaped, byte for byte as Python's
+// json.dumps(app_compose, sort_keys=True, separators=(",", ":"), ensure_ascii=False).
+func CanonicalAppCompose(data []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var compose any
+	if err := dec.Decode(&compose); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidAppCompose, err)
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, fmt.Errorf("%w: trailing data after the document", ErrInvalidAppCompose)
+	}
+	if _, ok := compose.(map[string]any); !ok {
+		return nil, fmt.Errorf("%w: not a JSON object", ErrInvalidAppCompose)
+	}
+
+	var buf bytes.Buffer
+	if err := writeCanonicalJSON(&buf, compose); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// ComposeHash returns the SHA-256 hash of an app-compose.json document, byte for byte as
+// deployed. The guest extends it into RTMR3 as the "compose-hash" event. Documents written by hand
+// should be converted with CanonicalAppCompose first if they are deployed by the dstack SDKs.
+func ComposeHash(data []byte) [32]byte {
+	return sha256.Sum256(data)
+}
+
+// AppIDFromComposeHash returns the app ID dstack derives from a compose hash, its first 20 bytes,
+// for apps whose ID isn't assigned by the KMS.
+func AppIDFromComposeHash(composeHash [32]byte) []byte {
+	return slices.Clone(composeHash[:20])
+}
+
+// writeCanonicalJSON writes a decoded JSON value the way Python's json module does.
+func writeCanonicalJSON(buf *bytes.Buffer, v any) error {
+	switch v := v.(type) {
+	case nil:
+		buf.WriteString("null")
+	case bool:
+		buf.WriteString(strconv.FormatBool(v))
+	case string:
+		writePythonJSONString(buf, v)
+	case json.Number:
+		number, err := pythonJSONNumber(v)
+		if err != nil {
+			return err
+		}
+		buf.WriteString(number)
+	case []any:
+		buf.WriteByte('[')
+		for i, item := range v {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := writeCanonicalJSON(buf, item); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		// Byte order of UTF-8 strings is code point order, which Python sorts keys by.
+		slices.Sort(keys)
+		buf.WriteByte('{')
+		for i, key := range keys {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			writePythonJSONString(buf, key)
+			buf.WriteByte(':')
+			if err := writeCanonicalJSON(buf, v[key]); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+	default:
+		return fmt.Errorf("%w: unexpected value %v", ErrInvalidAppCompose, v)
+	}
+	return nil
+}
+
+// writePythonJSONString writes a string with only quotes, backslashes and control characters
+// escaped.
+func writePythonJSONString(buf *bytes.Buffer, s string) {
+	buf.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			buf.WriteString(`\"`)
+		case '\\':
+			buf.WriteString(`\\`)
+		case '\n':
+			buf.WriteString(`\n`)
+		case '\r':
+			buf.WriteString(`\r`)
+		case '\t':
+			buf.WriteString(`\t`)
+		case '\b':
+			buf.WriteString(`\b`)
+		case '\f':
+			buf.WriteString(`\f`)
+		default:
+			if r < 0x20 {
+				fmt.Fprintf(buf, `\u%04x`, r)
+			} else {
+				buf.WriteRune(r)
+			}
+		}
+	}
+	buf.WriteByte('"')
+}
+
+// pythonJSONNumber formats a number the way Python does after parsing it: integers exactly,
+// other numbers as the shortest representation of the float64 value, e.g. 1.0 or 1e+16.
+func pythonJSONNumber(n json.Number) (string, error) {
+	if !strings.ContainsAny(string(n), ".eE") {
+		i, ok := new(big.Int).SetString(string(n), 10)
+		if !ok {
+			return "", fmt.Errorf("%w: invalid number %s", ErrInvalidAppCompose, n)
+		}
+		return i.String(), nil
+	}
+
+	f, err := strconv.ParseFloat(string(n), 64)
+	if err != nil && !math.IsInf(f, 0) {
+		return "", fmt.Errorf("%w: invalid number %s", ErrInvalidAppCompose, n)
+	}
+	switch {
+	case math.IsInf(f, 1):
+		return "Infinity", nil
+	case math.IsInf(f, -1):
+		return "-Infinity", nil
+	}
+	// Python's repr switches to the exponent notation outside of 1e-4 <= |f| < 1e16.
+	if abs := math.Abs(f); abs != 0 && (abs < 1e-4 || abs >= 1e16) {
+		return strconv.FormatFloat(f, 'e', -1, 64), nil
+	}
+	s := strconv.FormatFloat(f, 'f', -1, 64)
+	if !strings.Contains(s, ".") {
+		s += ".0"
+	}
+	return s, nil
+}
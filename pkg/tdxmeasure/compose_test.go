@@ -0,0 +1,70 @@
+package tdxmeasure
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCanonicalAppCompose(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"sorted keys", `{"runner": "docker-compose", "name": "app", "features": ["kms"]}`, `{"features":["kms"],"name":"app","runner":"docker-compose"}`},
+		{"nested", "{\n  \"b\": {\"z\": null, \"a\": [true, false, {}]},\n  \"a\": []\n}\n", `{"a":[],"b":{"a":[true,false,{}],"z":null}}`},
+		{"key order by code point", `{"b": 1, "B": 2, "é": 3, "_": 4, "aa": 5, "a": 6}`, `{"B":2,"_":4,"a":6,"aa":5,"b":1,"é":3}`},
+		{"non-ASCII", `{"name": "café 😀"}`, `{"name":"café 😀"}`},
+		{"escapes", `{"compose": "a\"b\\c\/d\n\t\r\b\f\u0001\u007f"}`, "{\"compose\":\"a\\\"b\\\\c/d\\n\\t\\r\\b\\f\\u0001\u007f\"}"},
+		{"HTML characters", `{"s": "<a & b>"}`, `{"s":"<a & b>"}`},
+		{"integers", `{"a": 0, "b": -12, "c": 123456789012345678901234567890}`, `{"a":0,"b":-12,"c":123456789012345678901234567890}`},
+		{"floats", `{"a": 1.0, "b": 1.50, "c": 1e3, "d": 1e16, "e": 0.0001, "f": 0.00001, "g": -0.0, "h": 1e400, "i": 0.1}`, `{"a":1.0,"b":1.5,"c":1000.0,"d":1e+16,"e":0.0001,"f":1e-05,"g":-0.0,"h":Infinity,"i":0.1}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := CanonicalAppCompose([]byte(tt.input))
+			require.NoError(t, err)
+			require.Equal(t, tt.want, string(got))
+
+			// The canonical encoding is its own canonical encoding, unless it has
+			// non-JSON numbers.
+			if tt.name != "floats" {
+				again, err := CanonicalAppCompose(got)
+				require.NoError(t, err)
+				require.Equal(t, tt.want, string(again))
+			}
+		})
+	}
+}
+
+func TestCanonicalAppComposeErrors(t *testing.T) {
+	for _, input := range []string{
+		``,
+		`[]`,
+		`"app"`,
+		`null`,
+		`{"a": 1`,
+		`{"a": 1} {}`,
+		`{"a": 1}x`,
+		`{a: 1}`,
+	} {
+		_, err := CanonicalAppCompose([]byte(input))
+		require.ErrorIs(t, err, ErrInvalidAppCompose, "%q", input)
+	}
+}
+
+func TestComposeHash(t *testing.T) {
+	// The guest hashes the document as deployed, whether or not it's canonical.
+	data := []byte("{\"name\": \"app\", \"runner\": \"docker-compose\"}\n")
+	hash := ComposeHash(data)
+	require.Equal(t, sha256.Sum256(data), hash)
+	canonical, err := CanonicalAppCompose(data)
+	require.NoError(t, err)
+	canonicalHash := ComposeHash(canonical)
+	require.Equal(t, "8b7592790b3c2baa54b2a293e84076b1bbbb43bb61c9c3e58c360fc399454e23", hex.EncodeToString(canonicalHash[:]))
+	require.NotEqual(t, hash, canonicalHash)
+	require.Equal(t, hash[:20], AppIDFromComposeHash(hash))
+}
@@ -0,0 +1,128 @@
+package tdxmeasure
+
+import (
+	"fmt"
+	"slices"
+)
+
+// DeviceConfig describes the devices attached to the guest, as far as they are reflected in the
+// ACPI tables generated by QEMU.
+type DeviceConfig struct {
+	// PCIDevices are the devices on the root PCI bus (pcie.0), besides the host bridge (00.0) and
+	// the ICH9 functions (1f.x) that are always present.
+	PCIDevices []PCIDevice `json:"pci_devices"`
+	// PCIHole64Start and PCIHole64Size describe the 64-bit MMIO window of the host bridge. QEMU
+	// reports the window spanning the 64-bit BARs assigned by the firmware, which is at least
+	// as large as the pci-hole64-size of the host bridge (Options.PCIHole64Size). Devices with
+	// large BARs, e.g. passed-through GPUs, grow the window. Zero values select the window of a
+	// guest with virtio devices only.
+	PCIHole64Start uint64 `json:"pci_hole64_start,omitempty"`
+	PCIHole64Size  uint64 `json:"pci_hole64_size,omitempty"`
+}
+
+// PCIDevice is a PCI function of the guest. Endpoints such as virtio-net, virtio-blk, vhost-vsock
+// or vfio-pci devices are only described by their address; bridges and PCIe root ports also
+// describe the functions on their secondary bus.
+type PCIDevice struct {
+	// Name is informational, e.g. "virtio-net".
+	Name     string `json:"name,omitempty"`
+	Slot     uint8  `json:"slot"`
+	Function uint8  `json:"function,omitempty"`
+	// ACPIIndex is the acpi-index property of a device on the root bus, zero if not set.
+	ACPIIndex uint32 `json:"acpi_index,omitempty"`
+	// Devices are the functions on the secondary bus of a PCI bridge or PCIe root port. They can
+	// only be described if ACPI hotplug on bridges is disabled in QEMU
+	// (-global ICH9-LPC.acpi-pci-hotplug-with-bridge-support=off).
+	Devices []PCIDevice `json:"devices,omitempty"`
+}
+
+// DefaultDeviceConfig returns the device configuration of a dstack guest, which has four virtio
+// devices in slots 1 to 4 of the root bus.
+func DefaultDeviceConfig() *DeviceConfig {
+	return &DeviceConfig{
+		PCIDevices: []PCIDevice{{Slot: 1}, {Slot: 2}, {Slot: 3}, {Slot: 4}},
+	}
+}
+
+// devfn returns the encoded device and function number.
+func (d *PCIDevice) devfn() uint8 {
+	return d.Slot<<3 | d.Function
+}
+
+// ich9Slot is the root bus slot of the ICH9 LPC bridge, SATA and SMBus controllers.
+const ich9Slot = 0x1f
+
+// check validates the device configuration.
+func (c *DeviceConfig) check() error {
+	if c.PCIHole64Size != 0 && c.PCIHole64Start == 0 || c.PCIHole64Start+c.PCIHole64Size < c.PCIHole64Start {
+		return fmt.Errorf("%w: invalid 64-bit PCI hole %#x+%#x", ErrUnsupportedConfig, c.PCIHole64Start, c.PCIHole64Size)
+	}
+	return checkPCIBus(c.PCIDevices, true)
+}
+
+// checkPCIBus validates the functions on a PCI bus.
+func checkPCIBus(devices []PCIDevice, root bool) error {
+	seen := make(map[uint8]bool)
+	for _, d := range devices {
+		if d.Slot > 0x1f || d.Function > 7 {
+			return fmt.Errorf("%w: invalid PCI address %02x.%x", ErrUnsupportedConfig, d.Slot, d.Function)
+		}
+		if root && (d.Slot == 0 || d.Slot == ich9Slot) {
+			return fmt.Errorf("%w: PCI slot %02x of the root bus is reserved", ErrUnsupportedConfig, d.Slot)
+		}
+		if seen[d.devfn()] {
+			return fmt.Errorf("%w: duplicate PCI address %02x.%x", ErrUnsupportedConfig, d.Slot, d.Function)
+		}
+		seen[d.devfn()] = true
+		if !root && d.ACPIIndex != 0 {
+			return fmt.Errorf("%w: acpi-index is only supported on the root bus", ErrUnsupportedConfig)
+		}
+		if err := checkPCIBus(d.Devices, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pciHole64 returns the first and last address of the 64-bit MMIO window, given the minimum
+// size of the hole.
+func (c *DeviceConfig) pciHole64(minSize uint64) (uint64, uint64) {
+	if c.PCIHole64Start == 0 {
+		return qemuPCIHole64Start, qemuPCIHole64Start + minSize - 1
+	}
+	size := max(c.PCIHole64Size, minSize)
+	return c.PCIHole64Start, c.PCIHole64Start + size - 1
+}
+
+// buildPCIBus appends the device objects of the given functions to the bus scope, in the order of
+// their addresses as QEMU does.
+func buildPCIBus(scope *aml, devices []PCIDevice, root bool) {
+	devices = slices.Clone(devices)
+	slices.SortFunc(devices, func(a, b PCIDevice) int { return int(a.devfn()) - int(b.devfn()) })
+	for _, d := range devices {
+		scope.append(buildPCIDevice(&d, root))
+	}
+}
+
+// buildPCIDevice builds the device object of a PCI function.
+func buildPCIDevice(d *PCIDevice, root bool) *aml {
+	dev := amlDevice("S%02X", d.devfn())
+	dev.append(amlNameDecl("_ADR", amlInt(uint64(d.Slot)<<16|uint64(d.Function))))
+	buildPCIBus(dev, d.Devices, false)
+	if root && d.ACPIIndex != 0 {
+		dev.append(buildPCIStaticEndpointDSM(d.ACPIIndex))
+	}
+	return dev
+}
+
+// buildPCIStaticEndpointDSM builds the _DSM method of a non-hotpluggable device with an
+// acpi-index, which forwards to EDSM.
+func buildPCIStaticEndpointDSM(acpiIndex uint32) *aml {
+	params := amlLocal(0)
+	method := amlMethod("_DSM", 4, true)
+	method.append(
+		amlStore(amlPackage(1).append(amlInt(uint64(acpiIndex))), params),
+		amlReturn(amlCall("EDSM", amlArg(0), amlArg(1), amlArg(2), amlArg(3), params)),
+	)
+	return method
+}
@@ -0,0 +1,217 @@
+package tdxmeasure
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testDeviceConfigs are the device configurations of testdata/acpi-devices.txt, with the QEMU
+// options they correspond to.
+var testDeviceConfigs = map[string]*DeviceConfig{
+	// -device virtio-net-pci,addr=0x1,acpi-index=1 -device virtio-blk-pci,addr=0x2,acpi-index=2
+	// -device vhost-vsock-pci,addr=0x3
+	"acpi-index": {PCIDevices: []PCIDevice{
+		{Name: "virtio-net", Slot: 1, ACPIIndex: 1},
+		{Name: "virtio-blk", Slot: 2, ACPIIndex: 2},
+		{Name: "vhost-vsock", Slot: 3},
+	}},
+	// The dstack devices, and a GPU passed through on a root port whose BARs grow the 64-bit PCI
+	// window to 96 GiB:
+	// -device pcie-root-port,id=rp0,addr=0x5 -device vfio-pci,host=...,bus=rp0
+	"vfio": {
+		PCIDevices: []PCIDevice{
+			{Name: "virtio-net", Slot: 1}, {Name: "virtio-blk", Slot: 2},
+			{Name: "vhost-vsock", Slot: 3}, {Name: "virtio-console", Slot: 4},
+			{Name: "pcie-root-port", Slot: 5, Devices: []PCIDevice{{Name: "vfio-pci", Slot: 0}}},
+		},
+		PCIHole64Start: 0x380000000000,
+		PCIHole64Size:  0x1800000000,
+	},
+	// Four root ports in the functions of a multifunction slot and two in single slots, given out
+	// of order: -device pcie-root-port,addr=0x6.0x0,multifunction=on ... addr=0x6.0x3,
+	// -device pcie-root-port,addr=0x8 -device pcie-root-port,addr=0x7
+	"root-ports": {PCIDevices: []PCIDevice{
+		{Name: "virtio-net", Slot: 1},
+		{Name: "pcie-root-port", Slot: 8},
+		{Name: "pcie-root-port", Slot: 6, Function: 3},
+		{Name: "pcie-root-port", Slot: 6},
+		{Name: "pcie-root-port", Slot: 6, Function: 2},
+		{Name: "pcie-root-port", Slot: 6, Function: 1},
+		{Name: "pcie-root-port", Slot: 7},
+	}},
+	// A PCI bridge behind a PCIe-to-PCI bridge, with devices on both:
+	// -device pcie-pci-bridge,id=br0,addr=0x9 -device pci-bridge,id=br1,bus=br0,addr=0x1,chassis_nr=1
+	// -device virtio-net-pci,bus=br0,addr=0x2 -device virtio-blk-pci,bus=br1,addr=0x1f
+	// -device virtio-blk-pci,bus=br1,addr=0x0
+	"nested-bridges": {PCIDevices: []PCIDevice{
+		{Name: "virtio-net", Slot: 1},
+		{Name: "pcie-pci-bridge", Slot: 9, Devices: []PCIDevice{
+			{Name: "pci-bridge", Slot: 1, Devices: []PCIDevice{
+				{Name: "virtio-blk", Slot: 0x1f},
+				{Name: "virtio-blk", Slot: 0},
+			}},
+			{Name: "virtio-net", Slot: 2},
+		}},
+	}},
+	// No devices besides the host bridge and the ICH9 functions.
+	"none": {},
+}
+
+// TestGenerateTablesQemuDevices checks the tables of the device configurations against
+// testdata/acpi-devices.txt.
+func TestGenerateTablesQemuDevices(t *testing.T) {
+	configs := make(map[string]Options)
+	for name, devices := range testDeviceConfigs {
+		configs[name] = Options{MemorySize: 4 << 30, CPUCount: 4, Devices: devices}
+	}
+	testGoldenTables(t, "testdata/acpi-devices.txt", configs)
+
+	// The default devices are the ones of a dstack guest.
+	opts := Options{MemorySize: 4 << 30, CPUCount: 4}
+	want, _, _, err := GenerateTablesQemu(opts)
+	require.NoError(t, err)
+	opts.Devices = &DeviceConfig{PCIDevices: testDeviceConfigs["vfio"].PCIDevices[:4]}
+	got, _, _, err := GenerateTablesQemu(opts)
+	require.NoError(t, err)
+	require.Equal(t, want, got)
+}
+
+// testAML decodes hex encoded AML, ignoring spaces.
+func testAML(t *testing.T, s string) []byte {
+	b, err := hex.DecodeString(strings.ReplaceAll(s, " ", ""))
+	require.NoError(t, err)
+	return b
+}
+
+// TestGenerateTablesQemuPCIDevices checks the device objects of the PCI functions in the DSDT
+// against their hand-assembled AML.
+func TestGenerateTablesQemuPCIDevices(t *testing.T) {
+	dsdt := func(devices *DeviceConfig) []byte {
+		blob, _, _, err := GenerateTablesQemu(Options{MemorySize: 4 << 30, CPUCount: 4, Devices: devices})
+		require.NoError(t, err)
+		return testACPITables(t, blob)["DSDT"]
+	}
+
+	// Device (S08) { Name (_ADR, 0x00010000) }
+	plain := testAML(t, "5b82 0f 5330385f 085f414452 0c00000100")
+	// Device (S10) {
+	//     Name (_ADR, 0x00020000)
+	//     Method (_DSM, 4, Serialized) {
+	//         Local0 = Package (1) { 0x10 }
+	//         Return (EDSM (Arg0, Arg1, Arg2, Arg3, Local0))
+	//     }
+	// }
+	withIndex := testAML(t, "5b82 27 5331305f 085f414452 0c00000200 "+
+		"14 17 5f44534d 0c 70 12 04 01 0a10 60 a4 4544534d 68 69 6a 6b 60")
+	// Device (S28) { Name (_ADR, 0x00050000) Device (S00) { Name (_ADR, Zero) } }
+	rootPort := testAML(t, "5b82 1c 5332385f 085f414452 0c00000500 5b82 0b 5330305f 085f414452 00")
+	// Device (S48) {
+	//     Name (_ADR, 0x00090000)
+	//     Device (S08) {
+	//         Name (_ADR, 0x00010000)
+	//         Device (S00) { Name (_ADR, Zero) }
+	//         Device (SF8) { Name (_ADR, 0x001F0000) }
+	//     }
+	//     Device (S10) { Name (_ADR, 0x00020000) }
+	// }
+	nested := testAML(t, "5b82 4005 5334385f 085f414452 0c00000900 "+
+		"5b82 2d 5330385f 085f414452 0c00000100 "+
+		"5b82 0b 5330305f 085f414452 00 "+
+		"5b82 0f 5346385f 085f414452 0c00001f00 "+
+		"5b82 0f 5331305f 085f414452 0c00000200")
+
+	table := dsdt(&DeviceConfig{PCIDevices: []PCIDevice{
+		{Slot: 9, Devices: []PCIDevice{
+			{Slot: 2},
+			{Slot: 1, Devices: []PCIDevice{{Slot: 0x1f}, {Slot: 0}}},
+		}},
+		{Slot: 5, Devices: []PCIDevice{{Slot: 0}}},
+		{Slot: 2, ACPIIndex: 0x10},
+		{Slot: 1},
+	}})
+	var last int
+	for name, want := range map[string][]byte{"plain": plain, "acpi-index": withIndex, "root port": rootPort, "nested": nested} {
+		require.True(t, bytes.Contains(table, want), name)
+	}
+	// The devices of the root bus are ordered by their address.
+	for _, want := range [][]byte{plain, withIndex, rootPort, nested} {
+		i := bytes.Index(table, want)
+		require.Greater(t, i, last)
+		last = i
+	}
+	// EDSM is only called by devices with an acpi-index.
+	require.Equal(t, 2, bytes.Count(table, []byte("EDSM")))
+	require.Equal(t, 1, bytes.Count(dsdt(nil), []byte("EDSM")))
+}
+
+// testPCIHole64 checks that the _CRS of the host bridge has a single 64-bit window with the given
+// start and size.
+func testPCIHole64(t *testing.T, opts Options, start, size uint64) {
+	blob, _, _, err := GenerateTablesQemu(opts)
+	require.NoError(t, err)
+	dsdt := testACPITables(t, blob)["DSDT"]
+
+	// QWordMemory (ResourceProducer, PosDecode, MinFixed, MaxFixed, Cacheable, ReadWrite, 0,
+	// start, end, 0, length)
+	desc := []byte{0x8a, 0x2b, 0x00, 0x00, 0x0c, 0x03}
+	for _, v := range []uint64{0, start, start + size - 1, 0, size} {
+		desc = binary.LittleEndian.AppendUint64(desc, v)
+	}
+	require.Equal(t, 1, bytes.Count(dsdt, desc[:3]))
+	require.True(t, bytes.Contains(dsdt, desc), "64-bit window %#x+%#x", start, size)
+}
+
+// TestGenerateTablesQemuPCIHole64 checks the 64-bit window in the _CRS of the host bridge.
+func TestGenerateTablesQemuPCIHole64(t *testing.T) {
+	opts := Options{MemorySize: 4 << 30, CPUCount: 1}
+	testPCIHole64(t, opts, 0x380000000000, 32<<30)
+	opts.Devices = testDeviceConfigs["vfio"]
+	testPCIHole64(t, opts, 0x380000000000, 96<<30)
+	// The window is at least 32 GiB large.
+	opts.Devices = &DeviceConfig{PCIHole64Start: 0x800000000, PCIHole64Size: 16 << 30}
+	testPCIHole64(t, opts, 0x800000000, 32<<30)
+}
+
+func TestDeviceConfigCheck(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  DeviceConfig
+		wantErr bool
+	}{
+		{"default", *DefaultDeviceConfig(), false},
+		{"none", DeviceConfig{}, false},
+		{"multifunction", DeviceConfig{PCIDevices: []PCIDevice{{Slot: 6}, {Slot: 6, Function: 7}}}, false},
+		{"slots 0 and 31 behind a bridge", DeviceConfig{PCIDevices: []PCIDevice{{Slot: 5, Devices: []PCIDevice{{Slot: 0}, {Slot: 0x1f}}}}}, false},
+		{"same address on different buses", DeviceConfig{PCIDevices: []PCIDevice{{Slot: 5, Devices: []PCIDevice{{Slot: 5}}}}}, false},
+		{"64-bit window", DeviceConfig{PCIHole64Start: 0x380000000000, PCIHole64Size: 1 << 40}, false},
+		{"64-bit window start only", DeviceConfig{PCIHole64Start: 0x380000000000}, false},
+		{"slot out of range", DeviceConfig{PCIDevices: []PCIDevice{{Slot: 0x20}}}, true},
+		{"function out of range", DeviceConfig{PCIDevices: []PCIDevice{{Slot: 1, Function: 8}}}, true},
+		{"host bridge slot", DeviceConfig{PCIDevices: []PCIDevice{{Slot: 0}}}, true},
+		{"ICH9 slot", DeviceConfig{PCIDevices: []PCIDevice{{Slot: 0x1f, Function: 4}}}, true},
+		{"duplicate address", DeviceConfig{PCIDevices: []PCIDevice{{Slot: 1}, {Slot: 2}, {Slot: 1}}}, true},
+		{"duplicate address behind a bridge", DeviceConfig{PCIDevices: []PCIDevice{{Slot: 5, Devices: []PCIDevice{{Slot: 1}, {Slot: 1}}}}}, true},
+		{"slot out of range behind nested bridges", DeviceConfig{PCIDevices: []PCIDevice{{Slot: 5, Devices: []PCIDevice{{Slot: 1, Devices: []PCIDevice{{Slot: 0x20}}}}}}}, true},
+		{"acpi-index behind a bridge", DeviceConfig{PCIDevices: []PCIDevice{{Slot: 5, Devices: []PCIDevice{{Slot: 0, ACPIIndex: 1}}}}}, true},
+		{"64-bit window size only", DeviceConfig{PCIHole64Size: 1 << 40}, true},
+		{"64-bit window overflow", DeviceConfig{PCIHole64Start: 0xffffff0000000000, PCIHole64Size: 1 << 40}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.config.check()
+			if tt.wantErr {
+				require.ErrorIs(t, err, ErrUnsupportedConfig)
+			} else {
+				require.NoError(t, err)
+			}
+			// The tables are only generated for valid configurations.
+			_, _, _, err = GenerateTablesQemu(Options{MemorySize: 1 << 30, CPUCount: 1, Devices: &tt.config})
+			require.Equal(t, tt.wantErr, err != nil, "%v", err)
+		})
+	}
+}
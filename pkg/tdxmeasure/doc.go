@@ -0,0 +1,10 @@
+// Package tdxmeasure calculates the expected TDX measurement registers (MRTD and RTMR0-3) of a
+// TD launched by QEMU from its firmware, kernel, initrd, VM configuration and dstack runtime
+// events.
+//
+// The measurements are computed with Measure, which also returns the predicted RTMR event log.
+// ParseQuote and ParseCcelEventLog parse the quote and event log produced by a running TD so
+// that they can be compared with the predicted values. ComposeHash computes the hash of a dstack
+// app-compose.json that the guest extends into RTMR3, and ParseAggregate defines digests over the
+// registers like mr_enclave and mr_image.
+package tdxmeasure
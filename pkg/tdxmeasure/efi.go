@@ -0,0 +1,112 @@
+package tdxmeasure
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"strings"
+	"unicode/utf16"
+
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+)
+
+const (
+	loadOptionActive      = 0x00000001
+	loadOptionHidden      = 0x00000008
+	loadOptionCategoryApp = 0x00000100
+
+	devicePathTypeMedia     = 0x04
+	devicePathTypeEnd       = 0x7f
+	devicePathSubTypeFvFile = 0x06
+	devicePathSubTypeFv     = 0x07
+	devicePathSubTypeEndAll = 0xff
+)
+
+// ovmfUiAppBootOption is the Boot0000 option created by OVMF's platform boot manager for the
+// UiApp (the firmware setup application) residing in the DXE firmware volume.
+var ovmfUiAppBootOption = &efiLoadOption{
+	attributes:  loadOptionActive | loadOptionHidden | loadOptionCategoryApp,
+	description: "UiApp",
+	filePath: encodeDevicePath(
+		devicePathFv("7CB8BDC9-F8EB-4F34-AAEA-3EE4AF6516A1"),     // DXEFV
+		devicePathFvFile("462CAA21-7614-4503-836E-8AB6F4662331"), // UiApp
+	),
+}
+
+// efiLoadOption is an EFI_LOAD_OPTION as stored in a Boot#### variable.
+type efiLoadOption struct {
+	attributes   uint32
+	description  string
+	filePath     []byte
+	optionalData []byte
+}
+
+// encode encodes the load option in the same way as the UEFI boot manager does:
+//
+//	4 byte attributes
+//	2 byte length of the device path list
+//	NUL-terminated UTF-16LE description
+//	device path list
+//	optional data
+func (o *efiLoadOption) encode() []byte {
+	var data []byte
+	data = binary.LittleEndian.AppendUint32(data, o.attributes)
+	data = binary.LittleEndian.AppendUint16(data, uint16(len(o.filePath)))
+	data = append(data, encodeUTF16(o.description+"\x00")...)
+	data = append(data, o.filePath...)
+	data = append(data, o.optionalData...)
+	return data
+}
+
+// encodeBootOrder encodes the BootOrder variable for the given boot option numbers.
+func encodeBootOrder(options []uint16) []byte {
+	var data []byte
+	for _, opt := range options {
+		data = binary.LittleEndian.AppendUint16(data, opt)
+	}
+	return data
+}
+
+// devicePathNode encodes a generic device path node.
+func devicePathNode(nodeType, subType uint8, data []byte) []byte {
+	node := []byte{nodeType, subType}
+	node = binary.LittleEndian.AppendUint16(node, uint16(4+len(data)))
+	return append(node, data...)
+}
+
+// devicePathFv encodes a MEDIA_PIWG_FW_VOL_DP node for the firmware volume with the given name.
+func devicePathFv(guid string) []byte {
+	return devicePathNode(devicePathTypeMedia, devicePathSubTypeFv, encodeGUID(guid))
+}
+
+// devicePathFvFile encodes a MEDIA_PIWG_FW_FILE_DP node for the firmware file with the given name.
+func devicePathFvFile(guid string) []byte {
+	return devicePathNode(devicePathTypeMedia, devicePathSubTypeFvFile, encodeGUID(guid))
+}
+
+// encodeDevicePath concatenates the given nodes and terminates the device path.
+func encodeDevicePath(nodes ...[]byte) []byte {
+	var path []byte
+	for _, node := range nodes {
+		path = append(path, node...)
+	}
+	return append(path, devicePathNode(devicePathTypeEnd, devicePathSubTypeEndAll, nil)...)
+}
+
+// encodeUTF16 converts the given string to UTF-16LE.
+func encodeUTF16(s string) []byte {
+	utf16le := unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM).NewEncoder()
+	xr := transform.NewReader(bytes.NewReader([]byte(s)), utf16le)
+	converted, _ := io.ReadAll(xr)
+	return converted
+}
+
+// decodeUTF16 converts the given UTF-16LE data to a string, dropping a terminating NUL.
+func decodeUTF16(data []byte) string {
+	chars := make([]uint16, len(data)/2)
+	for i := range chars {
+		chars[i] = binary.LittleEndian.Uint16(data[2*i:])
+	}
+	return strings.TrimSuffix(string(utf16.Decode(chars)), "\x00")
+}
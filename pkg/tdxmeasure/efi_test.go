@@ -0,0 +1,64 @@
+package tdxmeasure
+
+import (
+	"crypto/sha512"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func sha384Hex(data []byte) string {
+	digest := sha512.Sum384(data)
+	return hex.EncodeToString(digest[:])
+}
+
+// TestBootOptionDigests checks the boot variable digests against the values that were hardcoded
+// before they were computed.
+func TestBootOptionDigests(t *testing.T) {
+	require.Equal(t, "23ada07f5261f12f34a0bd8e46760962d6b4d576a416f1fea1c64bc656b1d28eacf7047ae6e967c58fd2a98bfa74c298",
+		sha384Hex(ovmfUiAppBootOption.encode()))
+	require.Equal(t, sha384Hex([]byte{0x00, 0x00}), sha384Hex(encodeBootOrder([]uint16{0})))
+}
+
+// TestMeasureTdxCfvImage checks that the CFV digest covers exactly the raw data of the CFV section.
+func TestMeasureTdxCfvImage(t *testing.T) {
+	fw := testFirmware(1, testTdvfSections)
+	meta, err := parseTdvfMetadata(fw)
+	require.NoError(t, err)
+	digest, err := measureTdxCfvImage(fw, meta)
+	require.NoError(t, err)
+	require.Equal(t, measureSha384(fw[:0x20000]), digest)
+
+	// The CFV event of the RTMR0 log has the same digest.
+	m, err := Measure(readTestMeasurements(t)[0].options())
+	require.NoError(t, err)
+	require.Equal(t, EvEfiPlatformFirmwareBlob2, m.EventLog[1].EventType)
+	require.Equal(t, digest, m.EventLog[1].Digest[:])
+
+	// Only changes of the CFV section change the digest.
+	modified := append([]byte{}, fw...)
+	modified[0x20000]++
+	changed, err := measureTdxCfvImage(modified, meta)
+	require.NoError(t, err)
+	require.Equal(t, digest, changed)
+	modified[0x1ffff]++
+	changed, err = measureTdxCfvImage(modified, meta)
+	require.NoError(t, err)
+	require.NotEqual(t, digest, changed)
+
+	// The CFV section is located through the metadata, not at a fixed offset.
+	sections := append([]testSection{}, testTdvfSections...)
+	sections[0].dataOffset, sections[0].rawDataSize = 0x40000, 0x10000
+	fw = testFirmware(1, sections)
+	meta, err = parseTdvfMetadata(fw)
+	require.NoError(t, err)
+	digest, err = measureTdxCfvImage(fw, meta)
+	require.NoError(t, err)
+	require.Equal(t, measureSha384(fw[0x40000:0x50000]), digest)
+
+	meta, err = parseTdvfMetadata(testFirmware(1, testTdvfSections[1:]))
+	require.NoError(t, err)
+	_, err = measureTdxCfvImage(fw, meta)
+	require.ErrorIs(t, err, ErrInvalidFirmware)
+}
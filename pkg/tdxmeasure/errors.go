@@ -0,0 +1,35 @@
+package tdxmeasure
+
+import "errors"
+
+// Errors returned by this package. They are wrapped with details about the failure and can be
+// matched with errors.Is.
+var (
+	// ErrInvalidFirmware is returned when the firmware is not a TDVF image, its metadata is
+	// malformed or it lacks a part that needs to be measured.
+	ErrInvalidFirmware = errors.New("invalid TDVF firmware")
+	// ErrInvalidKernel is returned when the kernel is not a Linux PE/COFF (EFI stub) image or is
+	// too old to be booted with the given configuration.
+	ErrInvalidKernel = errors.New("invalid kernel image")
+	// ErrInitrdTooLarge is returned when the initrd doesn't fit below the initrd load limit.
+	ErrInitrdTooLarge = errors.New("initrd is too large")
+	// ErrUnsupportedConfig is returned when the VM configuration can't be measured, e.g. when no
+	// ACPI tables can be generated for it.
+	ErrUnsupportedConfig = errors.New("unsupported VM configuration")
+	// ErrInvalidSecureBootKeys is returned when the Secure Boot key stores are malformed.
+	ErrInvalidSecureBootKeys = errors.New("invalid Secure Boot keys")
+	// ErrKernelNotAuthorized is returned when Secure Boot is enabled and no db entry authorizes
+	// the kernel image.
+	ErrKernelNotAuthorized = errors.New("kernel image is not authorized by any db entry")
+	// ErrInvalidQuote is returned when a TDX quote can't be parsed.
+	ErrInvalidQuote = errors.New("invalid TDX quote")
+	// ErrInvalidEventLog is returned when an event log can't be parsed.
+	ErrInvalidEventLog = errors.New("malformed event log")
+	// ErrInvalidKeyProvider is returned when a key provider measurement or descriptor is
+	// malformed.
+	ErrInvalidKeyProvider = errors.New("invalid key provider")
+	// ErrInvalidAppCompose is returned when an app-compose.json document isn't a JSON object.
+	ErrInvalidAppCompose = errors.New("invalid app compose")
+	// ErrInvalidAggregate is returned when the expression defining an aggregate is malformed.
+	ErrInvalidAggregate = errors.New("invalid aggregate")
+)
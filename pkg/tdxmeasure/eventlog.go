@@ -0,0 +1,253 @@
+package tdxmeasure
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+const (
+	evNoAction       = 0x00000003
+	tpmAlgSha384     = 0x000c
+	specIDSignature  = "Spec ID Event03\x00"
+	ccelEventLogEnd  = 0xffffffff
+	ccelMaxDigestLen = 64
+)
+
+// ParseCcelEventLog parses a TCG2 crypto-agile event log as exposed by the ACPI CCEL table (e.g.
+// /sys/firmware/acpi/tables/data/CCEL) and returns the events extended into the RTMRs.
+//
+// EV_NO_ACTION events are skipped as they are not extended into any register. The returned events
+// carry the SHA384 digest and the register value after each extend, like the predicted log.
+func ParseCcelEventLog(data []byte) ([]Event, error) {
+	r := &eventLogReader{data: data}
+
+	// The first event is in the legacy TCG_PCClientPCREvent format and contains the
+	// TCG_EfiSpecIDEvent describing the digest algorithms used in the rest of the log:
+	//
+	//   4 byte PCR index
+	//   4 byte event type (EV_NO_ACTION)
+	//   20 byte SHA1 digest
+	//   4 byte event size
+	//   event data
+	//
+	r.uint32()
+	if eventType := r.uint32(); eventType != evNoAction {
+		return nil, fmt.Errorf("%w: first event has type 0x%x", ErrInvalidEventLog, eventType)
+	}
+	r.bytes(20)
+	specID := r.bytes(int(r.uint32()))
+	if r.err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidEventLog, r.err)
+	}
+	digestSizes, err := parseSpecIDEvent(specID)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := digestSizes[tpmAlgSha384]; !ok {
+		return nil, fmt.Errorf("%w: event log has no SHA384 digests", ErrInvalidEventLog)
+	}
+
+	// The remaining events are TCG_PCR_EVENT2 structures:
+	//
+	//   4 byte MR index (0 = MRTD, 1 = RTMR0, ...)
+	//   4 byte event type
+	//   4 byte digest count
+	//   for each digest: 2 byte algorithm ID followed by the digest
+	//   4 byte event size
+	//   event data
+	//
+	logs := make([][]Event, 4)
+	for r.remaining() >= 8 {
+		mrIndex := r.uint32()
+		eventType := EventType(r.uint32())
+		if mrIndex == ccelEventLogEnd || mrIndex == 0 && eventType == 0 {
+			// The rest of the log area is unused.
+			break
+		}
+		digestCount := r.uint32()
+
+		var digest []byte
+		for range digestCount {
+			algID := r.uint16()
+			size, ok := digestSizes[algID]
+			if !ok {
+				return nil, fmt.Errorf("%w: unknown digest algorithm 0x%x at offset %d", ErrInvalidEventLog, algID, r.offset)
+			}
+			d := r.bytes(size)
+			if algID == tpmAlgSha384 {
+				digest = d
+			}
+		}
+		eventData := r.bytes(int(r.uint32()))
+		if r.err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidEventLog, r.err)
+		}
+
+		if eventType == evNoAction {
+			continue
+		}
+		if mrIndex < 1 || mrIndex > 4 {
+			return nil, fmt.Errorf("%w: unexpected MR index %d", ErrInvalidEventLog, mrIndex)
+		}
+		if digest == nil {
+			return nil, fmt.Errorf("%w: event without SHA384 digest at offset %d", ErrInvalidEventLog, r.offset)
+		}
+
+		rtmr := int(mrIndex - 1)
+		logs[rtmr] = append(logs[rtmr], newEvent(rtmr, eventType, describeEventData(eventType, eventData), digest))
+	}
+
+	var events []Event
+	for _, log := range logs {
+		measureLog(log)
+		events = append(events, log...)
+	}
+	return events, nil
+}
+
+// parseSpecIDEvent parses the TCG_EfiSpecIDEvent and returns the digest sizes by algorithm ID.
+func parseSpecIDEvent(data []byte) (map[uint16]int, error) {
+	// The structure is:
+	//
+	//   16 byte signature ("Spec ID Event03")
+	//   4 byte platform class
+	//   1 byte minor version, 1 byte major version, 1 byte errata, 1 byte uintn size
+	//   4 byte number of algorithms
+	//   for each algorithm: 2 byte algorithm ID, 2 byte digest size
+	//   1 byte vendor info size followed by vendor info
+	//
+	r := &eventLogReader{data: data}
+	if string(r.bytes(len(specIDSignature))) != specIDSignature {
+		return nil, fmt.Errorf("%w: bad spec ID event signature", ErrInvalidEventLog)
+	}
+	r.bytes(8)
+	numAlgs := r.uint32()
+	digestSizes := make(map[uint16]int)
+	for range numAlgs {
+		algID := r.uint16()
+		size := int(r.uint16())
+		if size > ccelMaxDigestLen {
+			return nil, fmt.Errorf("%w: digest size %d too large", ErrInvalidEventLog, size)
+		}
+		digestSizes[algID] = size
+	}
+	if r.err != nil {
+		return nil, fmt.Errorf("%w: spec ID event: %w", ErrInvalidEventLog, r.err)
+	}
+	return digestSizes, nil
+}
+
+// describeEventData derives a human-readable description from the event data.
+func describeEventData(eventType EventType, data []byte) string {
+	switch eventType {
+	case EvEfiVariableDriverConfig, EvEfiVariableBoot, EvEfiVariableAuthority:
+		// UEFI_VARIABLE_DATA: 16 byte GUID, 8 byte name length, 8 byte data length, name.
+		if len(data) < 32 {
+			break
+		}
+		nameLen := binary.LittleEndian.Uint64(data[16:24])
+		if nameLen > uint64(len(data)-32)/2 {
+			break
+		}
+		return decodeUTF16(data[32 : 32+2*nameLen])
+	case EvSeparator:
+		return "Separator"
+	}
+
+	// Most other events used by TDVF carry a (possibly NUL-terminated) ASCII string.
+	text := string(bytes.TrimRight(data, "\x00"))
+	for _, c := range text {
+		if c < 0x20 || c > 0x7e {
+			return ""
+		}
+	}
+	return strings.TrimSpace(text)
+}
+
+// eventLogReader reads little-endian fields from an event log, remembering the first error.
+type eventLogReader struct {
+	data   []byte
+	offset int
+	err    error
+}
+
+func (r *eventLogReader) remaining() int {
+	return len(r.data) - r.offset
+}
+
+func (r *eventLogReader) bytes(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || n > r.remaining() {
+		r.err = fmt.Errorf("truncated at offset %d (need %d bytes, have %d)", r.offset, n, r.remaining())
+		return nil
+	}
+	b := r.data[r.offset : r.offset+n]
+	r.offset += n
+	return b
+}
+
+func (r *eventLogReader) uint16() uint16 {
+	b := r.bytes(2)
+	if b == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint16(b)
+}
+
+func (r *eventLogReader) uint32() uint32 {
+	b := r.bytes(4)
+	if b == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint32(b)
+}
+
+// EventLogComparison is the result of aligning the predicted events of an RTMR with the events
+// found in an actual event log.
+type EventLogComparison struct {
+	RTMR      int
+	Predicted []Event
+	Actual    []Event
+	// FirstDivergence is the index of the first event whose digest differs or which is missing
+	// from either log, or -1 if both logs are identical.
+	FirstDivergence int
+}
+
+// CompareEventLogs aligns the predicted events with the actual events per RTMR.
+func CompareEventLogs(predicted, actual []Event) []EventLogComparison {
+	var result []EventLogComparison
+	for rtmr := range 4 {
+		c := EventLogComparison{
+			RTMR:            rtmr,
+			Predicted:       eventsOf(predicted, rtmr),
+			Actual:          eventsOf(actual, rtmr),
+			FirstDivergence: -1,
+		}
+		if len(c.Predicted) == 0 && len(c.Actual) == 0 {
+			continue
+		}
+		for i := range max(len(c.Predicted), len(c.Actual)) {
+			if i >= len(c.Predicted) || i >= len(c.Actual) || c.Predicted[i].Digest != c.Actual[i].Digest {
+				c.FirstDivergence = i
+				break
+			}
+		}
+		result = append(result, c)
+	}
+	return result
+}
+
+// eventsOf returns the events of the given RTMR.
+func eventsOf(log []Event, rtmr int) []Event {
+	var events []Event
+	for _, ev := range log {
+		if ev.RTMR == rtmr {
+			events = append(events, ev)
+		}
+	}
+	return events
+}
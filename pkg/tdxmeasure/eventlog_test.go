@@ -0,0 +1,167 @@
+package tdxmeasure
+
+import (
+	"bytes"
+	"crypto/sha512"
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/text/encoding/unicode"
+)
+
+const tpmAlgSha1 = 0x0004
+
+// testCcelEvent is an event of a test CCEL log.
+type testCcelEvent struct {
+	mrIndex   uint32
+	eventType EventType
+	data      []byte
+}
+
+// testSpecIDEvent returns a TCG_EfiSpecIDEvent with the given algorithms and digest sizes.
+func testSpecIDEvent(algs ...uint16) []byte {
+	data := append([]byte(specIDSignature), make([]byte, 8)...)
+	data = binary.LittleEndian.AppendUint32(data, uint32(len(algs)/2))
+	for _, v := range algs {
+		data = binary.LittleEndian.AppendUint16(data, v)
+	}
+	return append(data, 0)
+}
+
+// testCcelLog encodes events with SHA1 and SHA384 digests of their data, followed by the unused
+// part of the log area.
+func testCcelLog(specID []byte, events ...testCcelEvent) []byte {
+	log := binary.LittleEndian.AppendUint32(nil, 0)
+	log = binary.LittleEndian.AppendUint32(log, evNoAction)
+	log = append(log, make([]byte, 20)...)
+	log = binary.LittleEndian.AppendUint32(log, uint32(len(specID)))
+	log = append(log, specID...)
+	for _, e := range events {
+		log = binary.LittleEndian.AppendUint32(log, e.mrIndex)
+		log = binary.LittleEndian.AppendUint32(log, uint32(e.eventType))
+		log = binary.LittleEndian.AppendUint32(log, 2)
+		log = binary.LittleEndian.AppendUint16(log, tpmAlgSha1)
+		log = append(log, bytes.Repeat([]byte{0x11}, 20)...)
+		log = binary.LittleEndian.AppendUint16(log, tpmAlgSha384)
+		digest := sha512.Sum384(e.data)
+		log = append(log, digest[:]...)
+		log = binary.LittleEndian.AppendUint32(log, uint32(len(e.data)))
+		log = append(log, e.data...)
+	}
+	return append(log, bytes.Repeat([]byte{0xff}, 64)...)
+}
+
+// testVariableData returns the UEFI_VARIABLE_DATA of a variable.
+func testVariableData(name string) []byte {
+	encodedName, _ := unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM).NewEncoder().Bytes([]byte(name))
+	data := make([]byte, 16)
+	data = binary.LittleEndian.AppendUint64(data, uint64(len(name)))
+	data = binary.LittleEndian.AppendUint64(data, 0)
+	return append(data, encodedName...)
+}
+
+func TestParseCcelEventLog(t *testing.T) {
+	specID := testSpecIDEvent(tpmAlgSha1, 20, tpmAlgSha384, 48)
+	events := []testCcelEvent{
+		{1, EvEfiPlatformFirmwareBlob2, []byte("\x09TDXTABLE")},
+		{1, EvEfiVariableDriverConfig, testVariableData("SecureBoot")},
+		{2, EvEfiBootServicesApplication, []byte{0x01, 0x80, 0xff}},
+		{1, EvSeparator, []byte{0, 0, 0, 0}},
+		{0, evNoAction, []byte("ignored")},
+		{3, EvEventTag, []byte("LOADED_IMAGE::LoadOptions\x00")},
+		{2, EvEfiAction, []byte("Exit Boot Services Invocation")},
+	}
+	parsed, err := ParseCcelEventLog(testCcelLog(specID, events...))
+	require.NoError(t, err)
+
+	// The events are grouped by RTMR, in the order they were extended.
+	want := []Event{
+		newEvent(0, EvEfiPlatformFirmwareBlob2, "", sha384Of(events[0].data)),
+		newEvent(0, EvEfiVariableDriverConfig, "SecureBoot", sha384Of(events[1].data)),
+		newEvent(0, EvSeparator, "Separator", sha384Of(events[3].data)),
+		newEvent(1, EvEfiBootServicesApplication, "", sha384Of(events[2].data)),
+		newEvent(1, EvEfiAction, "Exit Boot Services Invocation", sha384Of(events[6].data)),
+		newEvent(2, EvEventTag, "LOADED_IMAGE::LoadOptions", sha384Of(events[5].data)),
+	}
+	measureLog(want[0:3])
+	measureLog(want[3:5])
+	measureLog(want[5:6])
+	require.Equal(t, want, parsed)
+	require.Equal(t, 2, parsed[2].Index)
+	require.Equal(t, 0, parsed[3].Index)
+}
+
+func TestParseCcelEventLogEnd(t *testing.T) {
+	specID := testSpecIDEvent(tpmAlgSha1, 20, tpmAlgSha384, 48)
+	log := testCcelLog(specID, testCcelEvent{1, EvSeparator, []byte{0, 0, 0, 0}})
+	// A zeroed log area also ends the log.
+	log = append(log[:len(log)-64], make([]byte, 64)...)
+	events, err := ParseCcelEventLog(log)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+
+	// So does the end of the data.
+	events, err = ParseCcelEventLog(log[:len(log)-64])
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+}
+
+func TestParseCcelEventLogErrors(t *testing.T) {
+	specID := testSpecIDEvent(tpmAlgSha1, 20, tpmAlgSha384, 48)
+	valid := testCcelLog(specID, testCcelEvent{1, EvSeparator, []byte{0, 0, 0, 0}})
+	firstType := bytes.Clone(valid)
+	binary.LittleEndian.PutUint32(firstType[4:], uint32(EvSeparator))
+	badSignature := bytes.Clone(specID)
+	badSignature[0] = 'X'
+	unknownAlg := bytes.Clone(valid)
+	binary.LittleEndian.PutUint16(unknownAlg[32+len(specID)+12:], 0x0b)
+
+	for name, log := range map[string][]byte{
+		"empty":                 {},
+		"first event type":      firstType,
+		"bad signature":         testCcelLog(badSignature),
+		"truncated spec ID":     testCcelLog(specID[:len(specID)-4]),
+		"digest size too large": testCcelLog(testSpecIDEvent(tpmAlgSha384, 48, 0x0d, 65)),
+		"no SHA384":             testCcelLog(testSpecIDEvent(tpmAlgSha1, 20)),
+		"unknown algorithm":     unknownAlg,
+		"truncated event":       valid[:len(valid)-64-2],
+		"MR index":              testCcelLog(specID, testCcelEvent{5, EvSeparator, []byte{0, 0, 0, 0}}),
+		"MRTD event":            testCcelLog(specID, testCcelEvent{0, EvSeparator, []byte{0, 0, 0, 0}}),
+	} {
+		_, err := ParseCcelEventLog(log)
+		require.ErrorIs(t, err, ErrInvalidEventLog, name)
+	}
+}
+
+func TestCompareEventLogs(t *testing.T) {
+	event := func(rtmr int, description string) Event {
+		return newEvent(rtmr, EvEventTag, description, sha384Of([]byte(description)))
+	}
+	predicted := []Event{event(0, "a"), event(0, "b"), event(1, "c"), event(2, "d"), event(2, "e")}
+	actual := []Event{event(0, "a"), event(0, "b"), event(1, "x"), event(2, "d"), event(3, "f")}
+
+	comparisons := CompareEventLogs(predicted, actual)
+	require.Len(t, comparisons, 4)
+	for i, want := range []struct {
+		rtmr, predicted, actual, firstDivergence int
+	}{
+		{0, 2, 2, -1},
+		{1, 1, 1, 0},
+		{2, 2, 1, 1},
+		{3, 0, 1, 0},
+	} {
+		c := comparisons[i]
+		require.Equal(t, want.rtmr, c.RTMR)
+		require.Len(t, c.Predicted, want.predicted)
+		require.Len(t, c.Actual, want.actual)
+		require.Equal(t, want.firstDivergence, c.FirstDivergence, "RTMR%d", c.RTMR)
+	}
+
+	require.Empty(t, CompareEventLogs(nil, nil))
+}
+
+func sha384Of(data []byte) []byte {
+	digest := sha512.Sum384(data)
+	return digest[:]
+}
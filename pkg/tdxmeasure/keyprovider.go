@@ -0,0 +1,62 @@
+package tdxmeasure
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// KeyProviderSize is the size of the key provider measurement mixed into mr_enclave.
+const KeyProviderSize = sha256.Size
+
+// ParseKeyProvider decodes a hex encoded key provider measurement, with an optional 0x prefix.
+func ParseKeyProvider(s string) ([]byte, error) {
+	mrKeyProvider, err := hex.DecodeString(strings.TrimPrefix(s, "0x"))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidKeyProvider, err)
+	}
+	if len(mrKeyProvider) != KeyProviderSize {
+		return nil, fmt.Errorf("%w: measurement is %d bytes long, expected %d", ErrInvalidKeyProvider, len(mrKeyProvider), KeyProviderSize)
+	}
+	return mrKeyProvider, nil
+}
+
+// KeyProviderInfo describes the key provider of a dstack app, e.g. {"name": "kms", "id": ...}
+// for a KMS identified by its root public key or {"name": "local-sgx", "id": ...} for a local SGX
+// key provider identified by its enclave measurement.
+type KeyProviderInfo struct {
+	Name string `json:"name"`
+	// ID is the hex encoded identity of the key provider.
+	ID string `json:"id"`
+}
+
+// ParseKeyProviderInfo parses and validates a JSON key provider descriptor.
+func ParseKeyProviderInfo(data []byte) (*KeyProviderInfo, error) {
+	var info KeyProviderInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidKeyProvider, err)
+	}
+	if info.Name == "" {
+		return nil, fmt.Errorf("%w: missing name", ErrInvalidKeyProvider)
+	}
+	if _, err := hex.DecodeString(info.ID); err != nil || info.ID == "" {
+		return nil, fmt.Errorf("%w: id %q is not a hex encoded identity", ErrInvalidKeyProvider, info.ID)
+	}
+	return &info, nil
+}
+
+// Payload returns the encoding of the descriptor the dstack guest extends into RTMR3 as the
+// "key-provider" event.
+func (k *KeyProviderInfo) Payload() []byte {
+	payload, _ := json.Marshal(k)
+	return payload
+}
+
+// Measurement returns the key provider measurement of the descriptor, the SHA-256 hash of its
+// payload.
+func (k *KeyProviderInfo) Measurement() []byte {
+	sum := sha256.Sum256(k.Payload())
+	return sum[:]
+}
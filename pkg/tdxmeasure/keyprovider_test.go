@@ -0,0 +1,74 @@
+package tdxmeasure
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseKeyProvider(t *testing.T) {
+	zero := hex.EncodeToString(make([]byte, KeyProviderSize))
+	tests := []struct {
+		input   string
+		want    []byte
+		wantErr bool
+	}{
+		{input: zero, want: make([]byte, KeyProviderSize)},
+		{input: "0x" + zero, want: make([]byte, KeyProviderSize)},
+		{input: "0x" + hex.EncodeToString(bytes.Repeat([]byte{0xab}, 32)), want: bytes.Repeat([]byte{0xab}, 32)},
+		{input: "AB" + zero[2:], want: append([]byte{0xab}, make([]byte, 31)...)},
+		{input: "", wantErr: true},
+		{input: "00", wantErr: true},
+		{input: zero + "00", wantErr: true},
+		{input: zero[1:], wantErr: true},
+		{input: "zz" + zero[2:], wantErr: true},
+		{input: "0X" + zero, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseKeyProvider(tt.input)
+		if tt.wantErr {
+			require.ErrorIs(t, err, ErrInvalidKeyProvider, "%q", tt.input)
+			continue
+		}
+		require.NoError(t, err, "%q", tt.input)
+		require.Equal(t, tt.want, got)
+	}
+}
+
+func TestParseKeyProviderInfo(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    *KeyProviderInfo
+		wantErr bool
+	}{
+		{input: `{"name": "kms", "id": "02ab"}`, want: &KeyProviderInfo{Name: "kms", ID: "02ab"}},
+		{input: `{"id": "CAFE", "name": "local-sgx", "extra": 1}`, want: &KeyProviderInfo{Name: "local-sgx", ID: "CAFE"}},
+		{input: `{"name": "kms"}`, wantErr: true},
+		{input: `{"name": "kms", "id": ""}`, wantErr: true},
+		{input: `{"name": "kms", "id": "0x02ab"}`, wantErr: true},
+		{input: `{"name": "kms", "id": "abc"}`, wantErr: true},
+		{input: `{"id": "02ab"}`, wantErr: true},
+		{input: `{"name": 1, "id": "02ab"}`, wantErr: true},
+		{input: `[]`, wantErr: true},
+		{input: ``, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseKeyProviderInfo([]byte(tt.input))
+		if tt.wantErr {
+			require.ErrorIs(t, err, ErrInvalidKeyProvider, "%q", tt.input)
+			continue
+		}
+		require.NoError(t, err, "%q", tt.input)
+		require.Equal(t, tt.want, got)
+	}
+}
+
+func TestKeyProviderInfoMeasurement(t *testing.T) {
+	info := &KeyProviderInfo{Name: "kms", ID: "02ab"}
+	require.Equal(t, `{"name":"kms","id":"02ab"}`, string(info.Payload()))
+	require.Equal(t, "34614344ed5a75da9eaf08ceb52ff7ffc61d444da339f62c1d0606ca310aab45", hex.EncodeToString(info.Measurement()))
+	_, err := ParseKeyProvider(hex.EncodeToString(info.Measurement()))
+	require.NoError(t, err)
+}
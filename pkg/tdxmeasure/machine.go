@@ -0,0 +1,67 @@
+package tdxmeasure
+
+import (
+	"fmt"
+	"math/bits"
+)
+
+// qemuMachine is the configuration of the QEMU q35 machine reflected in the ACPI tables.
+type qemuMachine struct {
+	mem      *memoryMap
+	apicIDs  []uint32 // APIC ID of each CPU.
+	cpuNodes []uint32 // NUMA node of each CPU, nil without NUMA.
+	devices  *DeviceConfig
+	numa     *NUMAConfig
+}
+
+// newQemuMachine validates the machine configuration of the options.
+func newQemuMachine(opts *Options) (*qemuMachine, error) {
+	if err := checkCPUCount(opts.CPUCount); err != nil {
+		return nil, err
+	}
+	sockets := max(opts.Sockets, 1)
+	if opts.CPUCount%sockets != 0 {
+		return nil, fmt.Errorf("%w: %d CPUs can't be split into %d sockets", ErrUnsupportedConfig, opts.CPUCount, sockets)
+	}
+
+	mem, err := newMemoryMap(opts)
+	if err != nil {
+		return nil, err
+	}
+	m := &qemuMachine{
+		mem:     mem,
+		devices: opts.Devices,
+		numa:    opts.NUMA,
+	}
+
+	// QEMU builds the APIC ID from the socket and core index, each core has a single thread.
+	coresPerSocket := opts.CPUCount / sockets
+	coreBits := 0
+	if coresPerSocket > 1 {
+		coreBits = bits.Len32(coresPerSocket - 1)
+	}
+	socketIDs := make([]uint32, opts.CPUCount)
+	for i := range socketIDs {
+		socketIDs[i] = uint32(i) / coresPerSocket
+		m.apicIDs = append(m.apicIDs, socketIDs[i]<<coreBits|uint32(i)%coresPerSocket)
+	}
+
+	if m.devices == nil {
+		m.devices = DefaultDeviceConfig()
+	}
+	if err := m.devices.check(); err != nil {
+		return nil, err
+	}
+	if m.numa != nil {
+		if m.cpuNodes, err = m.numa.cpuNodes(opts.MemorySize, socketIDs); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// x2APICMode reports whether APIC IDs that don't fit into the xAPIC ID field are in use, in which
+// case QEMU describes the NMI of all CPUs with an x2APIC structure.
+func (m *qemuMachine) x2APICMode() bool {
+	return m.apicIDs[len(m.apicIDs)-1] > 254
+}
@@ -0,0 +1,306 @@
+package tdxmeasure
+
+import (
+	"bufio"
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"math/big"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/foxboron/go-uefi/authenticode"
+	"github.com/foxboron/go-uefi/efi/signature"
+	"github.com/foxboron/go-uefi/efi/util"
+	"github.com/stretchr/testify/require"
+)
+
+// testKernel builds a 12 KiB x86-64 PE image with a single .text section and a Linux setup
+// header, as an EFI stub kernel has.
+func testKernel() []byte {
+	k := make([]byte, 0x3000)
+	testFill(k, 3)
+	clear(k[:0x300])
+
+	// DOS header pointing to the PE header.
+	copy(k, "MZ")
+	binary.LittleEndian.PutUint32(k[0x3c:], 0x40)
+	copy(k[0x40:], "PE\x00\x00")
+
+	// COFF file header: machine, section count, optional header size and characteristics.
+	fh := k[0x44:]
+	binary.LittleEndian.PutUint16(fh[0:], 0x8664)
+	binary.LittleEndian.PutUint16(fh[2:], 1)
+	binary.LittleEndian.PutUint16(fh[16:], 240)
+	binary.LittleEndian.PutUint16(fh[18:], 0x0206)
+
+	// PE32+ optional header.
+	oh := k[0x58:]
+	binary.LittleEndian.PutUint16(oh[0:], 0x20b)
+	binary.LittleEndian.PutUint32(oh[32:], 0x1000) // Section alignment
+	binary.LittleEndian.PutUint32(oh[36:], 0x200)  // File alignment
+	binary.LittleEndian.PutUint32(oh[56:], 0x3000) // Size of image
+	binary.LittleEndian.PutUint32(oh[60:], 0x1000) // Size of headers
+	binary.LittleEndian.PutUint16(oh[68:], 10)     // EFI application
+	binary.LittleEndian.PutUint32(oh[108:], 16)    // Number of data directories
+
+	st := k[0x58+240:]
+	copy(st, ".text")
+	binary.LittleEndian.PutUint32(st[8:], 0x1800)
+	binary.LittleEndian.PutUint32(st[12:], 0x1000)
+	binary.LittleEndian.PutUint32(st[16:], 0x1800)
+	binary.LittleEndian.PutUint32(st[20:], 0x1000)
+
+	// Linux setup header: boot flag, "HdrS" signature, protocol 2.15, loaded high, and the
+	// supported initrd address.
+	k[0x1fe] = 0x55
+	k[0x1ff] = 0xaa
+	copy(k[0x202:], "HdrS")
+	binary.LittleEndian.PutUint16(k[0x206:], 0x20f)
+	k[0x211] = 0x01
+	binary.LittleEndian.PutUint16(k[0x236:], 0x7f)
+	return k
+}
+
+// testMeasurementCase is a line of testdata/measurements.txt.
+type testMeasurementCase struct {
+	memory     uint64
+	cpu        uint32
+	variant    MRTDVariant
+	initrdSize int
+	mrtd       string
+	rtmr0      string
+	rtmr1      string
+	rtmr2      string
+	mrImage    string
+	cmdline    string
+}
+
+func (c *testMeasurementCase) options() Options {
+	initrd := make([]byte, c.initrdSize)
+	testFill(initrd, 9)
+	return Options{
+		Firmware:      testFirmware(1, testTdvfSections),
+		Kernel:        testKernel(),
+		Initrd:        initrd,
+		MemorySize:    c.memory << 20,
+		CPUCount:      c.cpu,
+		KernelCmdline: c.cmdline,
+		MRTDVariant:   c.variant,
+	}
+}
+
+func readTestMeasurements(t *testing.T) []testMeasurementCase {
+	f, err := os.Open("testdata/measurements.txt")
+	require.NoError(t, err)
+	defer f.Close()
+
+	var cases []testMeasurementCase
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.SplitN(line, " ", 10)
+		require.GreaterOrEqual(t, len(fields), 9, line)
+		var c testMeasurementCase
+		c.memory, err = strconv.ParseUint(fields[0], 10, 64)
+		require.NoError(t, err, line)
+		cpu, err := strconv.ParseUint(fields[1], 10, 32)
+		require.NoError(t, err, line)
+		c.cpu = uint32(cpu)
+		c.variant, err = ParseMRTDVariant(fields[2])
+		require.NoError(t, err, line)
+		c.initrdSize, err = strconv.Atoi(fields[3])
+		require.NoError(t, err, line)
+		c.mrtd, c.rtmr0, c.rtmr1, c.rtmr2, c.mrImage = fields[4], fields[5], fields[6], fields[7], fields[8]
+		if len(fields) == 10 {
+			c.cmdline = fields[9]
+		}
+		cases = append(cases, c)
+	}
+	require.NoError(t, scanner.Err())
+	return cases
+}
+
+// TestMeasureGolden checks the measurements of the test images against the values in
+// testdata/measurements.txt.
+func TestMeasureGolden(t *testing.T) {
+	cases := readTestMeasurements(t)
+	require.NotEmpty(t, cases)
+	for _, c := range cases {
+		m, err := Measure(c.options())
+		require.NoError(t, err)
+		require.Equal(t, c.variant, m.MRTDVariant)
+		require.Equal(t, c.mrtd, m.MRTD.String(), "MRTD, %+v", c)
+		require.Equal(t, c.rtmr0, m.RTMR0.String(), "RTMR0, %+v", c)
+		require.Equal(t, c.rtmr1, m.RTMR1.String(), "RTMR1, %+v", c)
+		require.Equal(t, c.rtmr2, m.RTMR2.String(), "RTMR2, %+v", c)
+		require.Equal(t, Register{}, m.RTMR3)
+		if c.mrImage != "-" {
+			require.Equal(t, c.mrImage, m.CalculateMrImage(), "mr_image, %+v", c)
+		}
+
+		mrtd, err := ComputeMRTD(testFirmware(1, testTdvfSections), c.variant)
+		require.NoError(t, err)
+		require.Equal(t, m.MRTD, mrtd)
+
+		// The event log replays to the registers.
+		registers := []Register{m.RTMR0, m.RTMR1, m.RTMR2}
+		for rtmr, want := range registers {
+			var last Register
+			for _, e := range m.EventLog {
+				if e.RTMR == rtmr {
+					last = e.RTMRValue
+				}
+			}
+			require.Equal(t, want, last, "RTMR%d", rtmr)
+		}
+	}
+}
+
+// TestMeasureInputs checks that the measurements don't depend on how the inputs are given.
+func TestMeasureInputs(t *testing.T) {
+	c := readTestMeasurements(t)[0]
+	opts := c.options()
+	want, err := Measure(opts)
+	require.NoError(t, err)
+
+	t.Run("readers", func(t *testing.T) {
+		streamed := opts
+		streamed.Kernel, streamed.Initrd = nil, nil
+		streamed.KernelReader, streamed.KernelSize = bytes.NewReader(opts.Kernel), int64(len(opts.Kernel))
+		streamed.InitrdReader, streamed.InitrdSize = bytes.NewReader(opts.Initrd), int64(len(opts.Initrd))
+		m, err := Measure(streamed)
+		require.NoError(t, err)
+		require.Equal(t, want, m)
+	})
+
+	t.Run("measurer", func(t *testing.T) {
+		measurer, err := NewMeasurer(opts)
+		require.NoError(t, err)
+		for range 2 {
+			m, err := measurer.Measure(opts.MemorySize, opts.CPUCount)
+			require.NoError(t, err)
+			require.Equal(t, want, m)
+		}
+	})
+
+	t.Run("ACPI cache", func(t *testing.T) {
+		cache, err := NewACPICache(t.TempDir())
+		require.NoError(t, err)
+		cached := opts
+		cached.ACPICache = cache
+		for range 2 {
+			m, err := Measure(cached)
+			require.NoError(t, err)
+			require.Equal(t, want, m)
+		}
+	})
+
+	t.Run("runtime events", func(t *testing.T) {
+		events := []RuntimeEvent{{Name: "app-id", Payload: []byte{1, 2}}, {Name: "compose-hash"}}
+		withEvents := opts
+		withEvents.RuntimeEvents = events
+		m, err := Measure(withEvents)
+		require.NoError(t, err)
+		require.Equal(t, MeasureRuntimeEvents(events), m.RTMR3)
+		require.Equal(t, []Register{want.MRTD, want.RTMR0, want.RTMR1, want.RTMR2}, []Register{m.MRTD, m.RTMR0, m.RTMR1, m.RTMR2})
+		require.Len(t, m.EventLog, len(want.EventLog)+len(events))
+	})
+}
+
+func TestMeasureErrors(t *testing.T) {
+	opts := readTestMeasurements(t)[0].options()
+	for name, modify := range map[string]func(*Options){
+		"no CPU":         func(o *Options) { o.CPUCount = 0 },
+		"no firmware":    func(o *Options) { o.Firmware = nil },
+		"MRTD variant":   func(o *Options) { o.MRTDVariant = 2 },
+		"not a PE image": func(o *Options) { o.Kernel = bytes.Repeat([]byte{1}, 0x3000) },
+	} {
+		modified := opts
+		modify(&modified)
+		_, err := Measure(modified)
+		require.Error(t, err, name)
+	}
+}
+
+// testSignedKernel signs the test kernel with a new key and returns it with the certificate.
+func testSignedKernel(t *testing.T) ([]byte, []byte) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "test db"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	img, err := authenticode.Parse(bytes.NewReader(testKernel()))
+	require.NoError(t, err)
+	_, err = img.Sign(key, cert)
+	require.NoError(t, err)
+	return img.Bytes(), der
+}
+
+// TestPEImageHash checks the Authenticode hashes of the streamed PE parser against go-uefi's.
+func TestPEImageHash(t *testing.T) {
+	signed, _ := testSignedKernel(t)
+	for name, kernel := range map[string][]byte{
+		"unsigned":      testKernel(),
+		"trailing data": append(testKernel(), 1, 2, 3),
+		"signed":        signed,
+	} {
+		ref, err := authenticode.Parse(bytes.NewReader(kernel))
+		require.NoError(t, err, name)
+		img, err := parsePEImage(bytes.NewReader(kernel), int64(len(kernel)))
+		require.NoError(t, err, name)
+		for _, alg := range []crypto.Hash{crypto.SHA256, crypto.SHA384} {
+			hash, err := img.hash(alg)
+			require.NoError(t, err, name)
+			require.Equal(t, ref.Hash(alg), hash, "%s, %s", name, alg)
+		}
+	}
+}
+
+func TestMeasureSecureBoot(t *testing.T) {
+	kernel, der := testSignedKernel(t)
+	db := signature.NewSignatureList(signature.CERT_X509_GUID)
+	db.AppendBytes(*util.StringToGUID("11111111-2222-3333-4444-555555555555"), der)
+	opts := readTestMeasurements(t)[0].options()
+	opts.Kernel = kernel
+
+	insecure, err := Measure(opts)
+	require.NoError(t, err)
+
+	opts.SecureBoot = &SecureBootConfig{PK: db.Bytes(), KEK: db.Bytes(), DB: db.Bytes()}
+	m, err := Measure(opts)
+	require.NoError(t, err)
+	require.NotEqual(t, insecure.RTMR0, m.RTMR0)
+	require.Equal(t, insecure.RTMR1, m.RTMR1)
+	require.Equal(t, insecure.RTMR2, m.RTMR2)
+	authority := 0
+	for _, e := range m.EventLog {
+		if e.EventType == EvEfiVariableAuthority {
+			authority++
+		}
+	}
+	require.Equal(t, 1, authority)
+
+	// The unsigned kernel isn't authorized by db.
+	opts.Kernel = testKernel()
+	_, err = Measure(opts)
+	require.ErrorIs(t, err, ErrKernelNotAuthorized)
+}
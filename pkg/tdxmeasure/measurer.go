@@ -0,0 +1,151 @@
+package tdxmeasure
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"sync"
+)
+
+// Measurer calculates the measurements of the same firmware and boot inputs for different memory
+// sizes and CPU counts. The inputs that don't depend on them, MRTD, the firmware and Secure Boot
+// events, the kernel command line, the initrd and the runtime events, are measured once. The
+// kernel image is only hashed again when the memory below 4 GiB changes, as its setup header then
+// differs.
+//
+// A Measurer is safe for concurrent use if the kernel reader of its options is.
+type Measurer struct {
+	opts       Options
+	mrtd       Register
+	rtmr0      *rtmr0Measurer
+	rtmr2      Register
+	rtmr2Log   []Event
+	rtmr3      Register
+	rtmr3Log   []Event
+	initrdSize uint32
+
+	mu sync.Mutex
+	// kernelHashes are the Authenticode hashes of the patched kernel image by below-4G memory
+	// size.
+	kernelHashes map[uint64][]byte
+}
+
+// NewMeasurer measures the inputs of opts shared by all machine configurations. The initrd reader
+// is consumed. The memory size and CPU count of opts are ignored, they are given to Measure.
+func NewMeasurer(opts Options) (*Measurer, error) {
+	initrd, initrdSize := opts.initrd()
+	if initrdSize < 0 || initrdSize > math.MaxUint32 {
+		return nil, fmt.Errorf("%w (size: %d)", ErrInitrdTooLarge, initrdSize)
+	}
+	if _, ok := mrtdVariantNames[opts.MRTDVariant]; !ok {
+		return nil, fmt.Errorf("%w: unknown MRTD variant %d", ErrUnsupportedConfig, opts.MRTDVariant)
+	}
+
+	// Parse TDVF metadata.
+	tdvfMeta, err := parseTdvfMetadata(opts.Firmware)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Measurer{
+		opts:         opts,
+		initrdSize:   uint32(initrdSize),
+		kernelHashes: make(map[uint64][]byte),
+	}
+	// The initrd can only be read once, the measurer keeps its hash.
+	m.opts.Initrd, m.opts.InitrdReader = nil, nil
+
+	copy(m.mrtd[:], tdvfMeta.computeMrtd(opts.Firmware, opts.MRTDVariant))
+
+	if m.rtmr0, err = newRTMR0Measurer(&opts, tdvfMeta); err != nil {
+		return nil, err
+	}
+
+	initrdHash, err := measureSha384Reader(initrd, initrdSize)
+	if err != nil {
+		return nil, fmt.Errorf("reading initrd: %w", err)
+	}
+	m.rtmr2Log = []Event{
+		newEvent(2, EvEventTag, "Kernel command line", measureTdxKernelCmdline(opts.KernelCmdline)),
+		newEvent(2, EvEventTag, "Initrd", initrdHash),
+	}
+	m.rtmr2 = measureLog(m.rtmr2Log)
+
+	m.rtmr3Log = measureRuntimeEvents(opts.RuntimeEvents)
+	m.rtmr3 = measureLog(m.rtmr3Log)
+	return m, nil
+}
+
+// Measure calculates the measurements of the TD with the given memory size in bytes and CPU
+// count.
+func (m *Measurer) Measure(memorySize uint64, cpuCount uint32) (*Measurements, error) {
+	opts := m.opts
+	opts.MemorySize, opts.CPUCount = memorySize, cpuCount
+	if err := checkCPUCount(opts.CPUCount); err != nil {
+		return nil, err
+	}
+	mem, err := newMemoryMap(&opts)
+	if err != nil {
+		return nil, err
+	}
+
+	measurements := &Measurements{MRTDVariant: opts.MRTDVariant, MRTD: m.mrtd}
+
+	// RTMR0 calculation
+	tdHobHash, err := measureTdxQemuTdHob(mem, m.rtmr0.tdvfMeta)
+	if err != nil {
+		return nil, err
+	}
+	rtmr0Log, err := m.rtmr0.eventLog(&opts, tdHobHash)
+	if err != nil {
+		return nil, err
+	}
+	measurements.RTMR0 = measureLog(rtmr0Log)
+
+	// RTMR1 calculation
+	kernelAuthHash, err := m.kernelHash(mem)
+	if err != nil {
+		return nil, err
+	}
+	rtmr1Log := []Event{
+		newEvent(1, EvEfiBootServicesApplication, "Kernel image (Authenticode)", kernelAuthHash),
+		newEvent(1, EvEfiAction, "Calling EFI Application from Boot Option", measureSha384([]byte("Calling EFI Application from Boot Option"))),
+		newEvent(1, EvSeparator, "Separator", measureSha384(separatorEventData)),
+		newEvent(1, EvEfiAction, "Exit Boot Services Invocation", measureSha384([]byte("Exit Boot Services Invocation"))),
+		newEvent(1, EvEfiAction, "Exit Boot Services Returned with Success", measureSha384([]byte("Exit Boot Services Returned with Success"))),
+	}
+	measurements.RTMR1 = measureLog(rtmr1Log)
+
+	// RTMR2 and RTMR3 don't depend on the machine configuration.
+	measurements.RTMR2 = m.rtmr2
+	measurements.RTMR3 = m.rtmr3
+
+	measurements.EventLog = slices.Concat(rtmr0Log, rtmr1Log, m.rtmr2Log, m.rtmr3Log)
+
+	return measurements, nil
+}
+
+// kernelHash returns the Authenticode hash of the kernel image patched for the memory map.
+func (m *Measurer) kernelHash(mem *memoryMap) ([]byte, error) {
+	// The memory size only ends up in the image when an initrd is loaded below it.
+	key := uint64(0)
+	if m.initrdSize != 0 {
+		key = mem.below4GMemSize
+	}
+	m.mu.Lock()
+	hash, ok := m.kernelHashes[key]
+	m.mu.Unlock()
+	if ok {
+		return hash, nil
+	}
+
+	kernel, kernelSize := m.opts.kernel()
+	hash, err := measureTdxQemuKernelImage(kernel, kernelSize, m.initrdSize, mem, 0x28000)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	m.kernelHashes[key] = hash
+	m.mu.Unlock()
+	return hash, nil
+}
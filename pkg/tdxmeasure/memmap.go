@@ -0,0 +1,52 @@
+package tdxmeasure
+
+import "fmt"
+
+// Guest physical memory layout of the QEMU q35 machine.
+const (
+	above4GMemStart = 0x100000000
+
+	// q35LowMemLimit is the most RAM q35 maps below 4 GiB, leaving room for the 32-bit PCI hole
+	// and MMCONFIG. Guests with at least this much RAM get q35LowMemSplit bytes below 4 GiB.
+	q35LowMemLimit = 0xb0000000
+	q35LowMemSplit = 0x80000000
+
+	// ramAlignment is the alignment QEMU rounds the memory size up to.
+	ramAlignment = 8 << 10
+)
+
+// memoryMap is the split of the guest RAM around the 32-bit PCI hole, shared by the TD HOB, the
+// ACPI tables and the kernel loader.
+type memoryMap struct {
+	below4GMemSize uint64 // RAM mapped from address 0, in bytes.
+	above4GMemSize uint64 // RAM mapped from 4 GiB, in bytes.
+	pciHole64Size  uint64 // Minimum size of the 64-bit PCI hole, in bytes.
+}
+
+// newMemoryMap computes the memory map of the options the same way as QEMU's pc_q35_init.
+func newMemoryMap(opts *Options) (*memoryMap, error) {
+	if opts.MemorySize == 0 || opts.MemorySize%ramAlignment != 0 {
+		return nil, fmt.Errorf("%w: memory size %#x is not a non-zero multiple of 8 KiB", ErrUnsupportedConfig, opts.MemorySize)
+	}
+	if opts.MaxRAMBelow4G > above4GMemStart {
+		return nil, fmt.Errorf("%w: max-ram-below-4g of %#x exceeds 4 GiB", ErrUnsupportedConfig, opts.MaxRAMBelow4G)
+	}
+
+	lowmem := uint64(q35LowMemLimit)
+	if opts.MemorySize >= q35LowMemLimit {
+		lowmem = q35LowMemSplit
+	}
+	if opts.MaxRAMBelow4G != 0 {
+		lowmem = min(lowmem, opts.MaxRAMBelow4G)
+	}
+
+	m := &memoryMap{
+		below4GMemSize: min(opts.MemorySize, lowmem),
+		pciHole64Size:  qemuPCIHole64Size,
+	}
+	m.above4GMemSize = opts.MemorySize - m.below4GMemSize
+	if opts.PCIHole64Size != 0 {
+		m.pciHole64Size = opts.PCIHole64Size
+	}
+	return m, nil
+}
@@ -0,0 +1,92 @@
+package tdxmeasure
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewMemoryMap(t *testing.T) {
+	const (
+		MiB = 1 << 20
+		GiB = 1 << 30
+	)
+	tests := []struct {
+		name                  string
+		opts                  Options
+		below4G, above4G, pci uint64
+	}{
+		{"small", Options{MemorySize: 512 * MiB}, 512 * MiB, 0, 32 * GiB},
+		{"8 KiB granular", Options{MemorySize: 512*MiB + 8<<10}, 512*MiB + 8<<10, 0, 32 * GiB},
+		{"2 GiB", Options{MemorySize: 2 * GiB}, 2 * GiB, 0, 32 * GiB},
+		// Below 2816 MiB (0xb0000000) all of the RAM fits below 4 GiB, from there on it is split
+		// at 2 GiB.
+		{"below the limit", Options{MemorySize: 2815 * MiB}, 2815 * MiB, 0, 32 * GiB},
+		{"just below the limit", Options{MemorySize: 2816*MiB - 8<<10}, 2816*MiB - 8<<10, 0, 32 * GiB},
+		{"at the limit", Options{MemorySize: 2816 * MiB}, 2 * GiB, 768 * MiB, 32 * GiB},
+		{"4 GiB", Options{MemorySize: 4 * GiB}, 2 * GiB, 2 * GiB, 32 * GiB},
+		{"64 GiB", Options{MemorySize: 64 * GiB}, 2 * GiB, 62 * GiB, 32 * GiB},
+		// max-ram-below-4g only ever lowers the split.
+		{"max-ram-below-4g", Options{MemorySize: 2 * GiB, MaxRAMBelow4G: 1 * GiB}, 1 * GiB, 1 * GiB, 32 * GiB},
+		{"max-ram-below-4g above the limit", Options{MemorySize: 2560 * MiB, MaxRAMBelow4G: 3 * GiB}, 2560 * MiB, 0, 32 * GiB},
+		{"max-ram-below-4g above the split", Options{MemorySize: 3 * GiB, MaxRAMBelow4G: 3 * GiB}, 2 * GiB, 1 * GiB, 32 * GiB},
+		{"max-ram-below-4g of 4 GiB", Options{MemorySize: 4 * GiB, MaxRAMBelow4G: 4 * GiB}, 2 * GiB, 2 * GiB, 32 * GiB},
+		{"max-ram-below-4g not at a GiB", Options{MemorySize: 4 * GiB, MaxRAMBelow4G: 1536 * MiB}, 1536 * MiB, 2560 * MiB, 32 * GiB},
+		{"pci-hole64-size", Options{MemorySize: 4 * GiB, PCIHole64Size: 64 * GiB}, 2 * GiB, 2 * GiB, 64 * GiB},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mem, err := newMemoryMap(&tt.opts)
+			require.NoError(t, err)
+			require.Equal(t, &memoryMap{below4GMemSize: tt.below4G, above4GMemSize: tt.above4G, pciHole64Size: tt.pci}, mem)
+		})
+	}
+}
+
+func TestNewMemoryMapErrors(t *testing.T) {
+	for name, opts := range map[string]Options{
+		"no memory":                 {},
+		"4 KiB aligned":             {MemorySize: 1<<30 + 4<<10},
+		"not page aligned":          {MemorySize: 1<<30 + 1},
+		"max-ram-below-4g above 4G": {MemorySize: 1 << 30, MaxRAMBelow4G: 1<<32 + 8<<10},
+	} {
+		_, err := newMemoryMap(&opts)
+		require.ErrorIs(t, err, ErrUnsupportedConfig, name)
+	}
+}
+
+// TestGenerateTablesQemuMemoryMap checks the PCI windows the memory map leaves in the _CRS of the
+// host bridge.
+func TestGenerateTablesQemuMemoryMap(t *testing.T) {
+	// The 32-bit window spans from the end of the RAM below 4 GiB to MMCONFIG:
+	// DWordMemory (ResourceProducer, PosDecode, MinFixed, MaxFixed, NonCacheable, ReadWrite, 0,
+	// start, 0xDFFFFFFF, 0, length)
+	for _, tt := range []struct {
+		opts    Options
+		below4G uint32
+	}{
+		{Options{MemorySize: 2815 << 20}, 2815 << 20},
+		{Options{MemorySize: 2816 << 20}, 2 << 30},
+		{Options{MemorySize: 2 << 30, MaxRAMBelow4G: 1 << 30}, 1 << 30},
+	} {
+		opts := tt.opts
+		opts.CPUCount = 1
+		blob, _, _, err := GenerateTablesQemu(opts)
+		require.NoError(t, err)
+		desc := []byte{0x87, 0x17, 0x00, 0x00, 0x0c, 0x01}
+		for _, v := range []uint32{0, tt.below4G, 0xdfffffff, 0, 0xe0000000 - tt.below4G} {
+			desc = binary.LittleEndian.AppendUint32(desc, v)
+		}
+		require.True(t, bytes.Contains(testACPITables(t, blob)["DSDT"], desc), "%+v", tt.opts)
+	}
+
+	// -global q35-pcihost.pci-hole64-size sets the minimum size of the 64-bit window.
+	opts := Options{MemorySize: 4 << 30, CPUCount: 1, PCIHole64Size: 64 << 30}
+	testPCIHole64(t, opts, 0x380000000000, 64<<30)
+	opts.Devices = testDeviceConfigs["vfio"]
+	testPCIHole64(t, opts, 0x380000000000, 96<<30)
+	opts.PCIHole64Size = 128 << 30
+	testPCIHole64(t, opts, 0x380000000000, 128<<30)
+}
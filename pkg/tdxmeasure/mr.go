@@ -0,0 +1,992 @@
+package tdxmeasure
+
+import (
+	"bytes"
+	"cmp"
+	"crypto"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+)
+
+// measureSha384 computes a SHA384 of the given blob.
+func measureSha384(data []byte) []byte {
+	h := sha512.Sum384(data)
+	return h[:]
+}
+
+// measureSha384Reader computes a SHA384 of the data read from r, which must be exactly size bytes.
+func measureSha384Reader(r io.Reader, size int64) ([]byte, error) {
+	h := sha512.New384()
+	n, err := io.Copy(h, r)
+	if err != nil {
+		return nil, err
+	}
+	if n != size {
+		return nil, fmt.Errorf("expected %d bytes, got %d", size, n)
+	}
+	return h.Sum(nil), nil
+}
+
+// measureTdxKernelCmdline measures the kernel cmdline.
+func measureTdxKernelCmdline(cmdline string) []byte {
+	// Add a NUL byte at the end.
+	d := append([]byte(cmdline), 0x00)
+	// Convert to UTF-16LE.
+	utf16le := unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM).NewEncoder()
+	xr := transform.NewReader(bytes.NewReader(d), utf16le)
+	converted, _ := io.ReadAll(xr)
+	return measureSha384(converted)
+}
+
+// tdxRAMEntry is a RAM range of the TD as described in the TD HOB.
+type tdxRAMEntry struct {
+	address  uint64
+	length   uint64
+	accepted bool
+}
+
+// tdxRAMEntries returns the RAM ranges of the TD the way QEMU tracks them: all RAM of the e820
+// map is unaccepted, except for the TDVF sections accepted by the VMM which are split off the
+// range containing them. The entries are sorted by address.
+func tdxRAMEntries(mem *memoryMap, meta *tdvfMetadata) ([]tdxRAMEntry, error) {
+	entries := []tdxRAMEntry{{address: 0, length: mem.below4GMemSize}}
+	if mem.above4GMemSize > 0 {
+		entries = append(entries, tdxRAMEntry{address: above4GMemStart, length: mem.above4GMemSize})
+	}
+
+	for _, s := range meta.sections {
+		if !s.secType.accepted() {
+			continue
+		}
+		start, end := s.memoryAddress, s.memoryAddress+s.memoryDataSize
+		idx := slices.IndexFunc(entries, func(e tdxRAMEntry) bool {
+			return !e.accepted && start >= e.address && end <= e.address+e.length
+		})
+		if idx < 0 {
+			return nil, fmt.Errorf("%w: TDVF %s section at 0x%x is outside of RAM", ErrUnsupportedConfig, s.secType, start)
+		}
+
+		e := entries[idx]
+		split := []tdxRAMEntry{{address: start, length: s.memoryDataSize, accepted: true}}
+		if start > e.address {
+			split = append(split, tdxRAMEntry{address: e.address, length: start - e.address})
+		}
+		if end < e.address+e.length {
+			split = append(split, tdxRAMEntry{address: end, length: e.address + e.length - end})
+		}
+		entries = slices.Replace(entries, idx, idx+1, split...)
+	}
+
+	slices.SortFunc(entries, func(a, b tdxRAMEntry) int { return cmp.Compare(a.address, b.address) })
+	return entries, nil
+}
+
+// measureTdxQemuTdHob measures the TD HOB.
+func measureTdxQemuTdHob(mem *memoryMap, meta *tdvfMetadata) ([]byte, error) {
+	// Construct a TD hob in the same way as QEMU does. Note that all fields are little-endian.
+	// See: https://github.com/intel-staging/qemu-tdx/blob/tdx-qemu-next/hw/i386/tdvf-hob.c
+	var tdHob []byte
+	hobSection := meta.findSection(tdvfSectionTdHob)
+	if hobSection == nil {
+		return nil, fmt.Errorf("%w: missing TD_HOB section in TDVF metadata", ErrInvalidFirmware)
+	}
+
+	// Start with EFI_HOB_TYPE_HANDOFF.
+	tdHob = append(tdHob,
+		0x01, 0x00, // Header.HobType (EFI_HOB_TYPE_HANDOFF)
+		0x38, 0x00, // Header.HobLength (56 bytes)
+		0x00, 0x00, 0x00, 0x00, // Header.Reserved
+		0x09, 0x00, 0x00, 0x00, // Version (EFI_HOB_HANDOFF_TABLE_VERSION)
+		0x00, 0x00, 0x00, 0x00, // BootMode
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // EfiMemoryTop
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // EfiMemoryBottom
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // EfiFreeMemoryTop
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // EfiFreeMemoryBottom
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // EfiEndOfHobList (filled later)
+	)
+
+	// The rest of the HOBs are EFI_HOB_TYPE_RESOURCE_DESCRIPTOR, one for each RAM range.
+	entries, err := tdxRAMEntries(mem, meta)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		resourceType := byte(0x07) // EFI_RESOURCE_MEMORY_UNACCEPTED
+		if e.accepted {
+			resourceType = 0x00 // EFI_RESOURCE_SYSTEM_MEMORY
+		}
+		tdHob = append(tdHob,
+			0x03, 0x00, // Header.HobType (EFI_HOB_TYPE_RESOURCE_DESCRIPTOR)
+			0x30, 0x00, // Header.HobLength (48 bytes)
+			0x00, 0x00, 0x00, 0x00, // Header.Reserved
+			0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // Owner
+			resourceType, 0x00, 0x00, 0x00, // ResourceType
+			0x07, 0x00, 0x00, 0x00, // ResourceAttribute (present, initialized, tested)
+		)
+		tdHob = binary.LittleEndian.AppendUint64(tdHob, e.address) // PhysicalStart
+		tdHob = binary.LittleEndian.AppendUint64(tdHob, e.length)  // Length
+	}
+
+	// The HOB list is terminated by an 8 byte EFI_HOB_TYPE_END_OF_HOB_LIST, which is not measured.
+	hobListEnd := uint64(len(tdHob)) + 8
+	if hobListEnd > hobSection.memoryDataSize {
+		return nil, fmt.Errorf("%w: TD HOB doesn't fit into the TD_HOB section", ErrUnsupportedConfig)
+	}
+
+	// Update EfiEndOfHobList.
+	binary.LittleEndian.PutUint64(tdHob[48:56], hobSection.memoryAddress+hobListEnd)
+
+	// Measure the TD HOB.
+	return measureSha384(tdHob), nil
+}
+
+// measureLog computes a measurement of the given RTMR event log by simulating extending the RTMR.
+//
+// The index and running register value of each event are filled in along the way.
+func measureLog(log []Event) Register {
+	var mr Register // Initialize to zero.
+	for i := range log {
+		h := sha512.New384()
+		_, _ = h.Write(mr[:])
+		_, _ = h.Write(log[i].Digest[:])
+		copy(mr[:], h.Sum([]byte{}))
+
+		log[i].Index = i
+		log[i].RTMRValue = mr
+	}
+	return mr
+}
+
+// measureTdxQemuAcpiTables measures QEMU-generated ACPI tables for TDX.
+func measureTdxQemuAcpiTables(opts *Options) ([]byte, []byte, []byte, error) {
+	compute := func() (acpiHashes, error) {
+		var hashes acpiHashes
+		// Generate ACPI tables
+		tables, rsdp, loader, err := GenerateTablesQemu(*opts)
+		if err != nil {
+			return hashes, fmt.Errorf("failed to generate ACPI tables: %w", err)
+		}
+
+		// Measure ACPI tables
+		for i, blob := range [][]byte{tables, rsdp, loader} {
+			copy(hashes[i][:], measureSha384(blob))
+		}
+		return hashes, nil
+	}
+
+	var hashes acpiHashes
+	var err error
+	if opts.ACPICache != nil {
+		hashes, err = opts.ACPICache.get(opts, compute)
+	} else {
+		hashes, err = compute()
+	}
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return hashes[0][:], hashes[1][:], hashes[2][:], nil
+}
+
+// measureTdxQemuKernelImage measures QEMU-patched TDX kernel image.
+//
+// Only the setup header is read into memory and patched, the rest of the image is streamed from
+// the reader while hashing.
+func measureTdxQemuKernelImage(kernel io.ReaderAt, kernelSize int64, initRdSize uint32, mem *memoryMap, acpiDataSize uint32) ([]byte, error) {
+	// Check if kernel data is long enough for all required fields
+	const minKernelLength = 0x1000
+	if kernelSize < minKernelLength {
+		return nil, fmt.Errorf("%w: kernel data too short: need at least %d bytes, got %d", ErrInvalidKernel, minKernelLength, kernelSize)
+	}
+
+	// Read a mutable copy of the kernel header
+	kd := make([]byte, minKernelLength)
+	if _, err := kernel.ReadAt(kd, 0); err != nil {
+		return nil, fmt.Errorf("reading kernel header: %w", err)
+	}
+
+	// Get protocol version from kernel header
+	protocol := uint16(kd[0x206]) + (uint16(kd[0x207]) << 8)
+
+	// Determine addresses based on protocol version
+	var realAddr, cmdlineAddr uint32
+	if protocol < 0x200 || (kd[0x211]&0x01) == 0 {
+		// Low kernel
+		realAddr = 0x90000
+		cmdlineAddr = 0x9a000
+	} else if protocol < 0x202 {
+		// High but ancient kernel
+		realAddr = 0x90000
+		cmdlineAddr = 0x9a000
+	} else {
+		// High and recent kernel
+		realAddr = 0x10000
+		cmdlineAddr = 0x20000
+	}
+
+	if protocol >= 0x200 {
+		kd[0x210] = 0xb0 // type_of_loader = Qemu v0
+	}
+	if protocol >= 0x201 {
+		kd[0x211] |= 0x80 // loadflags |= CAN_USE_HEAP
+		// heap_end_ptr
+		binary.LittleEndian.PutUint32(kd[0x224:0x224+4], cmdlineAddr-realAddr-0x200)
+	}
+
+	if protocol >= 0x202 {
+		// cmd_line_ptr
+		binary.LittleEndian.PutUint32(kd[0x228:0x228+4], cmdlineAddr)
+	} else {
+		// For older protocols
+		binary.LittleEndian.PutUint16(kd[0x20:0x20+2], 0xA33F)
+		binary.LittleEndian.PutUint16(kd[0x22:0x22+2], uint16(cmdlineAddr-realAddr))
+	}
+
+	// Handle initrd if size is non-zero
+	if initRdSize > 0 {
+		// Check protocol version - must be >= 0x200 to support initrd
+		if protocol < 0x200 {
+			return nil, fmt.Errorf("%w: linux kernel too old to load a ram disk (protocol version 0x%x)", ErrInvalidKernel, protocol)
+		}
+
+		// Determine initrd_max based on protocol version
+		var initrdMax uint32
+		if protocol >= 0x20c {
+			xlf := binary.LittleEndian.Uint16(kd[0x236 : 0x236+2])
+			if (xlf & 0x40) != 0 {
+				// XLF_CAN_BE_LOADED_ABOVE_4G (0x40) is set
+				initrdMax = ^uint32(0) // UINT32_MAX
+			} else {
+				initrdMax = 0x37ffffff
+			}
+		} else if protocol >= 0x203 {
+			initrdMax = binary.LittleEndian.Uint32(kd[0x22c : 0x22c+4])
+			if initrdMax == 0 {
+				initrdMax = 0x37ffffff
+			}
+		} else {
+			initrdMax = 0x37ffffff
+		}
+
+		// Adjust initrd_max based on memory size and ACPI data size
+		below4gMemSize := uint32(mem.below4GMemSize)
+		if initrdMax >= below4gMemSize-acpiDataSize {
+			initrdMax = below4gMemSize - acpiDataSize - 1
+		}
+
+		if initRdSize >= initrdMax {
+			return nil, fmt.Errorf("%w (max: %d, need: %d)", ErrInitrdTooLarge, initrdMax, initRdSize)
+		}
+
+		initrdAddr := (initrdMax - initRdSize) & ^uint32(4095)
+
+		// Store initrd address and size in kernel header
+		binary.LittleEndian.PutUint32(kd[0x218:0x218+4], initrdAddr)
+		binary.LittleEndian.PutUint32(kd[0x21c:0x21c+4], initRdSize)
+	}
+
+	img, err := parsePEImage(&headerOverlay{kernel, kd}, kernelSize)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to parse PE file: %w", ErrInvalidKernel, err)
+	}
+	digest, err := img.hash(crypto.SHA384)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to hash PE file: %w", ErrInvalidKernel, err)
+	}
+	return digest, nil
+}
+
+// encodeGUID encodes an UEFI GUID into binary form.
+func encodeGUID(guid string) []byte {
+	var data []byte
+	atoms := strings.Split(guid, "-")
+	for idx, atom := range atoms {
+		raw, err := hex.DecodeString(atom)
+		if err != nil {
+			panic("bad GUID")
+		}
+
+		if idx <= 2 {
+			// Little-endian.
+			for i := range raw {
+				data = append(data, raw[len(raw)-1-i])
+			}
+		} else {
+			// Big-endian.
+			data = append(data, raw...)
+		}
+	}
+	return data
+}
+
+// measureTdxCfvImage measures the configuration firmware volume (CFV) holding the initial UEFI
+// variable store. TDVF measures the raw data of the CFV section described in its metadata.
+func measureTdxCfvImage(fw []byte, meta *tdvfMetadata) ([]byte, error) {
+	s := meta.findSection(tdvfSectionCfv)
+	if s == nil {
+		return nil, fmt.Errorf("%w: missing CFV section in TDVF metadata", ErrInvalidFirmware)
+	}
+	return measureSha384(fw[s.dataOffset : s.dataOffset+s.rawDataSize]), nil
+}
+
+// measureTdxEfiVariable measures an EFI variable event.
+func measureTdxEfiVariable(vendorGUID string, varName string, varData []byte) []byte {
+	return measureSha384(encodeUefiVariableData(vendorGUID, varName, varData))
+}
+
+// encodeUefiVariableData encodes the UEFI_VARIABLE_DATA structure used as event data of EFI
+// variable events.
+func encodeUefiVariableData(vendorGUID string, varName string, varData []byte) []byte {
+	var data []byte
+	data = append(data, encodeGUID(vendorGUID)...)
+
+	var encLen [8]byte
+	binary.LittleEndian.PutUint64(encLen[:], uint64(len(varName)))
+	data = append(data, encLen[:]...)
+	binary.LittleEndian.PutUint64(encLen[:], uint64(len(varData)))
+	data = append(data, encLen[:]...)
+
+	// Convert varName to UTF-16LE.
+	data = append(data, encodeUTF16(varName)...)
+	data = append(data, varData...)
+
+	return data
+}
+
+const (
+	attributeMrExtend   = 0b00000000_00000000_00000000_00000001
+	attributePageAug    = 0b00000000_00000000_00000000_00000010
+	pageSize            = 0x1000
+	mrExtendGranularity = 0x100
+)
+
+// tdvfSectionType is the type of a TDVF metadata section.
+type tdvfSectionType uint32
+
+// TDVF section types, see Section 11.1 of "Intel TDX Virtual Firmware Design Guide".
+const (
+	// Boot firmware volume, the firmware code.
+	tdvfSectionBfv tdvfSectionType = 0
+	// Configuration firmware volume, the initial UEFI variable store.
+	tdvfSectionCfv tdvfSectionType = 1
+	// TD HOB passed by the VMM.
+	tdvfSectionTdHob tdvfSectionType = 2
+	// Temporary memory used during early boot, accepted by the VMM.
+	tdvfSectionTempMem tdvfSectionType = 3
+	// Permanent memory, added with TDH.MEM.PAGE.AUG and accepted by the guest.
+	tdvfSectionPermMem tdvfSectionType = 4
+	// Payload (e.g. kernel) image.
+	tdvfSectionPayload tdvfSectionType = 5
+	// Payload parameters (e.g. kernel command line) filled in by the VMM.
+	tdvfSectionPayloadParam tdvfSectionType = 6
+	// TD information filled in by the VMM.
+	tdvfSectionTdInfo tdvfSectionType = 7
+)
+
+var tdvfSectionTypeNames = map[tdvfSectionType]string{
+	tdvfSectionBfv:          "BFV",
+	tdvfSectionCfv:          "CFV",
+	tdvfSectionTdHob:        "TD_HOB",
+	tdvfSectionTempMem:      "TempMem",
+	tdvfSectionPermMem:      "PermMem",
+	tdvfSectionPayload:      "Payload",
+	tdvfSectionPayloadParam: "PayloadParam",
+	tdvfSectionTdInfo:       "TD_INFO",
+}
+
+func (t tdvfSectionType) String() string {
+	if name, ok := tdvfSectionTypeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%x", uint32(t))
+}
+
+// hasRawData returns true if sections of this type may be copied from the firmware image. The
+// memory of the other sections is zero-initialized or filled in by the VMM, and so is the memory
+// of Payload sections without raw data, whose payload is loaded by the VMM.
+func (t tdvfSectionType) hasRawData() bool {
+	switch t {
+	case tdvfSectionBfv, tdvfSectionCfv, tdvfSectionPayload:
+		return true
+	default:
+		return false
+	}
+}
+
+// accepted returns true if the memory of sections of this type is accepted by the VMM before the
+// TD runs and reported as system memory in the TD HOB. As in QEMU, these are only the TD_HOB and
+// TempMem sections; PermMem is accepted by the guest and reported as unaccepted memory.
+func (t tdvfSectionType) accepted() bool {
+	switch t {
+	case tdvfSectionTdHob, tdvfSectionTempMem:
+		return true
+	default:
+		return false
+	}
+}
+
+type tdvfSection struct {
+	dataOffset     uint32
+	rawDataSize    uint32
+	memoryAddress  uint64
+	memoryDataSize uint64
+	secType        tdvfSectionType
+	attributes     uint32
+}
+
+// pageAdded returns true if the pages of the section are added with TDH.MEM.PAGE.ADD before
+// the TD runs, and thus measured into MRTD. PermMem sections are always added with
+// TDH.MEM.PAGE.AUG, like sections with the PAGE.AUG attribute.
+func (s *tdvfSection) pageAdded() bool {
+	return s.attributes&attributePageAug == 0 && s.secType != tdvfSectionPermMem
+}
+
+// check validates the section against the constraints of its type.
+func (s *tdvfSection) check(fwSize int) error {
+	if s.memoryAddress%pageSize != 0 {
+		return fmt.Errorf("non-aligned memory address")
+	}
+	if s.memoryDataSize%pageSize != 0 {
+		return fmt.Errorf("non-aligned memory data size")
+	}
+	if s.memoryDataSize < uint64(s.rawDataSize) {
+		return fmt.Errorf("memory data size is less than raw data size")
+	}
+	if uint64(s.dataOffset)+uint64(s.rawDataSize) > uint64(fwSize) {
+		return fmt.Errorf("raw data is outside of the firmware")
+	}
+
+	if _, ok := tdvfSectionTypeNames[s.secType]; !ok {
+		return fmt.Errorf("unknown section type %s", s.secType)
+	}
+	switch {
+	case s.secType == tdvfSectionTdInfo:
+		return fmt.Errorf("unsupported section type %s", s.secType)
+	case s.secType.hasRawData():
+		if s.rawDataSize == 0 && s.secType != tdvfSectionPayload {
+			return fmt.Errorf("%s section without raw data", s.secType)
+		}
+		// The extended memory must be covered by raw data, which rules out payloads loaded by the
+		// VMM.
+		if s.attributes&attributeMrExtend != 0 && uint64(s.rawDataSize) < s.memoryDataSize {
+			return fmt.Errorf("raw data size is less than memory data size")
+		}
+	default:
+		// Memory sections don't carry data that could be measured.
+		if s.rawDataSize != 0 {
+			return fmt.Errorf("%s section with raw data", s.secType)
+		}
+		if s.attributes&attributeMrExtend != 0 {
+			return fmt.Errorf("%s section with MR.EXTEND attribute", s.secType)
+		}
+	}
+	return nil
+}
+
+type tdvfMetadata struct {
+	sections []*tdvfSection
+}
+
+// findSection returns the first section of the given type or nil if there is none.
+func (m *tdvfMetadata) findSection(secType tdvfSectionType) *tdvfSection {
+	for _, s := range m.sections {
+		if s.secType == secType {
+			return s
+		}
+	}
+	return nil
+}
+
+// MRTDVariant is the order in which QEMU adds and measures the pages of the TDVF sections.
+type MRTDVariant int
+
+const (
+	// MRTDTwoPass first adds all pages of a section and then extends them in a second pass.
+	MRTDTwoPass MRTDVariant = iota
+	// MRTDSinglePass extends each page right after adding it.
+	MRTDSinglePass
+)
+
+var mrtdVariantNames = map[MRTDVariant]string{
+	MRTDTwoPass:    "two-pass",
+	MRTDSinglePass: "single-pass",
+}
+
+func (v MRTDVariant) String() string {
+	if name, ok := mrtdVariantNames[v]; ok {
+		return name
+	}
+	return fmt.Sprintf("MRTDVariant(%d)", int(v))
+}
+
+// ParseMRTDVariant parses the name of an MRTD variant ("two-pass" or "single-pass").
+func ParseMRTDVariant(name string) (MRTDVariant, error) {
+	for v, n := range mrtdVariantNames {
+		if n == name {
+			return v, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown MRTD variant %q", name)
+}
+
+func (m *tdvfMetadata) computeMrtd(fw []byte, variant MRTDVariant) []byte {
+	h := sha512.New384()
+
+	memPageAdd := func(s *tdvfSection, page uint64) {
+		if s.pageAdded() {
+			// Use TDCALL [TDH.MEM.PAGE.ADD].
+			//
+			// Byte 0 through 11 contain the ASCII string 'MEM.PAGE.ADD'.
+			// Byte 16 through 23 contain the GPA (in little-endian format).
+			// All the other bytes contain 0.
+			var buf [128]byte
+			copy(buf[:12], []byte("MEM.PAGE.ADD"))
+			binary.LittleEndian.PutUint64(buf[16:24], s.memoryAddress+page*pageSize)
+			_, _ = h.Write(buf[:])
+		}
+	}
+
+	mrExtend := func(s *tdvfSection, page uint64) {
+		if s.attributes&attributeMrExtend != 0 {
+			// Need TDCALL [TDH.MR.EXTEND].
+			for i := range pageSize / mrExtendGranularity {
+				// Byte 0 through 8 contain the ASCII string 'MR.EXTEND'.
+				// Byte 16 through 23 contain the GPA (in little-endian format).
+				// All the other bytes contain 0.
+				var buf [128]byte
+				copy(buf[:9], []byte("MR.EXTEND"))
+				binary.LittleEndian.PutUint64(buf[16:24], s.memoryAddress+page*pageSize+uint64(i*mrExtendGranularity))
+				_, _ = h.Write(buf[:])
+
+				// The other two extension buffers contain the chunk’s content.
+				chunkOffset := int(s.dataOffset) + int(page*pageSize) + i*mrExtendGranularity
+				_, _ = h.Write(fw[chunkOffset : chunkOffset+mrExtendGranularity])
+			}
+		}
+	}
+
+	for _, s := range m.sections {
+		numPages := s.memoryDataSize / pageSize
+
+		// There are two known implementations of how QEMU is performing TD initialization:
+		//
+		// - First add all pages using MEM.PAGE.ADD and then in a second pass perform MR.EXTEND for
+		//   for each page (MRTDTwoPass).
+		//
+		// - For each page first add it using MEM.PAGE.ADD and then perform MR.EXTEND for that same
+		//   page (MRTDSinglePass).
+		//
+		// Unfortunately, changing these orders changes the MRTD computation so we need both.
+		switch variant {
+		case MRTDTwoPass:
+			for page := range numPages {
+				memPageAdd(s, page)
+			}
+			for page := range numPages {
+				mrExtend(s, page)
+			}
+		case MRTDSinglePass:
+			for page := range numPages {
+				memPageAdd(s, page)
+				mrExtend(s, page)
+			}
+		default:
+			panic("unknown MRTD variant")
+		}
+	}
+	return h.Sum(nil)
+}
+
+// ComputeMRTD computes the MRTD of the firmware with the given page-add ordering.
+func ComputeMRTD(fw []byte, variant MRTDVariant) (Register, error) {
+	var mrtd Register
+	if _, ok := mrtdVariantNames[variant]; !ok {
+		return mrtd, fmt.Errorf("%w: unknown MRTD variant %d", ErrUnsupportedConfig, variant)
+	}
+	meta, err := parseTdvfMetadata(fw)
+	if err != nil {
+		return mrtd, err
+	}
+	copy(mrtd[:], meta.computeMrtd(fw, variant))
+	return mrtd, nil
+}
+
+// parseTdvfMetadata parses the TDVF metadata from the firmware blob.
+//
+// See Section 11 of "Intel TDX Virtual Firmware Design Guide" for details.
+func parseTdvfMetadata(fw []byte) (*tdvfMetadata, error) {
+	const (
+		tdxMetadataOffsetGUID = "e47a6535-984a-4798-865e-4685a7bf8ec2"
+		tdxMetadataVersion    = 1
+		tdvfSignature         = "TDVF"
+		tableFooterGUID       = "96b582de-1fb2-45f7-baea-a366c55a082d"
+		bytesAfterTableFooter = 32
+	)
+
+	if len(fw) < bytesAfterTableFooter+16+2 {
+		return nil, fmt.Errorf("%w: firmware is too short", ErrInvalidFirmware)
+	}
+	offset := len(fw) - bytesAfterTableFooter
+	encodedFooterGUID := encodeGUID(tableFooterGUID)
+	guid := fw[offset-16 : offset]
+	tablesLen := int(binary.LittleEndian.Uint16(fw[offset-16-2 : offset-16]))
+	if !bytes.Equal(guid, encodedFooterGUID) {
+		return nil, fmt.Errorf("%w: malformed OVMF table footer", ErrInvalidFirmware)
+	}
+	if tablesLen == 0 || tablesLen > offset-16-2 {
+		return nil, fmt.Errorf("%w: malformed OVMF table footer", ErrInvalidFirmware)
+	}
+	tables := fw[offset-16-2-tablesLen : offset-16-2]
+	offset = len(tables)
+
+	// Find TDVF metadata table in OVMF, starting at the end.
+	var data []byte
+	encodedGUID := encodeGUID(tdxMetadataOffsetGUID)
+	for {
+		if offset < 18 {
+			return nil, fmt.Errorf("%w: missing TDVF metadata in firmware", ErrInvalidFirmware)
+		}
+
+		// The data structure is:
+		//
+		//   arbitrary length data
+		//   2 byte length of entire entry
+		//   16 byte GUID
+		//
+		guid = tables[offset-16 : offset]
+		entryLen := int(binary.LittleEndian.Uint16(tables[offset-16-2 : offset-16]))
+		if entryLen < 18 || offset < 18+entryLen {
+			return nil, fmt.Errorf("%w: malformed OVMF table in firmware at offset %d", ErrInvalidFirmware, offset)
+		}
+
+		if bytes.Equal(guid, encodedGUID) {
+			data = tables[offset-18-entryLen : offset-18]
+			break
+		}
+
+		offset -= entryLen
+	}
+	if data == nil {
+		return nil, fmt.Errorf("%w: missing TDVF metadata in firmware", ErrInvalidFirmware)
+	}
+
+	// Extract and parse TDVF metadata descriptor:
+	//
+	//   4 byte signature
+	//   4 byte length
+	//   4 byte version
+	//   4 byte number of section entries
+	//   32 byte each section * number of sections
+	//
+	tdvfMetaOffset := int(binary.LittleEndian.Uint32(data[len(data)-4:]))
+	tdvfMetaOffset = len(fw) - tdvfMetaOffset
+	if tdvfMetaOffset < 0 || tdvfMetaOffset+16 > len(fw) {
+		return nil, fmt.Errorf("%w: TDVF metadata descriptor is outside of the firmware", ErrInvalidFirmware)
+	}
+	tdvfMetaDesc := fw[tdvfMetaOffset : tdvfMetaOffset+16]
+	if string(tdvfMetaDesc[:4]) != tdvfSignature {
+		return nil, fmt.Errorf("%w: malformed TDVF metadata descriptor in firmware", ErrInvalidFirmware)
+	}
+	tdvfLength := int(binary.LittleEndian.Uint32(tdvfMetaDesc[4:8]))
+	tdvfVersion := binary.LittleEndian.Uint32(tdvfMetaDesc[8:12])
+	tdvfNumberOfSectionEntries := int(binary.LittleEndian.Uint32(tdvfMetaDesc[12:16]))
+	if tdvfNumberOfSectionEntries > (len(fw)-tdvfMetaOffset-16)/32 {
+		return nil, fmt.Errorf("%w: TDVF metadata section entries are outside of the firmware", ErrInvalidFirmware)
+	}
+	// Version 1 consists of the descriptor and the section entries only. Later revisions keep
+	// that layout and may only append to it.
+	switch {
+	case tdvfVersion == 0:
+		return nil, fmt.Errorf("%w: unsupported TDVF metadata descriptor version in firmware", ErrInvalidFirmware)
+	case tdvfVersion == 1 && tdvfLength != 16+32*tdvfNumberOfSectionEntries,
+		tdvfVersion > 1 && tdvfLength < 16+32*tdvfNumberOfSectionEntries:
+		return nil, fmt.Errorf("%w: TDVF metadata descriptor length doesn't match its section entries", ErrInvalidFirmware)
+	}
+
+	// Parse section entries.
+	var meta tdvfMetadata
+	for section := range tdvfNumberOfSectionEntries {
+		secOffset := tdvfMetaOffset + 16 + 32*section
+		secData := fw[secOffset : secOffset+32]
+
+		s := &tdvfSection{
+			dataOffset:     binary.LittleEndian.Uint32(secData[:4]),
+			rawDataSize:    binary.LittleEndian.Uint32(secData[4:8]),
+			memoryAddress:  binary.LittleEndian.Uint64(secData[8:16]),
+			memoryDataSize: binary.LittleEndian.Uint64(secData[16:24]),
+			secType:        tdvfSectionType(binary.LittleEndian.Uint32(secData[24:28])),
+			attributes:     binary.LittleEndian.Uint32(secData[28:32]),
+		}
+
+		// Sanity check section.
+		if err := s.check(len(fw)); err != nil {
+			return nil, fmt.Errorf("%w: TDVF metadata section %d: %w", ErrInvalidFirmware, section, err)
+		}
+		if s.secType == tdvfSectionTdHob && meta.findSection(tdvfSectionTdHob) != nil {
+			return nil, fmt.Errorf("%w: TDVF metadata has more than one TD_HOB section", ErrInvalidFirmware)
+		}
+
+		meta.sections = append(meta.sections, s)
+	}
+	return &meta, nil
+}
+
+// EventType is the TCG event type of an event log entry.
+type EventType uint32
+
+// TCG event types used by TDVF when measuring into the RTMRs.
+const (
+	EvSeparator                  EventType = 0x00000004
+	EvEventTag                   EventType = 0x00000006
+	EvPlatformConfigFlags        EventType = 0x0000000a
+	EvEfiVariableDriverConfig    EventType = 0x80000001
+	EvEfiVariableBoot            EventType = 0x80000002
+	EvEfiBootServicesApplication EventType = 0x80000003
+	EvEfiAction                  EventType = 0x80000007
+	EvEfiVariableAuthority       EventType = 0x800000e0
+	EvEfiPlatformFirmwareBlob2   EventType = 0x8000000a
+	EvEfiHandoffTables2          EventType = 0x8000000b
+)
+
+var eventTypeNames = map[EventType]string{
+	EvSeparator:                  "EV_SEPARATOR",
+	EvEventTag:                   "EV_EVENT_TAG",
+	EvPlatformConfigFlags:        "EV_PLATFORM_CONFIG_FLAGS",
+	EvEfiVariableDriverConfig:    "EV_EFI_VARIABLE_DRIVER_CONFIG",
+	EvEfiVariableBoot:            "EV_EFI_VARIABLE_BOOT",
+	EvEfiBootServicesApplication: "EV_EFI_BOOT_SERVICES_APPLICATION",
+	EvEfiAction:                  "EV_EFI_ACTION",
+	EvEfiVariableAuthority:       "EV_EFI_VARIABLE_AUTHORITY",
+	EvEfiPlatformFirmwareBlob2:   "EV_EFI_PLATFORM_FIRMWARE_BLOB2",
+	EvEfiHandoffTables2:          "EV_EFI_HANDOFF_TABLES2",
+	EvDstackRuntime:              "DSTACK_RUNTIME_EVENT",
+}
+
+func (t EventType) String() string {
+	if name, ok := eventTypeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%08x", uint32(t))
+}
+
+// Register is the value of a TDX measurement register.
+type Register [48]byte
+
+// String returns the hex encoding of the register value.
+func (r Register) String() string {
+	return hex.EncodeToString(r[:])
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (r Register) MarshalText() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+// Event is a single predicted entry of an RTMR event log.
+type Event struct {
+	// Index is the position of the event within the log of its RTMR.
+	Index int
+	// RTMR is the index of the register the event is extended into.
+	RTMR int
+	// EventType is the TCG event type.
+	EventType EventType
+	// Description is a human-readable description of what was measured.
+	Description string
+	// Digest is the SHA384 digest extended into the register.
+	Digest [48]byte
+	// RTMRValue is the register value after the event was extended.
+	RTMRValue Register
+}
+
+// newEvent creates an event log entry, leaving the index and register value to measureLog.
+func newEvent(rtmr int, eventType EventType, description string, digest []byte) Event {
+	ev := Event{
+		RTMR:        rtmr,
+		EventType:   eventType,
+		Description: description,
+	}
+	copy(ev.Digest[:], digest)
+	return ev
+}
+
+// Measurements contains all the measurement values for TDX
+type Measurements struct {
+	// MRTDVariant is the page-add ordering MRTD was computed with.
+	MRTDVariant MRTDVariant
+
+	MRTD  Register
+	RTMR0 Register
+	RTMR1 Register
+	RTMR2 Register
+	// RTMR3 is the replay of the runtime events of the options, zero if there are none.
+	RTMR3 Register
+
+	// EventLog contains the events of RTMR0, RTMR1, RTMR2 and RTMR3, in that order.
+	EventLog []Event
+}
+
+// CalculateMrEnclave calculates mr_enclave = sha256(mrtd+rtmr0+rtmr1+rtmr2+mr_key_provider) for
+// the hex encoded key provider measurement.
+func (m *Measurements) CalculateMrEnclave(mrKeyProvider string) (string, error) {
+	mrKeyProviderBytes, err := ParseKeyProvider(mrKeyProvider)
+	if err != nil {
+		return "", err
+	}
+	mrEnclave, err := MrEnclaveAggregate.Compute(m, mrKeyProviderBytes)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(mrEnclave), nil
+}
+
+// CalculateMrImage calculates mr_image = sha256(mrtd+rtmr1+rtmr2)
+func (m *Measurements) CalculateMrImage() string {
+	mrImage, _ := MrImageAggregate.Compute(m, nil)
+	return hex.EncodeToString(mrImage)
+}
+
+// Options describes a TD launched by QEMU.
+type Options struct {
+	// Firmware is the TDVF (OVMF) firmware image.
+	Firmware []byte
+	// Kernel is the Linux kernel image (bzImage with EFI stub).
+	Kernel []byte
+	// KernelReader and KernelSize can be set instead of Kernel to read the kernel image on
+	// demand while it's being hashed.
+	KernelReader io.ReaderAt
+	KernelSize   int64
+	// Initrd is the initial ramdisk, may be empty.
+	Initrd []byte
+	// InitrdReader and InitrdSize can be set instead of Initrd to hash the initrd while it's
+	// being read. The size is needed up front as it's patched into the kernel setup header.
+	InitrdReader io.Reader
+	InitrdSize   int64
+	// MemorySize is the guest memory size in bytes, a multiple of 8 KiB as QEMU rounds it up.
+	MemorySize uint64
+	// MaxRAMBelow4G limits the RAM mapped below 4 GiB in bytes (-machine max-ram-below-4g), which
+	// enlarges the 32-bit PCI hole. Zero keeps QEMU's split at 2 GiB or 2.75 GiB.
+	MaxRAMBelow4G uint64
+	// PCIHole64Size is the minimum size of the 64-bit PCI hole in bytes
+	// (-global q35-pcihost.pci-hole64-size). Zero selects QEMU's default of 32 GiB.
+	PCIHole64Size uint64
+	// CPUCount is the number of virtual CPUs, between 1 and MaxCPUCount.
+	CPUCount uint32
+	// Sockets is the number of CPU sockets the CPUs are evenly split into (-smp sockets=N), which
+	// determines their APIC IDs. Zero means a single socket.
+	Sockets uint32
+	// Devices describes the devices attached to the guest. A nil value selects
+	// DefaultDeviceConfig.
+	Devices *DeviceConfig
+	// NUMA describes the NUMA topology of the guest, nil if NUMA is not configured.
+	NUMA *NUMAConfig
+	// KernelCmdline is the kernel command line.
+	KernelCmdline string
+	// SecureBoot holds the enrolled Secure Boot keys. A nil value means Secure Boot is disabled.
+	SecureBoot *SecureBootConfig
+	// MRTDVariant selects the page-add ordering used by QEMU, which defaults to MRTDTwoPass.
+	MRTDVariant MRTDVariant
+	// RuntimeEvents are the events the dstack guest extends into RTMR3 at runtime, in order.
+	RuntimeEvents []RuntimeEvent
+	// ACPICache, if set, caches the measurements of the ACPI tables across calls.
+	ACPICache *ACPICache
+}
+
+// MaxCPUCount is the maximum number of virtual CPUs of a QEMU q35 machine.
+const MaxCPUCount = 4096
+
+// checkCPUCount checks that the CPU count is supported.
+func checkCPUCount(cpuCount uint32) error {
+	if cpuCount == 0 || cpuCount > MaxCPUCount {
+		return fmt.Errorf("%w: CPU count %d is out of range (1-%d)", ErrUnsupportedConfig, cpuCount, MaxCPUCount)
+	}
+	return nil
+}
+
+// kernel returns the reader and size of the kernel image.
+func (opts *Options) kernel() (io.ReaderAt, int64) {
+	if opts.KernelReader != nil {
+		return opts.KernelReader, opts.KernelSize
+	}
+	return bytes.NewReader(opts.Kernel), int64(len(opts.Kernel))
+}
+
+// initrd returns the reader and size of the initrd.
+func (opts *Options) initrd() (io.Reader, int64) {
+	if opts.InitrdReader != nil {
+		return opts.InitrdReader, opts.InitrdSize
+	}
+	return bytes.NewReader(opts.Initrd), int64(len(opts.Initrd))
+}
+
+// separatorEventData is the data of the EV_SEPARATOR events.
+var separatorEventData = []byte{0x00, 0x00, 0x00, 0x00}
+
+// rtmr0Measurer computes RTMR0 for varying machine configurations, measuring the firmware and
+// Secure Boot inputs that don't depend on them only once.
+type rtmr0Measurer struct {
+	tdvfMeta     *tdvfMetadata
+	cfvImageHash []byte
+	sbEvents     []Event
+	authority    []Event
+}
+
+func newRTMR0Measurer(opts *Options, tdvfMeta *tdvfMetadata) (*rtmr0Measurer, error) {
+	cfvImageHash, err := measureTdxCfvImage(opts.Firmware, tdvfMeta)
+	if err != nil {
+		return nil, err
+	}
+	r := &rtmr0Measurer{
+		tdvfMeta:     tdvfMeta,
+		cfvImageHash: cfvImageHash,
+		sbEvents:     measureTdxSecureBootVariables(opts.SecureBoot),
+	}
+	if opts.SecureBoot.Enabled() {
+		kernel, kernelSize := opts.kernel()
+		authority, err := measureTdxKernelAuthority(kernel, kernelSize, opts.SecureBoot)
+		if err != nil {
+			return nil, err
+		}
+		r.authority = []Event{authority}
+	}
+	return r, nil
+}
+
+// eventLog returns the RTMR0 event log of the machine configuration of opts, given the hash of its
+// TD HOB. The TD HOB only depends on the memory map, measureTdxQemuTdHob computes it.
+func (r *rtmr0Measurer) eventLog(opts *Options, tdHobHash []byte) ([]Event, error) {
+	acpiTablesHash, acpiRsdpHash, acpiLoaderHash, err := measureTdxQemuAcpiTables(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	log := []Event{
+		newEvent(0, EvEfiHandoffTables2, "TD HOB", tdHobHash),
+		newEvent(0, EvEfiPlatformFirmwareBlob2, "CFV image", r.cfvImageHash),
+	}
+	log = append(log, r.sbEvents...)
+	log = append(log,
+		newEvent(0, EvSeparator, "Separator", measureSha384(separatorEventData)),
+		newEvent(0, EvPlatformConfigFlags, "ACPI table loader (etc/table-loader)", acpiLoaderHash),
+		newEvent(0, EvPlatformConfigFlags, "ACPI RSDP (etc/acpi/rsdp)", acpiRsdpHash),
+		newEvent(0, EvPlatformConfigFlags, "ACPI tables (etc/acpi/tables)", acpiTablesHash),
+		newEvent(0, EvEfiVariableBoot, "BootOrder", measureSha384(encodeBootOrder([]uint16{0}))),
+		newEvent(0, EvEfiVariableBoot, "Boot0000", measureSha384(ovmfUiAppBootOption.encode())),
+	)
+	return append(log, r.authority...), nil
+}
+
+// Measure calculates the measurements of a TD launched by QEMU with the given options.
+func Measure(opts Options) (*Measurements, error) {
+	m, err := NewMeasurer(opts)
+	if err != nil {
+		return nil, err
+	}
+	return m.Measure(opts.MemorySize, opts.CPUCount)
+}
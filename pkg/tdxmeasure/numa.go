@@ -0,0 +1,184 @@
+package tdxmeasure
+
+import "fmt"
+
+// NUMAConfig describes the NUMA topology of the guest, as given to QEMU with -numa options.
+//
+// The topology is reflected in the SRAT and SLIT ACPI tables and the _PXM objects of the CPUs. The
+// TD HOB is built from QEMU's e820 table, which doesn't distinguish nodes, so it is not affected.
+type NUMAConfig struct {
+	Nodes []NUMANode `json:"nodes"`
+	// Distances is the full matrix of distances between the nodes (-numa dist). The SLIT table is
+	// only generated if distances are given.
+	Distances [][]uint8 `json:"distances,omitempty"`
+}
+
+// NUMANode is a NUMA node of the guest (-numa node).
+type NUMANode struct {
+	// MemorySize is the memory of the node in bytes. The node sizes must add up to the memory
+	// size of the guest.
+	MemorySize uint64 `json:"memory"`
+	// CPUs are the indexes of the CPUs of the node. CPUs that aren't assigned to any node are
+	// placed on the node of their socket index modulo the number of nodes, as done by QEMU.
+	CPUs []uint32 `json:"cpus,omitempty"`
+}
+
+const (
+	maxNUMANodes    = 128
+	numaDistanceMin = 10 // Distance of a node to itself.
+)
+
+// cpuNodes validates the NUMA configuration and returns the node of each CPU.
+func (c *NUMAConfig) cpuNodes(memorySize uint64, socketIDs []uint32) ([]uint32, error) {
+	if len(c.Nodes) == 0 || len(c.Nodes) > maxNUMANodes {
+		return nil, fmt.Errorf("%w: invalid number of NUMA nodes %d", ErrUnsupportedConfig, len(c.Nodes))
+	}
+
+	var total uint64
+	nodes := make([]uint32, len(socketIDs))
+	assigned := make([]bool, len(socketIDs))
+	for i, node := range c.Nodes {
+		total += node.MemorySize
+		for _, cpu := range node.CPUs {
+			if int(cpu) >= len(nodes) {
+				return nil, fmt.Errorf("%w: NUMA node %d has nonexistent CPU %d", ErrUnsupportedConfig, i, cpu)
+			}
+			if assigned[cpu] {
+				return nil, fmt.Errorf("%w: CPU %d is assigned to several NUMA nodes", ErrUnsupportedConfig, cpu)
+			}
+			nodes[cpu], assigned[cpu] = uint32(i), true
+		}
+	}
+	if total != memorySize {
+		return nil, fmt.Errorf("%w: NUMA node memory (%#x) doesn't match the memory size (%#x)", ErrUnsupportedConfig, total, memorySize)
+	}
+	for cpu := range nodes {
+		if !assigned[cpu] {
+			nodes[cpu] = socketIDs[cpu] % uint32(len(c.Nodes))
+		}
+	}
+
+	if c.Distances != nil {
+		if len(c.Distances) != len(c.Nodes) {
+			return nil, fmt.Errorf("%w: NUMA distance matrix doesn't match the number of nodes", ErrUnsupportedConfig)
+		}
+		for i, row := range c.Distances {
+			if len(row) != len(c.Nodes) {
+				return nil, fmt.Errorf("%w: NUMA distance matrix doesn't match the number of nodes", ErrUnsupportedConfig)
+			}
+			for j, d := range row {
+				if i == j && d != numaDistanceMin || i != j && d <= numaDistanceMin {
+					return nil, fmt.Errorf("%w: invalid NUMA distance %d from node %d to %d", ErrUnsupportedConfig, d, i, j)
+				}
+			}
+		}
+	}
+	return nodes, nil
+}
+
+// appendSRATMemory appends a Memory Affinity structure.
+func (b *acpiTableBuilder) appendSRATMemory(base, length uint64, node uint32, flags uint32) {
+	b.appendInt(1, 1)  // Type
+	b.appendInt(40, 1) // Length
+	b.appendInt(uint64(node), 4)
+	b.appendInt(0, 2) // Reserved
+	b.appendInt(base, 8)
+	b.appendInt(length, 8)
+	b.appendInt(0, 4) // Reserved
+	b.appendInt(uint64(flags), 4)
+	b.appendInt(0, 8) // Reserved
+}
+
+// buildSRAT builds the System Resource Affinity Table.
+func (b *acpiTableBuilder) buildSRAT(m *qemuMachine) uint32 {
+	const (
+		hole640KStart = 0xa0000
+		hole640KEnd   = 0x100000
+		memAffEnabled = 1
+	)
+
+	offset := b.begin("SRAT", 1)
+	b.appendInt(1, 4) // Reserved
+	b.appendInt(0, 8) // Reserved
+
+	for i, apicID := range m.apicIDs {
+		node := m.cpuNodes[i]
+		if apicID < 255 {
+			// Processor Local APIC Affinity.
+			b.appendInt(0, 1)  // Type
+			b.appendInt(16, 1) // Length
+			b.appendInt(uint64(node&0xff), 1)
+			b.appendInt(uint64(apicID), 1)
+			b.appendInt(1, 4) // Flags: enabled.
+			b.appendInt(0, 1) // Local SAPIC EID
+			b.appendInt(uint64(node>>8), 3)
+			b.appendInt(0, 4) // Clock domain
+		} else {
+			// Processor Local x2APIC Affinity.
+			b.appendInt(2, 1)  // Type
+			b.appendInt(24, 1) // Length
+			b.appendInt(0, 2)  // Reserved
+			b.appendInt(uint64(node), 4)
+			b.appendInt(uint64(apicID), 4)
+			b.appendInt(1, 4) // Flags: enabled.
+			b.appendInt(0, 4) // Clock domain
+			b.appendInt(0, 4) // Reserved
+		}
+	}
+
+	// The nodes are laid out consecutively in the guest physical memory, skipping the holes below
+	// 1 MiB and 4 GiB.
+	memStart := len(b.data)
+	var nextBase uint64
+	for i, node := range m.numa.Nodes {
+		memBase := nextBase
+		memLen := node.MemorySize
+		nextBase = memBase + memLen
+
+		if memBase <= hole640KStart && nextBase > hole640KStart {
+			memLen -= nextBase - hole640KStart
+			if memLen > 0 {
+				b.appendSRATMemory(memBase, memLen, uint32(i), memAffEnabled)
+			}
+			if nextBase <= hole640KEnd {
+				nextBase = hole640KEnd
+				continue
+			}
+			memBase = hole640KEnd
+			memLen = nextBase - hole640KEnd
+		}
+
+		lowMemSize := m.mem.below4GMemSize
+		if memBase <= lowMemSize && nextBase > lowMemSize {
+			memLen -= nextBase - lowMemSize
+			if memLen > 0 {
+				b.appendSRATMemory(memBase, memLen, uint32(i), memAffEnabled)
+			}
+			memBase = above4GMemStart
+			memLen = nextBase - lowMemSize
+			nextBase = memBase + memLen
+		}
+
+		if memLen > 0 {
+			b.appendSRATMemory(memBase, memLen, uint32(i), memAffEnabled)
+		}
+	}
+
+	// QEMU pads the memory affinity structures with empty ones for compatibility with old guests.
+	for slots := (len(b.data) - memStart) / 40; slots < len(m.numa.Nodes)+2; slots++ {
+		b.appendSRATMemory(0, 0, 0, 0)
+	}
+	b.end(offset)
+	return offset
+}
+
+// buildSLIT builds the System Locality Distance Information Table.
+func (b *acpiTableBuilder) buildSLIT(m *qemuMachine) uint32 {
+	offset := b.begin("SLIT", 1)
+	b.appendInt(uint64(len(m.numa.Nodes)), 8)
+	for _, row := range m.numa.Distances {
+		b.data = append(b.data, row...)
+	}
+	b.end(offset)
+	return offset
+}
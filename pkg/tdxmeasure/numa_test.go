@@ -0,0 +1,316 @@
+package tdxmeasure
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testNUMAConfigs are the NUMA configurations of testdata/acpi-numa.txt, with the QEMU options
+// they correspond to.
+var testNUMAConfigs = map[string]Options{
+	// -m 4G -smp 4,sockets=2 -numa node,memdev=m0 -numa node,memdev=m1
+	// -numa dist,src=0,dst=1,val=20
+	"two-nodes": {MemorySize: 4 << 30, CPUCount: 4, Sockets: 2, NUMA: &NUMAConfig{
+		Nodes:     []NUMANode{{MemorySize: 2 << 30}, {MemorySize: 2 << 30}},
+		Distances: [][]uint8{{10, 20}, {20, 10}},
+	}},
+	// The same without -numa dist, which leaves out the SLIT.
+	"two-nodes-no-distances": {MemorySize: 4 << 30, CPUCount: 4, Sockets: 2, NUMA: &NUMAConfig{
+		Nodes: []NUMANode{{MemorySize: 2 << 30}, {MemorySize: 2 << 30}},
+	}},
+	// Node 1 spans the split at 2 GiB of a guest with 2816 MiB, its memory continues at 4 GiB:
+	// -m 2816M -smp 6,sockets=3 -numa node,memdev=m0 (512M) -numa node,memdev=m1 (1792M)
+	// -numa node,memdev=m2 (512M) -numa dist,... (10/21/31)
+	"three-nodes-2816M": {MemorySize: 2816 << 20, CPUCount: 6, Sockets: 3, NUMA: &NUMAConfig{
+		Nodes:     []NUMANode{{MemorySize: 512 << 20}, {MemorySize: 1792 << 20}, {MemorySize: 512 << 20}},
+		Distances: [][]uint8{{10, 21, 31}, {21, 10, 21}, {31, 21, 10}},
+	}},
+	// Three nodes with explicitly placed CPUs, node 1 spanning the split of an 8 GiB guest:
+	// -m 8G -smp 6 -numa node,memdev=m0,cpus=0-1 (1G) -numa node,memdev=m1,cpus=4-5 (4G)
+	// -numa node,memdev=m2,cpus=2-3 (3G)
+	"three-nodes-cpus": {MemorySize: 8 << 30, CPUCount: 6, NUMA: &NUMAConfig{
+		Nodes: []NUMANode{
+			{MemorySize: 1 << 30, CPUs: []uint32{0, 1}},
+			{MemorySize: 4 << 30, CPUs: []uint32{4, 5}},
+			{MemorySize: 3 << 30, CPUs: []uint32{2, 3}},
+		},
+		Distances: [][]uint8{{10, 20, 20}, {20, 10, 20}, {20, 20, 10}},
+	}},
+	// x2APIC CPUs: -m 4G -smp 300,sockets=2 with two nodes.
+	"two-nodes-x2apic": {MemorySize: 4 << 30, CPUCount: 300, Sockets: 2, NUMA: &NUMAConfig{
+		Nodes:     []NUMANode{{MemorySize: 1 << 30}, {MemorySize: 3 << 30}},
+		Distances: [][]uint8{{10, 20}, {20, 10}},
+	}},
+}
+
+// TestGenerateTablesQemuNUMA checks the tables of the NUMA configurations against
+// testdata/acpi-numa.txt.
+func TestGenerateTablesQemuNUMA(t *testing.T) {
+	testGoldenTables(t, "testdata/acpi-numa.txt", testNUMAConfigs)
+}
+
+// testSRATMemory is a Memory Affinity structure of the SRAT.
+type testSRATMemory struct {
+	base, length uint64
+	node, flags  uint32
+}
+
+// testSRATCPU is a Processor Local APIC or x2APIC Affinity structure of the SRAT.
+type testSRATCPU struct {
+	x2APIC       bool
+	apicID, node uint32
+}
+
+// testSRAT decodes the affinity structures of the SRAT of the configuration.
+func testSRAT(t *testing.T, opts Options) ([]testSRATCPU, []testSRATMemory) {
+	blob, _, _, err := GenerateTablesQemu(opts)
+	require.NoError(t, err)
+	srat := testACPITables(t, blob)["SRAT"]
+	require.NotNil(t, srat)
+
+	var (
+		cpus   []testSRATCPU
+		memory []testSRATMemory
+	)
+	for entries := srat[48:]; len(entries) > 0; entries = entries[entries[1]:] {
+		switch entries[0] {
+		case 0: // Processor Local APIC Affinity
+			require.Equal(t, byte(16), entries[1])
+			require.Equal(t, uint32(1), binary.LittleEndian.Uint32(entries[4:]), "flags")
+			node := uint32(entries[2]) | uint32(entries[9])<<8 | uint32(entries[10])<<16 | uint32(entries[11])<<24
+			cpus = append(cpus, testSRATCPU{apicID: uint32(entries[3]), node: node})
+		case 1: // Memory Affinity
+			require.Equal(t, byte(40), entries[1])
+			memory = append(memory, testSRATMemory{
+				base:   binary.LittleEndian.Uint64(entries[8:]),
+				length: binary.LittleEndian.Uint64(entries[16:]),
+				node:   binary.LittleEndian.Uint32(entries[2:]),
+				flags:  binary.LittleEndian.Uint32(entries[28:]),
+			})
+		case 2: // Processor Local x2APIC Affinity
+			require.Equal(t, byte(24), entries[1])
+			require.Equal(t, uint32(1), binary.LittleEndian.Uint32(entries[12:]), "flags")
+			cpus = append(cpus, testSRATCPU{
+				x2APIC: true,
+				apicID: binary.LittleEndian.Uint32(entries[8:]),
+				node:   binary.LittleEndian.Uint32(entries[4:]),
+			})
+		default:
+			require.Failf(t, "unexpected SRAT structure", "type %d", entries[0])
+		}
+	}
+	return cpus, memory
+}
+
+func TestGenerateTablesQemuSRAT(t *testing.T) {
+	const (
+		MiB = 1 << 20
+		GiB = 1 << 30
+	)
+	tests := []struct {
+		name   string
+		cpus   []testSRATCPU
+		memory []testSRATMemory
+	}{
+		{
+			// Node 0 is split around the 640 KiB hole, node 1 lies above 4 GiB. An empty structure
+			// pads the memory affinity structures to the number of nodes plus two.
+			"two-nodes",
+			[]testSRATCPU{{apicID: 0, node: 0}, {apicID: 1, node: 0}, {apicID: 2, node: 1}, {apicID: 3, node: 1}},
+			[]testSRATMemory{
+				{0, 0xa0000, 0, 1},
+				{0x100000, 2*GiB - 0x100000, 0, 1},
+				{4 * GiB, 2 * GiB, 1, 1},
+				{0, 0, 0, 0},
+			},
+		},
+		{
+			// Node 1 continues at 4 GiB, node 2 follows it.
+			"three-nodes-2816M",
+			[]testSRATCPU{
+				{apicID: 0, node: 0}, {apicID: 1, node: 0}, {apicID: 2, node: 1},
+				{apicID: 3, node: 1}, {apicID: 4, node: 2}, {apicID: 5, node: 2},
+			},
+			[]testSRATMemory{
+				{0, 0xa0000, 0, 1},
+				{0x100000, 512*MiB - 0x100000, 0, 1},
+				{512 * MiB, 1536 * MiB, 1, 1},
+				{4 * GiB, 256 * MiB, 1, 1},
+				{4*GiB + 256*MiB, 512 * MiB, 2, 1},
+			},
+		},
+		{
+			"three-nodes-cpus",
+			[]testSRATCPU{
+				{apicID: 0, node: 0}, {apicID: 1, node: 0}, {apicID: 2, node: 2},
+				{apicID: 3, node: 2}, {apicID: 4, node: 1}, {apicID: 5, node: 1},
+			},
+			[]testSRATMemory{
+				{0, 0xa0000, 0, 1},
+				{0x100000, 1*GiB - 0x100000, 0, 1},
+				{1 * GiB, 1 * GiB, 1, 1},
+				{4 * GiB, 3 * GiB, 1, 1},
+				{7 * GiB, 3 * GiB, 2, 1},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cpus, memory := testSRAT(t, testNUMAConfigs[tt.name])
+			require.Equal(t, tt.cpus, cpus)
+			require.Equal(t, tt.memory, memory)
+		})
+	}
+
+	t.Run("x2APIC", func(t *testing.T) {
+		// The 150 cores of a socket take 8 bits of the APIC ID, all CPUs of socket 1 have an
+		// x2APIC ID.
+		cpus, _ := testSRAT(t, testNUMAConfigs["two-nodes-x2apic"])
+		require.Len(t, cpus, 300)
+		for i, cpu := range cpus {
+			socket := uint32(i / 150)
+			require.Equal(t, testSRATCPU{x2APIC: socket == 1, apicID: socket<<8 | uint32(i%150), node: socket}, cpu)
+		}
+	})
+
+	t.Run("640 KiB hole", func(t *testing.T) {
+		// A first node ending between 640 KiB and 1 MiB doesn't continue above the hole, the next
+		// node starts at 1 MiB instead. As in QEMU, this shifts the following memory up by the
+		// part of the hole the first node didn't cover.
+		opts := Options{MemorySize: 2 * GiB, CPUCount: 1, NUMA: &NUMAConfig{
+			Nodes: []NUMANode{{MemorySize: 768 << 10}, {MemorySize: 2*GiB - 768<<10}},
+		}}
+		_, memory := testSRAT(t, opts)
+		require.Equal(t, []testSRATMemory{
+			{0, 0xa0000, 0, 1},
+			{0x100000, 2*GiB - 0x100000, 1, 1},
+			{4 * GiB, 256 << 10, 1, 1},
+			{0, 0, 0, 0},
+		}, memory)
+	})
+}
+
+func TestGenerateTablesQemuSLIT(t *testing.T) {
+	blob, _, _, err := GenerateTablesQemu(testNUMAConfigs["three-nodes-2816M"])
+	require.NoError(t, err)
+	tables := testACPITables(t, blob)
+	require.Equal(t, []byte{
+		3, 0, 0, 0, 0, 0, 0, 0, // Number of localities.
+		10, 21, 31,
+		21, 10, 21,
+		31, 21, 10,
+	}, tables["SLIT"][36:])
+
+	// The SRAT and SLIT are listed in the RSDT between the MADT and the MCFG.
+	rsdt := tables["RSDT"][36:]
+	var signatures []string
+	for i := 0; i < len(rsdt); i += 4 {
+		offset := binary.LittleEndian.Uint32(rsdt[i:])
+		signatures = append(signatures, string(blob[offset:offset+4]))
+	}
+	require.Equal(t, []string{"FACP", "APIC", "SRAT", "SLIT", "MCFG", "WAET"}, signatures)
+
+	blob, _, _, err = GenerateTablesQemu(testNUMAConfigs["two-nodes-no-distances"])
+	require.NoError(t, err)
+	tables = testACPITables(t, blob)
+	require.Contains(t, tables, "SRAT")
+	require.NotContains(t, tables, "SLIT")
+}
+
+// TestGenerateTablesQemuCPUProximity checks the _PXM objects of the CPUs in the DSDT.
+func TestGenerateTablesQemuCPUProximity(t *testing.T) {
+	blob, _, _, err := GenerateTablesQemu(testNUMAConfigs["three-nodes-cpus"])
+	require.NoError(t, err)
+	dsdt := testACPITables(t, blob)["DSDT"]
+	// Name (_PXM, Zero), Name (_PXM, 0x02) and Name (_PXM, One) for two CPUs each.
+	require.Equal(t, 6, bytes.Count(dsdt, []byte("_PXM")))
+	require.Equal(t, 2, bytes.Count(dsdt, []byte("\x08_PXM\x00")))
+	require.Equal(t, 2, bytes.Count(dsdt, []byte("\x08_PXM\x01")))
+	require.Equal(t, 2, bytes.Count(dsdt, []byte("\x08_PXM\x0a\x02")))
+
+	blob, _, _, err = GenerateTablesQemu(Options{MemorySize: 8 << 30, CPUCount: 6})
+	require.NoError(t, err)
+	tables := testACPITables(t, blob)
+	require.NotContains(t, string(tables["DSDT"]), "_PXM")
+	require.NotContains(t, tables, "SRAT")
+}
+
+func TestNUMACPUNodes(t *testing.T) {
+	// Six CPUs in three sockets.
+	sockets := []uint32{0, 0, 1, 1, 2, 2}
+	nodes := func(cpus ...[]uint32) []NUMANode {
+		var n []NUMANode
+		for _, c := range cpus {
+			n = append(n, NUMANode{MemorySize: 1 << 30, CPUs: c})
+		}
+		return n
+	}
+	tests := []struct {
+		name   string
+		config NUMAConfig
+		want   []uint32
+	}{
+		{"by socket", NUMAConfig{Nodes: nodes(nil, nil)}, []uint32{0, 0, 1, 1, 0, 0}},
+		{"single node", NUMAConfig{Nodes: []NUMANode{{MemorySize: 2 << 30}}}, []uint32{0, 0, 0, 0, 0, 0}},
+		{"explicit", NUMAConfig{Nodes: nodes([]uint32{5, 0}, []uint32{1, 2, 3, 4})}, []uint32{0, 1, 1, 1, 1, 0}},
+		{"partly explicit", NUMAConfig{Nodes: nodes(nil, []uint32{0})}, []uint32{1, 0, 1, 1, 0, 0}},
+		{"distances", NUMAConfig{Nodes: nodes(nil, nil), Distances: [][]uint8{{10, 11}, {255, 10}}}, []uint32{0, 0, 1, 1, 0, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.config.cpuNodes(2<<30, sockets)
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestNUMACPUNodesErrors(t *testing.T) {
+	sockets := []uint32{0, 0, 1, 1}
+	twoNodes := []NUMANode{{MemorySize: 1 << 30}, {MemorySize: 1 << 30}}
+	for name, config := range map[string]NUMAConfig{
+		"no nodes":                {},
+		"too many nodes":          {Nodes: make([]NUMANode, maxNUMANodes+1)},
+		"unknown CPU":             {Nodes: []NUMANode{{MemorySize: 1 << 30, CPUs: []uint32{4}}, {MemorySize: 1 << 30}}},
+		"CPU on two nodes":        {Nodes: []NUMANode{{MemorySize: 1 << 30, CPUs: []uint32{1}}, {MemorySize: 1 << 30, CPUs: []uint32{2, 1}}}},
+		"CPU twice on a node":     {Nodes: []NUMANode{{MemorySize: 1 << 30, CPUs: []uint32{3, 3}}, {MemorySize: 1 << 30}}},
+		"memory too small":        {Nodes: []NUMANode{{MemorySize: 1 << 30}, {MemorySize: 512 << 20}}},
+		"memory too large":        {Nodes: []NUMANode{{MemorySize: 1 << 30}, {MemorySize: 1 << 30}, {MemorySize: 8 << 10}}},
+		"missing distance row":    {Nodes: twoNodes, Distances: [][]uint8{{10, 20}}},
+		"short distance row":      {Nodes: twoNodes, Distances: [][]uint8{{10, 20}, {20}}},
+		"empty distance matrix":   {Nodes: twoNodes, Distances: [][]uint8{}},
+		"local distance":          {Nodes: twoNodes, Distances: [][]uint8{{10, 20}, {20, 11}}},
+		"remote distance":         {Nodes: twoNodes, Distances: [][]uint8{{10, 20}, {10, 10}}},
+		"remote distance too low": {Nodes: twoNodes, Distances: [][]uint8{{10, 9}, {20, 10}}},
+	} {
+		_, err := config.cpuNodes(2<<30, sockets)
+		require.ErrorIs(t, err, ErrUnsupportedConfig, name)
+
+		// The same errors are reported for the machine.
+		_, _, _, err = GenerateTablesQemu(Options{MemorySize: 2 << 30, CPUCount: 4, Sockets: 2, NUMA: &config})
+		require.ErrorIs(t, err, ErrUnsupportedConfig, name)
+	}
+}
+
+// TestMeasureNUMA checks that the NUMA topology only changes RTMR0, through the ACPI tables.
+func TestMeasureNUMA(t *testing.T) {
+	c := readTestMeasurements(t)[1]
+	opts := c.options()
+	want, err := Measure(opts)
+	require.NoError(t, err)
+
+	opts.NUMA = &NUMAConfig{Nodes: []NUMANode{{MemorySize: 3 << 30}, {MemorySize: 1 << 30}}}
+	m, err := Measure(opts)
+	require.NoError(t, err)
+	require.NotEqual(t, want.RTMR0, m.RTMR0)
+	require.Equal(t, []Register{want.MRTD, want.RTMR1, want.RTMR2}, []Register{m.MRTD, m.RTMR1, m.RTMR2})
+	// The TD HOB is built from the e820 table, which doesn't distinguish nodes.
+	require.Equal(t, want.EventLog[0], m.EventLog[0])
+
+	opts.NUMA.Nodes[1].MemorySize = 2 << 30
+	_, err = Measure(opts)
+	require.ErrorIs(t, err, ErrUnsupportedConfig)
+}
@@ -0,0 +1,149 @@
+package tdxmeasure
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	tdxQuoteHeaderSize  = 48
+	tdxTeeType          = 0x00000081
+	tdReportBody10Size  = 584
+	tdReportBody15Size  = 648
+	tdxQuoteBodyTdx10   = 2
+	tdxQuoteBodyTdx15   = 3
+	tdxQuoteBodyDescLen = 6
+)
+
+// ReportBody is the TD report body of a TDX quote.
+type ReportBody struct {
+	TeeTcbSvn      [16]byte
+	MrSeam         Register
+	MrSignerSeam   Register
+	SeamAttributes [8]byte
+	TdAttributes   [8]byte
+	Xfam           [8]byte
+	MRTD           Register
+	MrConfigID     Register
+	MrOwner        Register
+	MrOwnerConfig  Register
+	RTMR0          Register
+	RTMR1          Register
+	RTMR2          Register
+	RTMR3          Register
+	ReportData     [64]byte
+
+	// TeeTcbSvn2 and MrServiceTd are only present in TD 1.5 report bodies and are zero otherwise.
+	TeeTcbSvn2  [16]byte
+	MrServiceTd Register
+}
+
+// Quote is a TDX quote. Only the header and the TD report body are parsed, the signature data
+// is left as is and is not verified.
+type Quote struct {
+	Version            uint16
+	AttestationKeyType uint16
+	TeeType            uint32
+	QeVendorID         [16]byte
+	UserData           [20]byte
+
+	Report ReportBody
+
+	SignatureData []byte
+}
+
+// ParseQuote parses a raw version 4 or version 5 TDX quote.
+//
+// See "Intel TDX DCAP Quote Generation Library and Quote Verification Library" for the layout.
+func ParseQuote(data []byte) (*Quote, error) {
+	if len(data) < tdxQuoteHeaderSize {
+		return nil, fmt.Errorf("%w: quote is too short: need at least %d bytes, got %d", ErrInvalidQuote, tdxQuoteHeaderSize, len(data))
+	}
+
+	// Parse the quote header:
+	//
+	//   2 byte version
+	//   2 byte attestation key type
+	//   4 byte TEE type
+	//   4 byte reserved
+	//   16 byte QE vendor ID
+	//   20 byte user data
+	//
+	q := &Quote{
+		Version:            binary.LittleEndian.Uint16(data[0:2]),
+		AttestationKeyType: binary.LittleEndian.Uint16(data[2:4]),
+		TeeType:            binary.LittleEndian.Uint32(data[4:8]),
+	}
+	copy(q.QeVendorID[:], data[12:28])
+	copy(q.UserData[:], data[28:48])
+	if q.TeeType != tdxTeeType {
+		return nil, fmt.Errorf("%w: not a TDX quote (TEE type 0x%x)", ErrInvalidQuote, q.TeeType)
+	}
+
+	offset := tdxQuoteHeaderSize
+	bodySize := tdReportBody10Size
+	switch q.Version {
+	case 4:
+	case 5:
+		// Version 5 quotes carry a body descriptor with the body type and size.
+		if len(data) < offset+tdxQuoteBodyDescLen {
+			return nil, fmt.Errorf("%w: quote is too short for the body descriptor", ErrInvalidQuote)
+		}
+		bodyType := binary.LittleEndian.Uint16(data[offset : offset+2])
+		bodySize = int(binary.LittleEndian.Uint32(data[offset+2 : offset+6]))
+		offset += tdxQuoteBodyDescLen
+
+		switch {
+		case bodyType == tdxQuoteBodyTdx10 && bodySize == tdReportBody10Size:
+		case bodyType == tdxQuoteBodyTdx15 && bodySize == tdReportBody15Size:
+		default:
+			return nil, fmt.Errorf("%w: unsupported quote body type %d with size %d", ErrInvalidQuote, bodyType, bodySize)
+		}
+	default:
+		return nil, fmt.Errorf("%w: unsupported quote version %d", ErrInvalidQuote, q.Version)
+	}
+
+	if len(data) < offset+bodySize {
+		return nil, fmt.Errorf("%w: quote is too short for the TD report body: need %d bytes, got %d", ErrInvalidQuote, offset+bodySize, len(data))
+	}
+	body := data[offset : offset+bodySize]
+	offset += bodySize
+
+	// Split the TD report body into its fields.
+	field := func(f []byte) {
+		copy(f, body)
+		body = body[len(f):]
+	}
+	r := &q.Report
+	field(r.TeeTcbSvn[:])
+	field(r.MrSeam[:])
+	field(r.MrSignerSeam[:])
+	field(r.SeamAttributes[:])
+	field(r.TdAttributes[:])
+	field(r.Xfam[:])
+	field(r.MRTD[:])
+	field(r.MrConfigID[:])
+	field(r.MrOwner[:])
+	field(r.MrOwnerConfig[:])
+	field(r.RTMR0[:])
+	field(r.RTMR1[:])
+	field(r.RTMR2[:])
+	field(r.RTMR3[:])
+	field(r.ReportData[:])
+	if bodySize == tdReportBody15Size {
+		field(r.TeeTcbSvn2[:])
+		field(r.MrServiceTd[:])
+	}
+
+	// The signature data follows the body, prefixed by its length.
+	if len(data) >= offset+4 {
+		sigLen := int(binary.LittleEndian.Uint32(data[offset : offset+4]))
+		offset += 4
+		if len(data) < offset+sigLen {
+			return nil, fmt.Errorf("%w: quote signature data is truncated: need %d bytes, got %d", ErrInvalidQuote, sigLen, len(data)-offset)
+		}
+		q.SignatureData = data[offset : offset+sigLen]
+	}
+
+	return q, nil
+}
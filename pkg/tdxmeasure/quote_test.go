@@ -0,0 +1,111 @@
+package tdxmeasure
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testQuote builds a quote of the given version with the body of the given type, if it has a
+// descriptor, whose bytes are their offset in the body, followed by the signature data.
+func testQuote(version uint16, bodyType uint16, bodySize int, sigData []byte) []byte {
+	quote := make([]byte, tdxQuoteHeaderSize)
+	binary.LittleEndian.PutUint16(quote[0:], version)
+	binary.LittleEndian.PutUint16(quote[2:], 2)
+	binary.LittleEndian.PutUint32(quote[4:], tdxTeeType)
+	copy(quote[12:28], bytes.Repeat([]byte{0xee}, 16))
+	copy(quote[28:48], bytes.Repeat([]byte{0xdd}, 20))
+	if version == 5 {
+		quote = binary.LittleEndian.AppendUint16(quote, bodyType)
+		quote = binary.LittleEndian.AppendUint32(quote, uint32(bodySize))
+	}
+	for i := 0; i < bodySize; i++ {
+		quote = append(quote, byte(i))
+	}
+	if sigData != nil {
+		quote = binary.LittleEndian.AppendUint32(quote, uint32(len(sigData)))
+		quote = append(quote, sigData...)
+	}
+	return quote
+}
+
+// testReportField returns the bytes of the field at the given offset of a test quote body.
+func testReportField(offset, size int) []byte {
+	field := make([]byte, size)
+	for i := range field {
+		field[i] = byte(offset + i)
+	}
+	return field
+}
+
+func TestParseQuote(t *testing.T) {
+	sigData := []byte{1, 2, 3, 4, 5}
+	tests := []struct {
+		name  string
+		quote []byte
+		td15  bool
+	}{
+		{"v4", testQuote(4, 0, tdReportBody10Size, sigData), false},
+		{"v5 TD 1.0", testQuote(5, tdxQuoteBodyTdx10, tdReportBody10Size, sigData), false},
+		{"v5 TD 1.5", testQuote(5, tdxQuoteBodyTdx15, tdReportBody15Size, sigData), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, err := ParseQuote(tt.quote)
+			require.NoError(t, err)
+			require.Equal(t, uint16(2), q.AttestationKeyType)
+			require.Equal(t, uint32(tdxTeeType), q.TeeType)
+			require.Equal(t, bytes.Repeat([]byte{0xee}, 16), q.QeVendorID[:])
+			require.Equal(t, bytes.Repeat([]byte{0xdd}, 20), q.UserData[:])
+			require.Equal(t, sigData, q.SignatureData)
+
+			r := q.Report
+			require.Equal(t, testReportField(0, 16), r.TeeTcbSvn[:])
+			require.Equal(t, testReportField(16, 48), r.MrSeam[:])
+			require.Equal(t, testReportField(120, 8), r.TdAttributes[:])
+			require.Equal(t, testReportField(128, 8), r.Xfam[:])
+			require.Equal(t, testReportField(136, 48), r.MRTD[:])
+			require.Equal(t, testReportField(328, 48), r.RTMR0[:])
+			require.Equal(t, testReportField(376, 48), r.RTMR1[:])
+			require.Equal(t, testReportField(424, 48), r.RTMR2[:])
+			require.Equal(t, testReportField(472, 48), r.RTMR3[:])
+			require.Equal(t, testReportField(520, 64), r.ReportData[:])
+			if tt.td15 {
+				require.Equal(t, testReportField(584, 16), r.TeeTcbSvn2[:])
+				require.Equal(t, testReportField(600, 48), r.MrServiceTd[:])
+			} else {
+				require.Equal(t, [16]byte{}, r.TeeTcbSvn2)
+				require.Equal(t, Register{}, r.MrServiceTd)
+			}
+		})
+	}
+}
+
+func TestParseQuoteWithoutSignature(t *testing.T) {
+	q, err := ParseQuote(testQuote(4, 0, tdReportBody10Size, nil))
+	require.NoError(t, err)
+	require.Nil(t, q.SignatureData)
+}
+
+func TestParseQuoteErrors(t *testing.T) {
+	sgx := testQuote(4, 0, tdReportBody10Size, nil)
+	binary.LittleEndian.PutUint32(sgx[4:], 0)
+	truncatedSig := testQuote(4, 0, tdReportBody10Size, []byte{1, 2, 3, 4})
+	for name, quote := range map[string][]byte{
+		"empty":                {},
+		"short header":         make([]byte, tdxQuoteHeaderSize-1),
+		"SGX quote":            sgx,
+		"version 3":            testQuote(3, 0, tdReportBody10Size, nil),
+		"truncated body":       testQuote(4, 0, tdReportBody10Size-1, nil),
+		"missing descriptor":   testQuote(5, 0, 0, nil)[:tdxQuoteHeaderSize+4],
+		"unknown body type":    testQuote(5, 1, tdReportBody10Size, nil),
+		"mismatched body size": testQuote(5, tdxQuoteBodyTdx15, tdReportBody10Size, nil),
+		"truncated v5 body":    testQuote(5, tdxQuoteBodyTdx15, tdReportBody15Size, nil)[:tdxQuoteHeaderSize+tdxQuoteBodyDescLen+tdReportBody10Size],
+		"truncated signature":  truncatedSig[:len(truncatedSig)-1],
+	} {
+		_, err := ParseQuote(quote)
+		require.ErrorIs(t, err, ErrInvalidQuote, name)
+	}
+}
@@ -0,0 +1,92 @@
+package tdxmeasure
+
+import (
+	"bytes"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+)
+
+// EvDstackRuntime is the event type of the events dstack guests extend into RTMR3 at runtime.
+const EvDstackRuntime EventType = 0x08000001
+
+// RuntimeEvent is an application-level event extended into RTMR3 by the dstack guest, such as
+// "app-id", "compose-hash", "instance-id" or "key-provider".
+type RuntimeEvent struct {
+	Name    string
+	Payload []byte
+}
+
+// Digest returns the digest the event is extended with: the SHA384 hash of the event type (32-bit
+// little-endian), the name and the payload, separated by colons.
+func (e RuntimeEvent) Digest() [48]byte {
+	h := sha512.New384()
+	binary.Write(h, binary.LittleEndian, uint32(EvDstackRuntime))
+	h.Write([]byte(":"))
+	h.Write([]byte(e.Name))
+	h.Write([]byte(":"))
+	h.Write(e.Payload)
+	var digest [48]byte
+	h.Sum(digest[:0])
+	return digest
+}
+
+// measureRuntimeEvents returns the RTMR3 event log of the runtime events.
+func measureRuntimeEvents(events []RuntimeEvent) []Event {
+	log := make([]Event, 0, len(events))
+	for _, e := range events {
+		digest := e.Digest()
+		log = append(log, newEvent(3, EvDstackRuntime, e.Name, digest[:]))
+	}
+	return log
+}
+
+// MeasureRuntimeEvents replays the runtime events into RTMR3 and returns its value.
+func MeasureRuntimeEvents(events []RuntimeEvent) Register {
+	return measureLog(measureRuntimeEvents(events))
+}
+
+// runtimeEventLogEntry is an entry of the JSON event log reported by the dstack guest agent.
+type runtimeEventLogEntry struct {
+	IMR          uint32    `json:"imr"`
+	EventType    EventType `json:"event_type"`
+	Digest       string    `json:"digest"`
+	Event        string    `json:"event"`
+	EventPayload string    `json:"event_payload"`
+}
+
+// ParseRuntimeEventLog parses the RTMR3 events of a JSON event log in the format reported by the
+// dstack guest agent: a list of objects with the register index ("imr"), "event_type", "digest",
+// "event" name and hex encoded "event_payload". Events of other registers are skipped. The digest
+// and event type are optional, but must match the event if present.
+func ParseRuntimeEventLog(data []byte) ([]RuntimeEvent, error) {
+	var entries []runtimeEventLogEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidEventLog, err)
+	}
+
+	var events []RuntimeEvent
+	for i, entry := range entries {
+		if entry.IMR != 3 {
+			continue
+		}
+		if entry.EventType != 0 && entry.EventType != EvDstackRuntime {
+			return nil, fmt.Errorf("%w: event %d has type %s", ErrInvalidEventLog, i, entry.EventType)
+		}
+		payload, err := hex.DecodeString(entry.EventPayload)
+		if err != nil {
+			return nil, fmt.Errorf("%w: event %d has an invalid payload: %w", ErrInvalidEventLog, i, err)
+		}
+		event := RuntimeEvent{Name: entry.Event, Payload: payload}
+		if entry.Digest != "" {
+			digest := event.Digest()
+			if logged, err := hex.DecodeString(entry.Digest); err != nil || !bytes.Equal(logged, digest[:]) {
+				return nil, fmt.Errorf("%w: digest of event %d (%s) doesn't match its payload", ErrInvalidEventLog, i, entry.Event)
+			}
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
@@ -0,0 +1,77 @@
+package tdxmeasure
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testAppIDDigest       = "aa89600f6438e7c3d46e86fb9a3600120c01fc21fcb855a928d26efab202750856302f2cb202822e6e6951ded19d1829"
+	testComposeHashDigest = "27cbb1d7fc3e1d2331f13ed765ca6ae815ad7ed50ea9c76b71b29e805e0fa26c04911e3547686044a1603da757452744"
+)
+
+func TestRuntimeEventDigest(t *testing.T) {
+	digest := RuntimeEvent{Name: "app-id", Payload: []byte{1, 2}}.Digest()
+	require.Equal(t, testAppIDDigest, hex.EncodeToString(digest[:]))
+	digest = RuntimeEvent{Name: "compose-hash"}.Digest()
+	require.Equal(t, testComposeHashDigest, hex.EncodeToString(digest[:]))
+}
+
+func TestMeasureRuntimeEvents(t *testing.T) {
+	require.Equal(t, Register{}, MeasureRuntimeEvents(nil))
+	rtmr3 := MeasureRuntimeEvents([]RuntimeEvent{
+		{Name: "app-id", Payload: []byte{1, 2}},
+		{Name: "compose-hash"},
+	})
+	require.Equal(t, "f79eb7727102c967b851ae1f4c88f0fe6490f72f50ed9762da21a67d9eadd6c1c85e8ac4d1d075af68f6405e93be31b7", rtmr3.String())
+}
+
+func TestParseRuntimeEventLog(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    []RuntimeEvent
+		wantErr bool
+	}{
+		{
+			name: "guest agent log",
+			input: `[
+  {"imr": 0, "event_type": 2147483649, "digest": "00", "event": "", "event_payload": "zz"},
+  {"imr": 3, "event_type": 134217729, "digest": "` + testAppIDDigest + `", "event": "app-id", "event_payload": "0102"},
+  {"imr": 3, "event_type": 134217729, "digest": "` + testComposeHashDigest + `", "event": "compose-hash", "event_payload": ""}
+]`,
+			want: []RuntimeEvent{{Name: "app-id", Payload: []byte{1, 2}}, {Name: "compose-hash", Payload: []byte{}}},
+		},
+		{
+			name:  "without digests and types",
+			input: `[{"imr": 3, "event": "app-id", "event_payload": "0102"}]`,
+			want:  []RuntimeEvent{{Name: "app-id", Payload: []byte{1, 2}}},
+		},
+		{
+			name:  "upper case digest",
+			input: `[{"imr": 3, "event": "compose-hash", "digest": "` + strings.ToUpper(testComposeHashDigest) + `"}]`,
+			want:  []RuntimeEvent{{Name: "compose-hash", Payload: []byte{}}},
+		},
+		{name: "empty", input: `[]`},
+		{name: "not a list", input: `{"imr": 3}`, wantErr: true},
+		{name: "invalid JSON", input: `[{"imr": 3,}]`, wantErr: true},
+		{name: "wrong type", input: `[{"imr": 3, "event_type": 4, "event": "app-id", "event_payload": "0102"}]`, wantErr: true},
+		{name: "invalid payload", input: `[{"imr": 3, "event": "app-id", "event_payload": "012"}]`, wantErr: true},
+		{name: "wrong digest", input: `[{"imr": 3, "event": "app-id", "event_payload": "0103", "digest": "` + testAppIDDigest + `"}]`, wantErr: true},
+		{name: "invalid digest", input: `[{"imr": 3, "event": "app-id", "event_payload": "0102", "digest": "xyz"}]`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events, err := ParseRuntimeEventLog([]byte(tt.input))
+			if tt.wantErr {
+				require.ErrorIs(t, err, ErrInvalidEventLog)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, events)
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package tdxmeasure
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"runtime"
+	"slices"
+	"sync"
+)
+
+// SearchRange is the range of machine configurations swept by SearchRTMR0.
+type SearchRange struct {
+	// MinMemory, MaxMemory and MemoryStep describe the memory sizes in bytes.
+	MinMemory  uint64
+	MaxMemory  uint64
+	MemoryStep uint64
+	// MaxCPUCount is the largest CPU count, the counts from 1 to MaxCPUCount are tried.
+	MaxCPUCount uint32
+}
+
+// ConfigMatch is a machine configuration found by SearchRTMR0.
+type ConfigMatch struct {
+	MemorySize uint64
+	CPUCount   uint32
+}
+
+// SearchRTMR0 returns the memory sizes and CPU counts of the range for which the TD described by
+// opts has the given RTMR0, sorted by memory size and CPU count.
+//
+// The memory size and CPU count of opts are ignored, all other inputs are fixed. The firmware and
+// Secure Boot inputs are measured once, the TD HOB is regenerated for each memory size and the
+// ACPI tables for each configuration. Configurations QEMU doesn't support, e.g. memory sizes too
+// small for the firmware or CPU counts that can't be split into the sockets, are skipped.
+func SearchRTMR0(opts Options, rtmr0 Register, r SearchRange) ([]ConfigMatch, error) {
+	if r.MinMemory == 0 || r.MinMemory > r.MaxMemory || r.MemoryStep == 0 && r.MinMemory != r.MaxMemory ||
+		r.MinMemory%ramAlignment != 0 || r.MemoryStep%ramAlignment != 0 {
+		return nil, fmt.Errorf("invalid memory range %#x-%#x (step %#x)", r.MinMemory, r.MaxMemory, r.MemoryStep)
+	}
+	if err := checkCPUCount(r.MaxCPUCount); err != nil {
+		return nil, err
+	}
+	if opts.NUMA != nil {
+		return nil, fmt.Errorf("%w: the NUMA topology determines the memory size", ErrUnsupportedConfig)
+	}
+
+	tdvfMeta, err := parseTdvfMetadata(opts.Firmware)
+	if err != nil {
+		return nil, err
+	}
+	measurer, err := newRTMR0Measurer(&opts, tdvfMeta)
+	if err != nil {
+		return nil, err
+	}
+
+	var memorySizes []uint64
+	for size := r.MinMemory; size <= r.MaxMemory; size += r.MemoryStep {
+		memorySizes = append(memorySizes, size)
+		if r.MemoryStep == 0 || size+r.MemoryStep < size {
+			break
+		}
+	}
+
+	// Each worker sweeps the CPU counts of one memory size at a time.
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		matches  []ConfigMatch
+		firstErr error
+	)
+	sizes := make(chan uint64)
+	for range runtime.GOMAXPROCS(0) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for size := range sizes {
+				found, err := measurer.searchCPUCounts(opts, size, rtmr0, r.MaxCPUCount)
+				mu.Lock()
+				matches = append(matches, found...)
+				if err != nil && firstErr == nil {
+					firstErr = err
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, size := range memorySizes {
+		sizes <- size
+	}
+	close(sizes)
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+
+	slices.SortFunc(matches, func(a, b ConfigMatch) int {
+		return cmp.Or(cmp.Compare(a.MemorySize, b.MemorySize), cmp.Compare(a.CPUCount, b.CPUCount))
+	})
+	return matches, nil
+}
+
+// searchCPUCounts returns the configurations with the given memory size and RTMR0.
+func (m *rtmr0Measurer) searchCPUCounts(opts Options, memorySize uint64, rtmr0 Register, maxCPUCount uint32) ([]ConfigMatch, error) {
+	opts.MemorySize = memorySize
+	mem, err := newMemoryMap(&opts)
+	if errors.Is(err, ErrUnsupportedConfig) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	// The TD HOB doesn't depend on the CPU count, only the ACPI tables are regenerated for each.
+	tdHobHash, err := measureTdxQemuTdHob(mem, m.tdvfMeta)
+	if errors.Is(err, ErrUnsupportedConfig) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var matches []ConfigMatch
+	for cpu := uint32(1); cpu <= maxCPUCount; cpu++ {
+		opts.CPUCount = cpu
+		log, err := m.eventLog(&opts, tdHobHash)
+		if errors.Is(err, ErrUnsupportedConfig) {
+			continue
+		} else if err != nil {
+			return matches, err
+		}
+		if measureLog(log) == rtmr0 {
+			matches = append(matches, ConfigMatch{MemorySize: memorySize, CPUCount: cpu})
+		}
+	}
+	return matches, nil
+}
@@ -0,0 +1,87 @@
+package tdxmeasure
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testRegister decodes a hex register value.
+func testRegister(t *testing.T, s string) Register {
+	var r Register
+	b, err := hex.DecodeString(s)
+	require.NoError(t, err)
+	require.Len(t, b, len(r))
+	copy(r[:], b)
+	return r
+}
+
+func TestSearchRTMR0(t *testing.T) {
+	for _, c := range readTestMeasurements(t)[:3] {
+		rtmr0 := testRegister(t, c.rtmr0)
+		opts := c.options()
+		// The memory size and CPU count of the options are ignored.
+		opts.MemorySize, opts.CPUCount = 1<<30, 1
+
+		matches, err := SearchRTMR0(opts, rtmr0, SearchRange{
+			MinMemory:   1536 << 20,
+			MaxMemory:   4608 << 20,
+			MemoryStep:  256 << 20,
+			MaxCPUCount: 33,
+		})
+		require.NoError(t, err)
+		require.Equal(t, []ConfigMatch{{MemorySize: c.memory << 20, CPUCount: c.cpu}}, matches, "%+v", c)
+	}
+}
+
+func TestSearchRTMR0NoMatch(t *testing.T) {
+	c := readTestMeasurements(t)[1]
+	rtmr0 := testRegister(t, c.rtmr0)
+
+	// The matching configuration is just outside of the range.
+	matches, err := SearchRTMR0(c.options(), rtmr0, SearchRange{
+		MinMemory:   512 << 20,
+		MaxMemory:   3584 << 20,
+		MemoryStep:  512 << 20,
+		MaxCPUCount: 16,
+	})
+	require.NoError(t, err)
+	require.Empty(t, matches)
+	matches, err = SearchRTMR0(c.options(), rtmr0, SearchRange{
+		MinMemory:   c.memory << 20,
+		MaxMemory:   c.memory << 20,
+		MaxCPUCount: c.cpu - 1,
+	})
+	require.NoError(t, err)
+	require.Empty(t, matches)
+}
+
+func TestSearchRTMR0Errors(t *testing.T) {
+	opts := readTestMeasurements(t)[0].options()
+	valid := SearchRange{MinMemory: 1 << 30, MaxMemory: 2 << 30, MemoryStep: 1 << 30, MaxCPUCount: 8}
+	for name, modify := range map[string]func(*SearchRange){
+		"no memory":        func(r *SearchRange) { r.MinMemory = 0 },
+		"inverted range":   func(r *SearchRange) { r.MinMemory, r.MaxMemory = r.MaxMemory, r.MinMemory },
+		"no step":          func(r *SearchRange) { r.MemoryStep = 0 },
+		"unaligned memory": func(r *SearchRange) { r.MinMemory += 4 << 10 },
+		"unaligned step":   func(r *SearchRange) { r.MemoryStep += 4 << 10 },
+		"no CPU":           func(r *SearchRange) { r.MaxCPUCount = 0 },
+		"too many CPUs":    func(r *SearchRange) { r.MaxCPUCount = MaxCPUCount + 1 },
+	} {
+		r := valid
+		modify(&r)
+		_, err := SearchRTMR0(opts, Register{}, r)
+		require.Error(t, err, name)
+	}
+
+	numa := opts
+	numa.NUMA = &NUMAConfig{Nodes: []NUMANode{{MemorySize: 1 << 30}, {MemorySize: 1 << 30}}}
+	_, err := SearchRTMR0(numa, Register{}, valid)
+	require.ErrorIs(t, err, ErrUnsupportedConfig)
+
+	noFirmware := opts
+	noFirmware.Firmware = nil
+	_, err = SearchRTMR0(noFirmware, Register{}, valid)
+	require.ErrorIs(t, err, ErrInvalidFirmware)
+}
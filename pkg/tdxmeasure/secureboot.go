@@ -0,0 +1,263 @@
+package tdxmeasure
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/foxboron/go-uefi/authenticode"
+	"github.com/foxboron/go-uefi/efi/signature"
+	"github.com/foxboron/go-uefi/efi/util"
+)
+
+const (
+	efiGlobalVariableGUID             = "8BE4DF61-93CA-11D2-AA0D-00E098032B8C"
+	efiImageSecurityDatabaseGUID      = "D719B2CB-3D3A-4596-A3BC-DAD00E67656F"
+	efiAuthenticatedVariableGUID      = "AAF32C78-947B-439A-A180-2E144EC37792"
+	efiVariableGUID                   = "DDCF3616-3275-4164-98B6-FE85707FFE7D"
+	efiTimeSize                       = 16
+	winCertificateUefiGUIDHeaderSize  = 24
+	varStoreHeaderSize                = 28
+	varStartID                        = 0x55aa
+	varAdded                          = 0x3f
+	varInDeletedTransition            = 0xfe
+	authenticatedVariableHeaderLength = 60
+	variableHeaderLength              = 32
+)
+
+// SecureBootConfig holds the Secure Boot key stores of the firmware. Each field contains the
+// variable data, i.e. a list of EFI_SIGNATURE_LISTs. Secure Boot is enabled when a PK is enrolled.
+type SecureBootConfig struct {
+	PK  []byte
+	KEK []byte
+	DB  []byte
+	DBX []byte
+}
+
+// Enabled returns true if Secure Boot is enabled.
+func (c *SecureBootConfig) Enabled() bool {
+	return c != nil && len(c.PK) > 0
+}
+
+// measureTdxSecureBootVariables returns the events measuring the Secure Boot variables. A nil
+// configuration measures all variables as empty, as done by firmware with Secure Boot disabled.
+func measureTdxSecureBootVariables(cfg *SecureBootConfig) []Event {
+	if cfg == nil {
+		cfg = &SecureBootConfig{}
+	}
+	var secureBoot []byte
+	if cfg.Enabled() {
+		secureBoot = []byte{0x01}
+	}
+	return []Event{
+		newEvent(0, EvEfiVariableDriverConfig, "SecureBoot", measureTdxEfiVariable(efiGlobalVariableGUID, "SecureBoot", secureBoot)),
+		newEvent(0, EvEfiVariableDriverConfig, "PK", measureTdxEfiVariable(efiGlobalVariableGUID, "PK", cfg.PK)),
+		newEvent(0, EvEfiVariableDriverConfig, "KEK", measureTdxEfiVariable(efiGlobalVariableGUID, "KEK", cfg.KEK)),
+		newEvent(0, EvEfiVariableDriverConfig, "db", measureTdxEfiVariable(efiImageSecurityDatabaseGUID, "db", cfg.DB)),
+		newEvent(0, EvEfiVariableDriverConfig, "dbx", measureTdxEfiVariable(efiImageSecurityDatabaseGUID, "dbx", cfg.DBX)),
+	}
+}
+
+// measureTdxKernelAuthority returns the EV_EFI_VARIABLE_AUTHORITY event logged when the kernel
+// image is verified against db. The event is extended into RTMR0 as it is measured into PCR7.
+func measureTdxKernelAuthority(kernel io.ReaderAt, kernelSize int64, cfg *SecureBootConfig) (Event, error) {
+	db, err := signature.ReadSignatureDatabase(bytes.NewReader(cfg.DB))
+	if err != nil {
+		return Event{}, fmt.Errorf("%w: failed to parse db: %w", ErrInvalidSecureBootKeys, err)
+	}
+	img, err := parsePEImage(kernel, kernelSize)
+	if err != nil {
+		return Event{}, fmt.Errorf("%w: failed to parse PE file: %w", ErrInvalidKernel, err)
+	}
+	kernelHash, err := img.hash(crypto.SHA256)
+	if err != nil {
+		return Event{}, fmt.Errorf("%w: failed to hash PE file: %w", ErrInvalidKernel, err)
+	}
+	signatures, err := kernelSignatures(img, kernelHash)
+	if err != nil {
+		return Event{}, fmt.Errorf("%w: %w", ErrInvalidKernel, err)
+	}
+
+	// Find the db entry that authorizes the kernel, which is either a certificate the image is
+	// signed with or the image hash itself.
+	for _, list := range db {
+		for _, sig := range list.Signatures {
+			switch {
+			case util.CmpEFIGUID(list.SignatureType, signature.CERT_X509_GUID):
+				cert, err := x509.ParseCertificate(sig.Data)
+				if err != nil {
+					return Event{}, fmt.Errorf("%w: failed to parse db certificate: %w", ErrInvalidSecureBootKeys, err)
+				}
+				if !signedBy(signatures, cert) {
+					continue
+				}
+			case util.CmpEFIGUID(list.SignatureType, signature.CERT_SHA256_GUID):
+				if !bytes.Equal(sig.Data, kernelHash) {
+					continue
+				}
+			default:
+				continue
+			}
+
+			// The event data is the UEFI_VARIABLE_DATA of db holding only the matching
+			// EFI_SIGNATURE_DATA, and its digest is taken over the whole event data.
+			varData := encodeUefiVariableData(efiImageSecurityDatabaseGUID, "db", sig.Bytes())
+			return newEvent(0, EvEfiVariableAuthority, "db", measureSha384(varData)), nil
+		}
+	}
+	return Event{}, ErrKernelNotAuthorized
+}
+
+// kernelSignatures returns the Authenticode signatures of the image that cover its hash.
+func kernelSignatures(img *peImage, kernelHash []byte) ([]*authenticode.Authenticode, error) {
+	certs, err := img.signatures()
+	if err != nil {
+		return nil, err
+	}
+	var signatures []*authenticode.Authenticode
+	for _, c := range certs {
+		sig, err := authenticode.ParseAuthenticode(c.Certificate)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse signature: %w", err)
+		}
+		if bytes.Equal(sig.Digest, kernelHash) {
+			signatures = append(signatures, sig)
+		}
+	}
+	return signatures, nil
+}
+
+// signedBy returns true if any of the signatures was made with the certificate.
+func signedBy(signatures []*authenticode.Authenticode, cert *x509.Certificate) bool {
+	for _, sig := range signatures {
+		if ok, _ := sig.Pkcs.Verify(cert); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// LoadSignatureListFile extracts the EFI_SIGNATURE_LISTs from the contents of an ESL file or an
+// authenticated variable (.auth) file.
+func LoadSignatureListFile(data []byte) ([]byte, error) {
+	if isSignatureList(data) {
+		return data, nil
+	}
+
+	// Authenticated variables are prefixed by an EFI_VARIABLE_AUTHENTICATION_2 header:
+	//
+	//   16 byte EFI_TIME
+	//   WIN_CERTIFICATE_UEFI_GUID, of which the first 4 bytes are its total length
+	//
+	if len(data) >= efiTimeSize+winCertificateUefiGUIDHeaderSize {
+		certLen := int(binary.LittleEndian.Uint32(data[efiTimeSize : efiTimeSize+4]))
+		if certLen >= winCertificateUefiGUIDHeaderSize && efiTimeSize+certLen <= len(data) {
+			esl := data[efiTimeSize+certLen:]
+			if isSignatureList(esl) {
+				return esl, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("%w: not an EFI signature list or authenticated variable", ErrInvalidSecureBootKeys)
+}
+
+// isSignatureList returns true if the data is a well-formed sequence of EFI_SIGNATURE_LISTs.
+func isSignatureList(data []byte) bool {
+	if len(data) == 0 {
+		return true
+	}
+	db, err := signature.ReadSignatureDatabase(bytes.NewReader(data))
+	return err == nil && len(db) > 0
+}
+
+// SecureBootConfigFromFirmware extracts the Secure Boot key stores from the UEFI variable store
+// in the CFV of the firmware.
+func SecureBootConfigFromFirmware(fw []byte) (*SecureBootConfig, error) {
+	meta, err := parseTdvfMetadata(fw)
+	if err != nil {
+		return nil, err
+	}
+	s := meta.findSection(tdvfSectionCfv)
+	if s == nil {
+		return nil, fmt.Errorf("%w: missing CFV section in TDVF metadata", ErrInvalidFirmware)
+	}
+	vars, err := parseVariableStore(fw[s.dataOffset : s.dataOffset+s.rawDataSize])
+	if err != nil {
+		return nil, err
+	}
+
+	globalGUID := string(encodeGUID(efiGlobalVariableGUID))
+	dbGUID := string(encodeGUID(efiImageSecurityDatabaseGUID))
+	return &SecureBootConfig{
+		PK:  vars[globalGUID+"PK"],
+		KEK: vars[globalGUID+"KEK"],
+		DB:  vars[dbGUID+"db"],
+		DBX: vars[dbGUID+"dbx"],
+	}, nil
+}
+
+// parseVariableStore parses a firmware volume holding an UEFI variable store and returns the
+// data of all valid variables, keyed by the encoded vendor GUID followed by the variable name.
+func parseVariableStore(fv []byte) (map[string][]byte, error) {
+	// The firmware volume header has the "_FVH" signature at offset 40 and its length at 48.
+	if len(fv) < 56 || string(fv[40:44]) != "_FVH" {
+		return nil, fmt.Errorf("%w: malformed variable store firmware volume", ErrInvalidFirmware)
+	}
+	offset := int(binary.LittleEndian.Uint16(fv[48:50]))
+
+	// Variable store header:
+	//
+	//   16 byte signature GUID (authenticated or plain variables)
+	//   4 byte size
+	//   1 byte format, 1 byte state, 6 bytes reserved
+	//
+	if len(fv) < offset+varStoreHeaderSize {
+		return nil, fmt.Errorf("%w: malformed variable store header", ErrInvalidFirmware)
+	}
+	var headerLen int
+	switch storeGUID := fv[offset : offset+16]; {
+	case bytes.Equal(storeGUID, encodeGUID(efiAuthenticatedVariableGUID)):
+		headerLen = authenticatedVariableHeaderLength
+	case bytes.Equal(storeGUID, encodeGUID(efiVariableGUID)):
+		headerLen = variableHeaderLength
+	default:
+		return nil, fmt.Errorf("%w: unknown variable store format", ErrInvalidFirmware)
+	}
+	storeEnd := offset + int(binary.LittleEndian.Uint32(fv[offset+16:offset+20]))
+	if storeEnd > len(fv) {
+		return nil, fmt.Errorf("%w: malformed variable store size", ErrInvalidFirmware)
+	}
+	offset += varStoreHeaderSize
+
+	// Walk the variables. The header starts with a 2 byte start ID, 1 byte state and ends with
+	// 4 byte name size, 4 byte data size and 16 byte vendor GUID; it is followed by the name and
+	// data and the next variable is 4 byte aligned.
+	vars := make(map[string][]byte)
+	for offset+headerLen <= storeEnd {
+		hdr := fv[offset : offset+headerLen]
+		if binary.LittleEndian.Uint16(hdr[0:2]) != varStartID {
+			break
+		}
+		state := hdr[2]
+		nameSize := int(binary.LittleEndian.Uint32(hdr[headerLen-24 : headerLen-20]))
+		dataSize := int(binary.LittleEndian.Uint32(hdr[headerLen-20 : headerLen-16]))
+		vendorGUID := hdr[headerLen-16:]
+
+		nameStart := offset + headerLen
+		dataStart := nameStart + nameSize
+		dataEnd := dataStart + dataSize
+		if nameSize < 0 || dataSize < 0 || dataEnd > storeEnd {
+			return nil, fmt.Errorf("%w: malformed variable at offset %d", ErrInvalidFirmware, offset)
+		}
+
+		if state == varAdded || state == varAdded&varInDeletedTransition {
+			name := decodeUTF16(fv[nameStart:dataStart])
+			vars[string(vendorGUID)+name] = fv[dataStart:dataEnd]
+		}
+		offset = (dataEnd + 3) &^ 3
+	}
+	return vars, nil
+}
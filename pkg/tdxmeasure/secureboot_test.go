@@ -0,0 +1,174 @@
+package tdxmeasure
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const efiCertSha256GUID = "c1c41626-504c-4092-aca9-41f936934328"
+
+// testSignatureList returns an EFI_SIGNATURE_LIST of SHA-256 hashes, each filled with one of the
+// given bytes.
+func testSignatureList(hashes ...byte) []byte {
+	const signatureSize = 16 + 32
+	esl := encodeGUID(efiCertSha256GUID)
+	esl = binary.LittleEndian.AppendUint32(esl, uint32(28+signatureSize*len(hashes)))
+	esl = binary.LittleEndian.AppendUint32(esl, 0)
+	esl = binary.LittleEndian.AppendUint32(esl, signatureSize)
+	for _, b := range hashes {
+		esl = append(esl, encodeGUID(efiGlobalVariableGUID)...)
+		esl = append(esl, bytes.Repeat([]byte{b}, 32)...)
+	}
+	return esl
+}
+
+func TestLoadSignatureListFile(t *testing.T) {
+	esl := append(testSignatureList(1, 2), testSignatureList(3)...)
+
+	// An authenticated variable with a WIN_CERTIFICATE_UEFI_GUID holding a 7 byte signature.
+	auth := make([]byte, efiTimeSize)
+	auth = binary.LittleEndian.AppendUint32(auth, winCertificateUefiGUIDHeaderSize+7)
+	auth = append(auth, make([]byte, winCertificateUefiGUIDHeaderSize-4+7)...)
+	auth = append(auth, esl...)
+
+	tests := []struct {
+		name    string
+		input   []byte
+		want    []byte
+		wantErr bool
+	}{
+		{name: "ESL", input: esl, want: esl},
+		{name: "empty", input: []byte{}, want: []byte{}},
+		{name: "auth", input: auth, want: esl},
+		{name: "auth without ESL", input: auth[:len(auth)-len(esl)], want: []byte{}},
+		{name: "truncated ESL", input: esl[:len(esl)-1], wantErr: true},
+		{name: "truncated auth", input: auth[:len(auth)-1], wantErr: true},
+		{name: "garbage", input: bytes.Repeat([]byte{0x5a}, 100), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := LoadSignatureListFile(tt.input)
+			if tt.wantErr {
+				require.ErrorIs(t, err, ErrInvalidSecureBootKeys)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+// testVariable is a variable of a test variable store.
+type testVariable struct {
+	guid  string
+	name  string
+	state byte
+	data  []byte
+}
+
+// testVariableStore returns a firmware volume of the given size holding a variable store with
+// authenticated or plain variable headers.
+func testVariableStore(size int, authenticated bool, vars ...testVariable) []byte {
+	const fvHeaderLen = 72
+	fv := make([]byte, fvHeaderLen, size)
+	copy(fv[40:], "_FVH")
+	binary.LittleEndian.PutUint16(fv[48:], fvHeaderLen)
+
+	storeGUID, headerLen := efiVariableGUID, variableHeaderLength
+	if authenticated {
+		storeGUID, headerLen = efiAuthenticatedVariableGUID, authenticatedVariableHeaderLength
+	}
+	fv = append(fv, encodeGUID(storeGUID)...)
+	fv = binary.LittleEndian.AppendUint32(fv, uint32(size-fvHeaderLen))
+	fv = append(fv, 0x5a, 0xfe, 0, 0, 0, 0, 0, 0)
+	for _, v := range vars {
+		name := encodeUTF16(v.name + "\x00")
+		hdr := make([]byte, headerLen-24)
+		binary.LittleEndian.PutUint16(hdr, varStartID)
+		hdr[2] = v.state
+		hdr = binary.LittleEndian.AppendUint32(hdr, uint32(len(name)))
+		hdr = binary.LittleEndian.AppendUint32(hdr, uint32(len(v.data)))
+		hdr = append(hdr, encodeGUID(v.guid)...)
+		fv = append(fv, hdr...)
+		fv = append(fv, name...)
+		fv = append(fv, v.data...)
+		for len(fv)%4 != 0 {
+			fv = append(fv, 0xff)
+		}
+	}
+	return append(fv, bytes.Repeat([]byte{0xff}, size-len(fv))...)
+}
+
+func TestParseVariableStore(t *testing.T) {
+	vars := []testVariable{
+		{efiGlobalVariableGUID, "PK", varAdded, []byte{1, 2, 3}},
+		{efiGlobalVariableGUID, "KEK", varAdded & 0xfd, []byte{4}},
+		{efiImageSecurityDatabaseGUID, "db", varAdded & varInDeletedTransition, []byte{5, 6}},
+		{efiImageSecurityDatabaseGUID, "dbx", varAdded, nil},
+	}
+	globalGUID := string(encodeGUID(efiGlobalVariableGUID))
+	dbGUID := string(encodeGUID(efiImageSecurityDatabaseGUID))
+	want := map[string][]byte{
+		globalGUID + "PK": {1, 2, 3},
+		dbGUID + "db":     {5, 6},
+		dbGUID + "dbx":    {},
+	}
+	for _, authenticated := range []bool{true, false} {
+		got, err := parseVariableStore(testVariableStore(0x1000, authenticated, vars...))
+		require.NoError(t, err)
+		require.Equal(t, want, got, "authenticated: %v", authenticated)
+	}
+
+	got, err := parseVariableStore(testVariableStore(0x1000, true))
+	require.NoError(t, err)
+	require.Empty(t, got)
+}
+
+func TestParseVariableStoreErrors(t *testing.T) {
+	valid := testVariableStore(0x1000, true, testVariable{efiGlobalVariableGUID, "PK", varAdded, []byte{1}})
+	unknownFormat := bytes.Clone(valid)
+	unknownFormat[72] ^= 0xff
+	largeStore := bytes.Clone(valid)
+	binary.LittleEndian.PutUint32(largeStore[72+16:], 0x1000)
+	largeVariable := bytes.Clone(valid)
+	binary.LittleEndian.PutUint32(largeVariable[72+varStoreHeaderSize+authenticatedVariableHeaderLength-20:], 0x1000)
+
+	for name, fv := range map[string][]byte{
+		"short":           valid[:40],
+		"no FV signature": make([]byte, 0x1000),
+		"short header":    valid[:72+varStoreHeaderSize-1],
+		"unknown format":  unknownFormat,
+		"store size":      largeStore,
+		"variable size":   largeVariable,
+	} {
+		_, err := parseVariableStore(fv)
+		require.ErrorIs(t, err, ErrInvalidFirmware, name)
+	}
+}
+
+func TestSecureBootConfigFromFirmware(t *testing.T) {
+	pk, kek, db := testSignatureList(1), testSignatureList(2), testSignatureList(3, 4)
+	fw := testFirmware(1, testTdvfSections)
+	// The CFV is the first 128 KiB of the test firmware.
+	copy(fw, testVariableStore(0x20000, true,
+		testVariable{efiGlobalVariableGUID, "PK", varAdded, pk},
+		testVariable{efiGlobalVariableGUID, "KEK", varAdded, kek},
+		testVariable{efiImageSecurityDatabaseGUID, "db", varAdded, db},
+		// Variables of other vendors are ignored.
+		testVariable{efiVariableGUID, "dbx", varAdded, []byte{1}},
+	))
+
+	cfg, err := SecureBootConfigFromFirmware(fw)
+	require.NoError(t, err)
+	require.Equal(t, &SecureBootConfig{PK: pk, KEK: kek, DB: db}, cfg)
+	require.True(t, cfg.Enabled())
+
+	// Without a PK, Secure Boot is disabled.
+	copy(fw, testVariableStore(0x20000, true))
+	cfg, err = SecureBootConfigFromFirmware(fw)
+	require.NoError(t, err)
+	require.False(t, cfg.Enabled())
+}
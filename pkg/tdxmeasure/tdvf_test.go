@@ -0,0 +1,155 @@
+package tdxmeasure
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testSection is a TDVF metadata section entry of a test firmware image.
+type testSection struct {
+	dataOffset, rawDataSize uint32
+	address, size           uint64
+	secType                 tdvfSectionType
+	attributes              uint32
+}
+
+// testTdvfSections are the sections of a minimal TDVF image: its code and variable store, and the
+// memory QEMU provides to it.
+var testTdvfSections = []testSection{
+	{0x0, 0x20000, 0xffc00000, 0x20000, tdvfSectionCfv, 0},
+	{0x20000, 0x40000, 0xfffc0000, 0x40000, tdvfSectionBfv, attributeMrExtend},
+	{0, 0, 0x809000, 0x2000, tdvfSectionTdHob, 0},
+	{0, 0, 0x800000, 0x6000, tdvfSectionTempMem, 0},
+	{0, 0, 0x80b000, 0x2000, tdvfSectionTempMem, 0},
+	{0, 0, 0x811000, 0xf000, tdvfSectionTempMem, 0},
+}
+
+// testFill fills b with deterministic pseudo-random bytes.
+func testFill(b []byte, seed byte) {
+	x := uint32(seed) + 1
+	for i := range b {
+		x = x*1103515245 + 12345
+		b[i] = byte(x >> 16)
+	}
+}
+
+// testFirmware builds a 384 KiB firmware image with the TDVF metadata of the given version and
+// sections, referenced from the OVMF table at the end of the image.
+func testFirmware(version uint32, sections []testSection) []byte {
+	fw := make([]byte, 0x60000)
+	testFill(fw, 7)
+
+	const descOffset = 0x5e000
+	copy(fw[descOffset:], "TDVF")
+	binary.LittleEndian.PutUint32(fw[descOffset+4:], uint32(16+32*len(sections)))
+	binary.LittleEndian.PutUint32(fw[descOffset+8:], version)
+	binary.LittleEndian.PutUint32(fw[descOffset+12:], uint32(len(sections)))
+	for i, s := range sections {
+		entry := fw[descOffset+16+32*i:]
+		binary.LittleEndian.PutUint32(entry, s.dataOffset)
+		binary.LittleEndian.PutUint32(entry[4:], s.rawDataSize)
+		binary.LittleEndian.PutUint64(entry[8:], s.address)
+		binary.LittleEndian.PutUint64(entry[16:], s.size)
+		binary.LittleEndian.PutUint32(entry[24:], uint32(s.secType))
+		binary.LittleEndian.PutUint32(entry[28:], s.attributes)
+	}
+
+	// OVMF table footer, preceded by the TDVF metadata offset entry.
+	end := len(fw) - 32
+	copy(fw[end-16:end], encodeGUID("96b582de-1fb2-45f7-baea-a366c55a082d"))
+	binary.LittleEndian.PutUint16(fw[end-18:end-16], 64)
+	tableEnd := end - 18
+	copy(fw[tableEnd-16:tableEnd], encodeGUID("e47a6535-984a-4798-865e-4685a7bf8ec2"))
+	binary.LittleEndian.PutUint16(fw[tableEnd-18:tableEnd-16], 22)
+	binary.LittleEndian.PutUint32(fw[tableEnd-22:tableEnd-18], uint32(len(fw)-descOffset))
+	return fw
+}
+
+func withSections(extra ...testSection) []testSection {
+	return append(append([]testSection{}, testTdvfSections...), extra...)
+}
+
+func TestParseTdvfMetadata(t *testing.T) {
+	tests := []struct {
+		name     string
+		version  uint32
+		sections []testSection
+		wantErr  bool
+	}{
+		{"valid", 1, testTdvfSections, false},
+		{"later revision", 2, testTdvfSections, false},
+		{"version 0", 0, testTdvfSections, true},
+		{"PermMem", 1, withSections(testSection{0, 0, 0x900000, 0x10000, tdvfSectionPermMem, 0}), false},
+		{"payload loaded by the VMM", 1, withSections(testSection{0, 0, 0x1000000, 0x10000, tdvfSectionPayload, 0}), false},
+		{"extended payload loaded by the VMM", 1, withSections(testSection{0, 0, 0x1000000, 0x10000, tdvfSectionPayload, attributeMrExtend}), true},
+		{"payload with raw data", 1, withSections(testSection{0x40000, 0x1000, 0x1000000, 0x1000, tdvfSectionPayload, attributeMrExtend}), false},
+		{"BFV without raw data", 1, withSections(testSection{0, 0, 0x1000000, 0x1000, tdvfSectionBfv, 0}), true},
+		{"TempMem with raw data", 1, withSections(testSection{0x40000, 0x1000, 0x1000000, 0x1000, tdvfSectionTempMem, 0}), true},
+		{"raw data outside of the firmware", 1, withSections(testSection{0x5f000, 0x2000, 0x1000000, 0x2000, tdvfSectionBfv, 0}), true},
+		{"non-aligned address", 1, withSections(testSection{0, 0, 0x900800, 0x1000, tdvfSectionTempMem, 0}), true},
+		{"two TD HOBs", 1, withSections(testSection{0, 0, 0x900000, 0x1000, tdvfSectionTdHob, 0}), true},
+		{"TD_INFO", 1, withSections(testSection{0, 0, 0x900000, 0x1000, tdvfSectionTdInfo, 0}), true},
+		{"unknown type", 1, withSections(testSection{0, 0, 0x900000, 0x1000, 8, 0}), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			meta, err := parseTdvfMetadata(testFirmware(tt.version, tt.sections))
+			if tt.wantErr {
+				require.ErrorIs(t, err, ErrInvalidFirmware)
+				return
+			}
+			require.NoError(t, err)
+			require.Len(t, meta.sections, len(tt.sections))
+		})
+	}
+}
+
+func TestParseTdvfMetadataMissing(t *testing.T) {
+	fw := make([]byte, 0x10000)
+	_, err := parseTdvfMetadata(fw)
+	require.ErrorIs(t, err, ErrInvalidFirmware)
+}
+
+func TestTdxRAMEntries(t *testing.T) {
+	meta, err := parseTdvfMetadata(testFirmware(1, withSections(
+		testSection{0, 0, 0x900000, 0x10000, tdvfSectionPermMem, attributePageAug},
+	)))
+	require.NoError(t, err)
+	mem, err := newMemoryMap(&Options{MemorySize: 1 << 30})
+	require.NoError(t, err)
+
+	entries, err := tdxRAMEntries(mem, meta)
+	require.NoError(t, err)
+	// Only the TD HOB and TempMem sections are accepted, PermMem stays unaccepted.
+	require.Equal(t, []tdxRAMEntry{
+		{address: 0, length: 0x800000},
+		{address: 0x800000, length: 0x6000, accepted: true},
+		{address: 0x806000, length: 0x3000},
+		{address: 0x809000, length: 0x2000, accepted: true},
+		{address: 0x80b000, length: 0x2000, accepted: true},
+		{address: 0x80d000, length: 0x4000},
+		{address: 0x811000, length: 0xf000, accepted: true},
+		{address: 0x820000, length: 1<<30 - 0x820000},
+	}, entries)
+}
+
+func TestComputeMrtdSkipsAugmentedPages(t *testing.T) {
+	fw := testFirmware(1, testTdvfSections)
+	base, err := parseTdvfMetadata(fw)
+	require.NoError(t, err)
+
+	// PermMem and PAGE.AUG sections aren't added before the TD runs, so they don't change MRTD.
+	for _, s := range []testSection{
+		{0, 0, 0x900000, 0x10000, tdvfSectionPermMem, 0},
+		{0, 0, 0x900000, 0x10000, tdvfSectionTempMem, attributePageAug},
+	} {
+		meta, err := parseTdvfMetadata(testFirmware(1, withSections(s)))
+		require.NoError(t, err)
+		require.Equal(t, base.computeMrtd(fw, MRTDTwoPass), meta.computeMrtd(fw, MRTDTwoPass), "%s", s.secType)
+	}
+	meta, err := parseTdvfMetadata(testFirmware(1, withSections(testSection{0, 0, 0x900000, 0x10000, tdvfSectionTempMem, 0})))
+	require.NoError(t, err)
+	require.NotEqual(t, base.computeMrtd(fw, MRTDTwoPass), meta.computeMrtd(fw, MRTDTwoPass))
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/kvinwang/dstack-mr/pkg/tdxmeasure"
+)
+
+type searchMatch struct {
+	Memory     string `json:"memory"`
+	MemorySize uint64 `json:"memory_size"`
+	CPUCount   uint32 `json:"cpu"`
+}
+
+// runSearch sweeps memory sizes and CPU counts for the configurations matching the RTMR0 of a
+// quote or a given value.
+func runSearch(args []string) {
+	var (
+		cfg        measureConfig
+		quotePath  string
+		rtmr0      hexFlag
+		minMemory  memoryValue
+		maxMemory  memoryValue
+		memoryStep memoryValue
+		maxCPU     uint
+		jsonOutput bool
+	)
+
+	fs := flag.NewFlagSet("search", flag.ExitOnError)
+	cfg.registerFlags(fs)
+	minMemory, maxMemory, memoryStep = 512<<20, 64<<30, 512<<20
+	fs.StringVar(&quotePath, "quote", "", "Path to TDX quote file (raw or hex encoded) to take RTMR0 from")
+	fs.Var(&rtmr0, "rtmr0", "Target RTMR0 (hex), instead of -quote")
+	fs.Var(&minMemory, "min-memory", "Smallest memory size to try")
+	fs.Var(&maxMemory, "max-memory", "Largest memory size to try")
+	fs.Var(&memoryStep, "memory-step", "Memory size increment")
+	fs.UintVar(&maxCPU, "max-cpu", 64, "Largest CPU count to try, all counts from 1 are tried")
+	fs.BoolVar(&jsonOutput, "json", false, "Output in JSON format")
+	fs.Parse(args)
+
+	var target tdxmeasure.Register
+	switch {
+	case quotePath != "" && rtmr0 != nil:
+		fmt.Println("Error: -quote and -rtmr0 are mutually exclusive")
+		os.Exit(1)
+	case quotePath != "":
+		quoteData, err := readQuote(quotePath)
+		if err != nil {
+			fmt.Printf("Error reading quote file: %v\n", err)
+			os.Exit(1)
+		}
+		quote, err := tdxmeasure.ParseQuote(quoteData)
+		if err != nil {
+			fmt.Printf("Error parsing quote: %v\n", err)
+			os.Exit(1)
+		}
+		target = quote.Report.RTMR0
+	case len(rtmr0) == len(target):
+		copy(target[:], rtmr0)
+	default:
+		fmt.Println("Error: a quote or a 48 byte RTMR0 is required")
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	if err := cfg.resolveSearch(maxCPU); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	opts, closeFiles, err := cfg.options()
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	defer closeFiles()
+
+	matches, err := tdxmeasure.SearchRTMR0(opts, target, tdxmeasure.SearchRange{
+		MinMemory:   uint64(minMemory),
+		MaxMemory:   uint64(maxMemory),
+		MemoryStep:  uint64(memoryStep),
+		MaxCPUCount: uint32(maxCPU),
+	})
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	output := []searchMatch{}
+	for _, m := range matches {
+		output = append(output, searchMatch{Memory: formatSize(m.MemorySize), MemorySize: m.MemorySize, CPUCount: m.CPUCount})
+	}
+	if jsonOutput {
+		jsonData, err := json.MarshalIndent(output, "", "  ")
+		if err != nil {
+			fmt.Printf("Error encoding JSON: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println(string(jsonData))
+	} else {
+		for _, m := range output {
+			fmt.Printf("memory=%s cpu=%d\n", m.Memory, m.CPUCount)
+		}
+		if len(output) == 0 {
+			fmt.Println("No matching configuration found")
+		}
+	}
+
+	if len(output) == 0 {
+		os.Exit(1)
+	}
+}
+
+// resolveSearch resolves the inputs of a search up to the given CPU count. The -cpu flag is
+// ignored, the CPU counts that can't be split into the sockets are skipped by the search.
+func (c *measureConfig) resolveSearch(maxCPU uint) error {
+	if err := c.resolveInputs(); err != nil {
+		return err
+	}
+	if c.sockets < 1 || c.sockets > maxCPU {
+		return fmt.Errorf("invalid socket count %d (must be between 1 and the largest CPU count %d)", c.sockets, maxCPU)
+	}
+	return nil
+}
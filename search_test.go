@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveSearch(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     measureConfig
+		maxCPU  uint
+		wantErr bool
+	}{
+		{"default", measureConfig{fwPath: "ovmf.fd", kernelPath: "bzImage", cpuCount: 1, sockets: 1}, 64, false},
+		// The -cpu flag isn't used by the search, the sockets only need to fit the largest count.
+		{"sockets", measureConfig{fwPath: "ovmf.fd", kernelPath: "bzImage", cpuCount: 1, sockets: 2}, 64, false},
+		{"sockets of all CPUs", measureConfig{fwPath: "ovmf.fd", kernelPath: "bzImage", cpuCount: 1, sockets: 64}, 64, false},
+		{"too many sockets", measureConfig{fwPath: "ovmf.fd", kernelPath: "bzImage", cpuCount: 1, sockets: 65}, 64, true},
+		{"no sockets", measureConfig{fwPath: "ovmf.fd", kernelPath: "bzImage", cpuCount: 1, sockets: 0}, 64, true},
+		{"no kernel", measureConfig{fwPath: "ovmf.fd", cpuCount: 1, sockets: 1}, 64, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := tt.cfg
+			err := cfg.resolveSearch(tt.maxCPU)
+			if tt.wantErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+
+	// Measuring a single configuration still needs the CPU count to split into the sockets.
+	cfg := measureConfig{fwPath: "ovmf.fd", kernelPath: "bzImage", cpuCount: 1, sockets: 2}
+	require.Error(t, cfg.resolve())
+	cfg.cpuCount = 4
+	require.NoError(t, cfg.resolve())
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const kiB = 1 << 10
+
+// sizeSuffixes are the size units accepted by QEMU, in increasing powers of 1024.
+const sizeSuffixes = "BKMGTPE"
+
+var sizePattern = regexp.MustCompile(`^([0-9]*)(?:\.([0-9]*))?([BKMGTPEbkmgtpe]?)$`)
+
+// parseSize parses a size the way QEMU's do_strtosz does: a count in decimal or hexadecimal, or
+// a decimal number with an optional fraction followed by one of the suffixes B, K, M, G, T, P or E.
+// A number without suffix is in the default unit, one of the suffixes, like B for qemu_strtosz and
+// M for qemu_strtosz_MiB. A fraction is only allowed if the unit is larger than a byte.
+func parseSize(s string, defaultUnit byte) (uint64, error) {
+	s = strings.TrimSpace(s)
+	defaultShift := 10 * strings.IndexByte(sizeSuffixes, defaultUnit)
+	if hex, ok := strings.CutPrefix(strings.ToLower(s), "0x"); ok {
+		v, err := strconv.ParseUint(hex, 16, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid size %q", s)
+		}
+		if v > ^uint64(0)>>defaultShift {
+			return 0, fmt.Errorf("invalid size %q: value too large", s)
+		}
+		return v << defaultShift, nil
+	}
+
+	m := sizePattern.FindStringSubmatch(s)
+	if m == nil || m[1] == "" && m[2] == "" {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	value, ok := new(big.Rat).SetString(m[1] + "." + m[2] + "0")
+	if !ok {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	shift := defaultShift
+	if m[3] != "" {
+		shift = 10 * strings.IndexByte(sizeSuffixes, strings.ToUpper(m[3])[0])
+	}
+	unit := int64(1) << shift
+	if unit == 1 && !value.IsInt() {
+		return 0, fmt.Errorf("invalid size %q: a fraction requires a unit", s)
+	}
+
+	// Round half a byte up like QEMU.
+	value.Mul(value, new(big.Rat).SetInt64(unit))
+	value.Add(value, big.NewRat(1, 2))
+	bytes := new(big.Int).Quo(value.Num(), value.Denom())
+	if !bytes.IsUint64() {
+		return 0, fmt.Errorf("invalid size %q: value too large", s)
+	}
+	return bytes.Uint64(), nil
+}
+
+// formatSize formats a size with the largest unit it is a multiple of.
+func formatSize(size uint64) string {
+	i := 0
+	for i < len(sizeSuffixes)-1 && size != 0 && size%kiB == 0 {
+		size /= kiB
+		i++
+	}
+	if i == 0 {
+		return strconv.FormatUint(size, 10)
+	}
+	return fmt.Sprintf("%d%c", size, sizeSuffixes[i])
+}
+
+// parseMemoryOption parses the argument of QEMU's -m option, [size=]SIZE[,slots=N][,maxmem=SIZE],
+// and returns the memory size in bytes. A size without a suffix is in MiB and the size is rounded
+// up to 8 KiB, as QEMU does. Memory hotplug (maxmem larger than the size) is not supported.
+func parseMemoryOption(s string) (uint64, error) {
+	var sizeStr, maxmemStr string
+	var hasSlots bool
+	var slots uint64
+	for i, opt := range strings.Split(s, ",") {
+		key, value, ok := strings.Cut(opt, "=")
+		if !ok && i == 0 {
+			key, value = "size", opt
+		}
+		var err error
+		switch key {
+		case "size":
+			sizeStr = value
+		case "slots":
+			hasSlots = true
+			if slots, err = strconv.ParseUint(value, 10, 64); err != nil {
+				return 0, fmt.Errorf("invalid memory slots %q", value)
+			}
+		case "maxmem":
+			maxmemStr = value
+		default:
+			return 0, fmt.Errorf("invalid memory option %q", opt)
+		}
+	}
+	if sizeStr == "" {
+		return 0, errors.New("missing memory size")
+	}
+
+	size, err := parseSize(sizeStr, 'M')
+	if err != nil {
+		return 0, err
+	}
+	if size > ^uint64(0)-(8*kiB-1) {
+		return 0, fmt.Errorf("invalid size %q: value too large", sizeStr)
+	}
+	size = (size + 8*kiB - 1) &^ (8*kiB - 1)
+	if size == 0 {
+		return 0, errors.New("memory size must not be zero")
+	}
+
+	if maxmemStr == "" {
+		if hasSlots {
+			return 0, errors.New("memory slots specified without maxmem")
+		}
+		return size, nil
+	}
+	maxmem, err := parseSize(maxmemStr, 'B')
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case maxmem < size:
+		return 0, fmt.Errorf("maximum memory size (%#x) must be at least the initial memory size (%#x)", maxmem, size)
+	case slots != 0 && maxmem == size:
+		return 0, fmt.Errorf("memory slots were specified but maximum memory size (%#x) is equal to the initial memory size (%#x)", maxmem, size)
+	case maxmem > size:
+		return 0, errors.New("memory hotplug (maxmem larger than the memory size) is not supported")
+	}
+	return size, nil
+}
+
+// memoryValue is a flag holding the memory size given as QEMU's -m option.
+type memoryValue uint64
+
+func (m *memoryValue) String() string {
+	return formatSize(uint64(*m))
+}
+
+func (m *memoryValue) Set(value string) error {
+	size, err := parseMemoryOption(value)
+	if err != nil {
+		return err
+	}
+	*m = memoryValue(size)
+	return nil
+}
+
+// sizeValue is a flag holding a size in QEMU's size syntax.
+type sizeValue uint64
+
+func (s *sizeValue) String() string {
+	return formatSize(uint64(*s))
+}
+
+func (s *sizeValue) Set(value string) error {
+	size, err := parseSize(value, 'B')
+	if err != nil {
+		return err
+	}
+	*s = sizeValue(size)
+	return nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		input       string
+		defaultUnit byte
+		want        uint64
+		wantErr     bool
+	}{
+		{"0", 'B', 0, false},
+		{"4096", 'B', 4096, false},
+		{" 12 ", 'B', 12, false},
+		{"0x1000", 'B', 0x1000, false},
+		{"0X1f", 'B', 0x1f, false},
+		{"1K", 'B', 1 << 10, false},
+		{"2m", 'B', 2 << 20, false},
+		{"1.5G", 'B', 3 << 29, false},
+		{".5K", 'B', 512, false},
+		{"1.K", 'B', 1 << 10, false},
+		{"512B", 'B', 512, false},
+		{"1E", 'B', 1 << 60, false},
+		{"16E", 'B', 0, true},
+		{"1.0000001K", 'B', 1024, false},
+		{"0.0009765625K", 'B', 1, false},
+		{"0.00048828125K", 'B', 1, false}, // Half a byte is rounded up.
+		{"18446744073709551615", 'B', 1<<64 - 1, false},
+		{"18446744073709551616", 'B', 0, true},
+		{"1.5", 'B', 0, true},
+		{"1.5B", 'B', 0, true},
+		{"", 'B', 0, true},
+		{".", 'B', 0, true},
+		{"K", 'B', 0, true},
+		{"-1", 'B', 0, true},
+		{"1KiB", 'B', 0, true},
+		{"1 K", 'B', 0, true},
+		{"0x", 'B', 0, true},
+		{"0x1.5", 'B', 0, true},
+		{"2048", 'M', 2 << 30, false},
+		{"1.5", 'M', 3 << 19, false},
+		{"0x10", 'M', 16 << 20, false},
+		{"4G", 'M', 4 << 30, false},
+		{"4096B", 'M', 4096, false},
+		{"17592186044416", 'M', 0, true},
+		{"0x100000000000", 'M', 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseSize(tt.input, tt.defaultUnit)
+		if tt.wantErr {
+			require.Error(t, err, "%q", tt.input)
+			continue
+		}
+		require.NoError(t, err, "%q", tt.input)
+		require.Equal(t, tt.want, got, "%q in %c", tt.input, tt.defaultUnit)
+	}
+}
+
+func TestParseMemoryOption(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    uint64
+		wantErr string
+	}{
+		{input: "2048", want: 2 << 30},
+		{input: "2G", want: 2 << 30},
+		{input: "1.5", want: 3 << 19},
+		{input: "1.5G", want: 3 << 29},
+		{input: "0x800", want: 2 << 30},
+		{input: "size=4G", want: 4 << 30},
+		{input: "4G,slots=0,maxmem=4G", want: 4 << 30},
+		{input: "size=4G,maxmem=4096M", want: 4 << 30},
+		{input: "1000000B", want: 1007616},
+		{input: "1K", want: 8 << 10},
+		{input: "", wantErr: "missing memory size"},
+		{input: "slots=2", wantErr: "missing memory size"},
+		{input: "0", wantErr: "must not be zero"},
+		{input: "2G,slots=x", wantErr: "invalid memory slots"},
+		{input: "2G,cpus=2", wantErr: "invalid memory option"},
+		{input: "2G,slots=2", wantErr: "without maxmem"},
+		{input: "4G,maxmem=2G", wantErr: "must be at least"},
+		{input: "4G,slots=2,maxmem=4G", wantErr: "equal to the initial memory size"},
+		{input: "4G,slots=2,maxmem=8G", wantErr: "not supported"},
+		{input: "1.5X", wantErr: "invalid size"},
+		{input: "16E", wantErr: "value too large"},
+	}
+	for _, tt := range tests {
+		got, err := parseMemoryOption(tt.input)
+		if tt.wantErr != "" {
+			require.ErrorContains(t, err, tt.wantErr, "%q", tt.input)
+			continue
+		}
+		require.NoError(t, err, "%q", tt.input)
+		require.Equal(t, tt.want, got, "%q", tt.input)
+	}
+}
+
+func TestFormatSize(t *testing.T) {
+	for size, want := range map[uint64]string{
+		0:             "0",
+		1000:          "1000",
+		1 << 10:       "1K",
+		1536 << 20:    "1536M",
+		2 << 30:       "2G",
+		3 << 40:       "3T",
+		1<<30 + 1<<10: "1048577K",
+		1 << 60:       "1E",
+		1<<64 - 1<<10: "18014398509481983K",
+	} {
+		require.Equal(t, want, formatSize(size))
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/kvinwang/dstack-mr/pkg/tdxmeasure"
+)
+
+const (
+	fieldMatch      = "match"
+	fieldMismatch   = "mismatch"
+	fieldNotChecked = "-"
+)
+
+type verifyField struct {
+	Name     string `json:"name"`
+	Status   string `json:"status"`
+	Quote    string `json:"quote"`
+	Expected string `json:"expected,omitempty"`
+}
+
+type verifyOutput struct {
+	Image  *imageInfo    `json:"image,omitempty"`
+	Match  bool          `json:"match"`
+	Fields []verifyField `json:"fields"`
+}
+
+// readQuote reads a TDX quote from a file, which may contain either the raw quote or its hex
+// encoding.
+func readQuote(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	text := strings.TrimPrefix(string(bytes.TrimSpace(data)), "0x")
+	if decoded, err := hex.DecodeString(text); err == nil {
+		return decoded, nil
+	}
+	return data, nil
+}
+
+// hexFlag is a flag holding an optional hex-encoded value.
+type hexFlag []byte
+
+func (h *hexFlag) String() string {
+	return hex.EncodeToString(*h)
+}
+
+func (h *hexFlag) Set(value string) error {
+	decoded, err := hex.DecodeString(strings.TrimPrefix(value, "0x"))
+	if err != nil {
+		return fmt.Errorf("invalid hex value: %w", err)
+	}
+	*h = decoded
+	return nil
+}
+
+// compareField compares a quote field with its expected value. A nil expected value means that
+// the field is not checked.
+func compareField(name string, quote, expected []byte) verifyField {
+	f := verifyField{
+		Name:   name,
+		Status: fieldNotChecked,
+		Quote:  hex.EncodeToString(quote),
+	}
+	if expected != nil {
+		f.Expected = hex.EncodeToString(expected)
+		f.Status = fieldMismatch
+		if bytes.Equal(quote, expected) {
+			f.Status = fieldMatch
+		}
+	}
+	return f
+}
+
+// runVerify parses a TDX quote and compares it with the measurements calculated from the inputs.
+func runVerify(args []string) {
+	var (
+		cfg           measureConfig
+		quotePath     string
+		jsonOutput    bool
+		rtmr3         hexFlag
+		mrConfigID    hexFlag
+		mrOwner       hexFlag
+		mrOwnerConfig hexFlag
+	)
+
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	cfg.registerFlags(fs)
+	fs.StringVar(&quotePath, "quote", "", "Path to TDX quote file (raw or hex encoded)")
+	fs.BoolVar(&jsonOutput, "json", false, "Output in JSON format")
+	fs.Var(&rtmr3, "rtmr3", "Expected RTMR3 (hex, not checked if empty unless RTMR3 runtime events are given)")
+	fs.Var(&mrConfigID, "mrconfigid", "Expected MRCONFIGID (hex, not checked if empty)")
+	fs.Var(&mrOwner, "mrowner", "Expected MROWNER (hex, not checked if empty)")
+	fs.Var(&mrOwnerConfig, "mrownerconfig", "Expected MROWNERCONFIG (hex, not checked if empty)")
+	fs.Parse(args)
+
+	if quotePath == "" {
+		fmt.Println("Error: quote path is required")
+		fs.Usage()
+		os.Exit(1)
+	}
+	if rtmr3 != nil && cfg.hasRuntimeEvents() {
+		fmt.Println("Error: -rtmr3 and RTMR3 runtime events are mutually exclusive")
+		os.Exit(1)
+	}
+
+	quoteData, err := readQuote(quotePath)
+	if err != nil {
+		fmt.Printf("Error reading quote file: %v\n", err)
+		os.Exit(1)
+	}
+	quote, err := tdxmeasure.ParseQuote(quoteData)
+	if err != nil {
+		fmt.Printf("Error parsing quote: %v\n", err)
+		os.Exit(1)
+	}
+
+	report := &quote.Report
+
+	// With several MRTD variants, compare with the one matching the quote, if any.
+	variants := cfg.resolveAndMeasure(fs)
+	measurements := variants[0]
+	for _, m := range variants {
+		if m.MRTD == report.MRTD {
+			measurements = m
+			break
+		}
+	}
+	if cfg.hasRuntimeEvents() {
+		rtmr3 = measurements.RTMR3[:]
+	}
+	fields := []verifyField{
+		compareField("MRTD", report.MRTD[:], measurements.MRTD[:]),
+		compareField("RTMR0", report.RTMR0[:], measurements.RTMR0[:]),
+		compareField("RTMR1", report.RTMR1[:], measurements.RTMR1[:]),
+		compareField("RTMR2", report.RTMR2[:], measurements.RTMR2[:]),
+		compareField("RTMR3", report.RTMR3[:], rtmr3),
+		compareField("MRCONFIGID", report.MrConfigID[:], mrConfigID),
+		compareField("MROWNER", report.MrOwner[:], mrOwner),
+		compareField("MROWNERCONFIG", report.MrOwnerConfig[:], mrOwnerConfig),
+		compareField("MRSEAM", report.MrSeam[:], nil),
+		compareField("MRSIGNERSEAM", report.MrSignerSeam[:], nil),
+		compareField("TDATTRIBUTES", report.TdAttributes[:], nil),
+		compareField("XFAM", report.Xfam[:], nil),
+		compareField("REPORTDATA", report.ReportData[:], nil),
+	}
+
+	output := verifyOutput{Image: cfg.metadata.imageInfo(), Match: true, Fields: fields}
+	for _, f := range fields {
+		if f.Status == fieldMismatch {
+			output.Match = false
+		}
+	}
+
+	if jsonOutput {
+		jsonData, err := json.MarshalIndent(output, "", "  ")
+		if err != nil {
+			fmt.Printf("Error encoding JSON: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println(string(jsonData))
+	} else {
+		fmt.Printf("Quote version: %d\n", quote.Version)
+		printImageInfo(output.Image)
+		for _, f := range fields {
+			fmt.Printf("%-14s %-8s %s\n", f.Name, f.Status, f.Quote)
+			if f.Status == fieldMismatch {
+				fmt.Printf("%-14s %-8s %s\n", "", "expected", f.Expected)
+			}
+		}
+		if output.Match {
+			fmt.Println("Result: OK")
+		} else {
+			fmt.Println("Result: MISMATCH")
+		}
+	}
+
+	if !output.Match {
+		os.Exit(1)
+	}
+}
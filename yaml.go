@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// yamlToJSON converts a YAML document to JSON, so that it can be decoded like the JSON inputs,
+// with unknown fields rejected. JSON documents are returned as is, JSON being a subset of YAML.
+// Mapping keys other than strings are converted to strings.
+func yamlToJSON(data []byte) ([]byte, error) {
+	if json.Valid(data) {
+		return data, nil
+	}
+	var value any
+	if err := yaml.Unmarshal(data, &value); err != nil {
+		return nil, err
+	}
+	value, err := yamlToJSONValue(value)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(value)
+}
+
+// yamlToJSONValue converts the mappings of a decoded YAML value to map[string]any.
+func yamlToJSONValue(v any) (any, error) {
+	switch v := v.(type) {
+	case map[string]any:
+		for key, value := range v {
+			var err error
+			if v[key], err = yamlToJSONValue(value); err != nil {
+				return nil, err
+			}
+		}
+		return v, nil
+	case map[any]any:
+		m := make(map[string]any, len(v))
+		for key, value := range v {
+			var name string
+			switch key := key.(type) {
+			case nil:
+				name = "null"
+			case time.Time:
+				name = key.Format(time.RFC3339Nano)
+			case map[string]any, map[any]any, []any:
+				return nil, fmt.Errorf("unsupported mapping key %v", key)
+			default:
+				name = fmt.Sprint(key)
+			}
+			if _, ok := m[name]; ok {
+				return nil, fmt.Errorf("duplicate mapping key %q", name)
+			}
+			var err error
+			if m[name], err = yamlToJSONValue(value); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	case []any:
+		for i, item := range v {
+			var err error
+			if v[i], err = yamlToJSONValue(item); err != nil {
+				return nil, err
+			}
+		}
+		return v, nil
+	case float64:
+		if math.IsInf(v, 0) || math.IsNaN(v) {
+			return nil, fmt.Errorf("unsupported number %v", v)
+		}
+	case time.Time:
+		return v.Format(time.RFC3339Nano), nil
+	}
+	return v, nil
+}
+
+// jsonField is a field of a JSON object decoded by decodeOrderedJSON.
+type jsonField struct {
+	key   string
+	value any
+}
+
+// decodeOrderedJSON decodes a JSON document like json.Unmarshal with numbers kept as written,
+// except that objects are decoded as []jsonField to keep the order of their fields.
+func decodeOrderedJSON(data []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	return decodeOrderedJSONValue(dec)
+}
+
+func decodeOrderedJSONValue(dec *json.Decoder) (any, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch tok {
+	case json.Delim('{'):
+		fields := []jsonField{}
+		for dec.More() {
+			key, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			value, err := decodeOrderedJSONValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, jsonField{key: key.(string), value: value})
+		}
+		_, err = dec.Token()
+		return fields, err
+	case json.Delim('['):
+		items := []any{}
+		for dec.More() {
+			item, err := decodeOrderedJSONValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		_, err = dec.Token()
+		return items, err
+	}
+	return tok, nil
+}
+
+// jsonToYAML converts a JSON document to block style YAML, keeping the order of the object
+// fields. Strings are always double quoted so that hex values aren't read back as numbers.
+func jsonToYAML(data []byte) ([]byte, error) {
+	value, err := decodeOrderedJSON(data)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(yamlNode(value)); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// yamlNode returns the YAML node of a value decoded by decodeOrderedJSON.
+func yamlNode(v any) *yaml.Node {
+	switch v := v.(type) {
+	case []jsonField:
+		node := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		for _, f := range v {
+			node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: f.key}, yamlNode(f.value))
+		}
+		return node
+	case []any:
+		node := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+		for _, item := range v {
+			node.Content = append(node.Content, yamlNode(item))
+		}
+		return node
+	case string:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: v, Style: yaml.DoubleQuotedStyle}
+	case json.Number:
+		tag := "!!int"
+		if strings.ContainsAny(string(v), ".eE") {
+			tag = "!!float"
+		}
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: tag, Value: string(v)}
+	case bool:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!bool", Value: strconv.FormatBool(v)}
+	}
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null", Value: "null"}
+}
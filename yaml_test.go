@@ -0,0 +1,78 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestYAMLToJSON(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"empty", "", `null`},
+		{"json", `{"memory": [2048, "4G"], "cpu": [1]}`, `{"memory": [2048, "4G"], "cpu": [1]}`},
+		{"block", `
+images:
+  - name: dstack-0.5.0   # comment
+    metadata: dstack-0.5.0/metadata.json
+memory: [2G, 4G, 1.5]
+cpu:
+- 1
+- 2
+`, `{"cpu":[1,2],"images":[{"metadata":"dstack-0.5.0/metadata.json","name":"dstack-0.5.0"}],"memory":["2G","4G",1.5]}`},
+		{"scalars", `
+null: ~
+bool: true
+int: 0x10
+quoted: "0x10"
+single: 'it''s'
+escaped: "a\tb\u00e9"
+date: 2024-01-02
+`, `{"bool":true,"date":"2024-01-02T00:00:00Z","escaped":"a\tbé","int":16,"null":null,"quoted":"0x10","single":"it's"}`},
+		{"anchors", `
+base: &base
+  fw: ovmf.fd
+  kernel: bzImage
+images:
+  - <<: *base
+    name: a
+  - <<: *base
+    name: b
+    kernel: other
+`, `{"base":{"fw":"ovmf.fd","kernel":"bzImage"},"images":[{"fw":"ovmf.fd","kernel":"bzImage","name":"a"},{"fw":"ovmf.fd","kernel":"other","name":"b"}]}`},
+		{"block scalars", `
+literal: |
+  console=ttyS0
+  initrd=initrd
+folded: >-
+  console=ttyS0
+  initrd=initrd
+`, `{"folded":"console=ttyS0 initrd=initrd","literal":"console=ttyS0\ninitrd=initrd\n"}`},
+		{"flow mappings", `images: [{name: a, metadata: a.json}, {name: "b, c"}]`, `{"images":[{"metadata":"a.json","name":"a"},{"name":"b, c"}]}`},
+		{"non-string keys", "1: one\ntrue: yes\n", `{"1":"one","true":"yes"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := yamlToJSON([]byte(tt.input))
+			require.NoError(t, err)
+			require.Equal(t, tt.want, string(got))
+		})
+	}
+}
+
+func TestYAMLToJSONErrors(t *testing.T) {
+	for _, input := range []string{
+		"a: [1, 2",
+		"a: 1\na: 2\n",
+		"a:\n\t- 1\n",
+		"a: *missing\n",
+		"? [1, 2]\n: x\n",
+		"a: .nan\n",
+	} {
+		_, err := yamlToJSON([]byte(input))
+		require.Error(t, err, "%q", input)
+	}
+}